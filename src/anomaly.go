@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// checkChangeAnomaly fails if more than max_changed_percent of the files of
+// the previous snapshot were modified or deleted by this run. Mass changes
+// like these are typical of ransomware encrypting the source, and such a
+// snapshot must not become the latest one or push good history out.
+func (b *Backup) checkChangeAnomaly(changes []snapshotChange, lastBackup string) error {
+	if b.config.MaxChangedPercent <= 0 || b.config.DryRun || b.isSSHPath(b.config.Destination) || lastBackup == "(none)" {
+		return nil
+	}
+
+	previous, err := b.loadCatalog(lastBackup)
+	if err != nil {
+		return nil // Nothing to compare against
+	}
+	files := make(map[string]bool)
+	for _, e := range previous {
+		if !e.Mode.IsDir() {
+			files[e.Path] = true
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	modified, deleted := 0, 0
+	for _, c := range changes {
+		if !files[c.Path] {
+			continue
+		}
+		switch c.Kind {
+		case "modified":
+			modified++
+		case "deleted":
+			deleted++
+		}
+	}
+
+	percent := (modified + deleted) * 100 / len(files)
+	b.log("Changed files: %d%% of %d (%d modified, %d deleted, max_changed_percent: %d%%)",
+		percent, len(files), modified, deleted, b.config.MaxChangedPercent)
+	if percent > b.config.MaxChangedPercent {
+		return fmt.Errorf("%d%% of the files changed since %s (%d modified, %d deleted), more than max_changed_percent %d%%",
+			percent, lastBackup, modified, deleted, b.config.MaxChangedPercent)
+	}
+	return nil
+}
+
+// alert runs the configured alert_command with the level and message in the
+// environment (BACKUP_ALERT_LEVEL, BACKUP_ALERT_MESSAGE).
+func (b *Backup) alert(level, message string) {
+	if b.config.AlertCommand == "" {
+		return
+	}
+	cmd := exec.Command("sh", "-c", b.config.AlertCommand)
+	cmd.Env = b.childEnv("BACKUP_ALERT_LEVEL="+level, "BACKUP_ALERT_MESSAGE="+message)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		b.log("Warning: alert command failed: %v: %s", err, output)
+	}
+}
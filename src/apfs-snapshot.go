@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Volume holding the user data on macOS 10.15 and later. /Users,
+// /Applications, /Library and /private are firmlinks into it.
+const DataVolume = "/System/Volumes/Data"
+
+// appDatabase is a database an app keeps open while it runs. Copied from
+// the live filesystem it may not be consistent.
+type appDatabase struct {
+	App     string
+	Pattern string // Glob of the absolute path
+}
+
+var appDatabases = []appDatabase{
+	{"Photos", "/Users/*/Pictures/*.photoslibrary/database/Photos.sqlite"},
+	{"Mail", "/Users/*/Library/Mail/V*/MailData/Envelope Index"},
+	{"Notes", "/Users/*/Library/Group Containers/group.com.apple.notes/NoteStore.sqlite"},
+	{"Messages", "/Users/*/Library/Messages/chat.db"},
+	{"Calendar", "/Users/*/Library/Calendars/Calendar.sqlitedb"},
+}
+
+// tmutilDateRe matches the date tmutil prints for a new local snapshot.
+var tmutilDateRe = regexp.MustCompile(`\d{4}-\d{2}-\d{2}-\d{6}`)
+
+// warnOpenDatabases points out app databases inside the sources that are
+// copied while the app may be writing them, unless apfs_snapshot or
+// quiesce_apps take care of them.
+func (b *Backup) warnOpenDatabases() {
+	if b.config.APFSSnapshot || len(b.config.QuiesceApps) > 0 {
+		return
+	}
+	for _, db := range appDatabases {
+		matches, _ := filepath.Glob(db.Pattern)
+		for _, match := range matches {
+			for _, source := range b.sourcePaths() {
+				if !b.isSSHPath(source) && isWithin(match, resolvePath(source)) {
+					b.log("Warning: %s database %s is copied while %s may be writing it - consider apfs_snapshot or quiesce_apps", db.App, match, db.App)
+				}
+			}
+		}
+	}
+}
+
+// appRunning reports whether a macOS app is running.
+func appRunning(app string) bool {
+	output, err := exec.Command("osascript", "-e", fmt.Sprintf("application %q is running", app)).Output()
+	return err == nil && strings.TrimSpace(string(output)) == "true"
+}
+
+// quiesceApps quits the running apps of quiesce_apps, so their databases
+// are closed, and returns the apps to reopen afterwards.
+func (b *Backup) quiesceApps() []string {
+	var quit []string
+	for _, app := range b.config.QuiesceApps {
+		if !appRunning(app) {
+			continue
+		}
+		b.log("Quitting %s for a consistent copy of its data", app)
+		if err := exec.Command("osascript", "-e", fmt.Sprintf("tell application %q to quit", app)).Run(); err != nil {
+			b.log("Warning: failed to quit %s: %v", app, err)
+			continue
+		}
+		quit = append(quit, app)
+		for deadline := time.Now().Add(30 * time.Second); appRunning(app) && time.Now().Before(deadline); {
+			time.Sleep(time.Second)
+		}
+		if appRunning(app) {
+			b.log("Warning: %s is still running (unsaved changes?), its data may be inconsistent", app)
+		}
+	}
+	return quit
+}
+
+// reopenApps starts the apps quit by quiesceApps again, in the background.
+func (b *Backup) reopenApps(apps []string) {
+	for _, app := range apps {
+		if err := exec.Command("open", "-g", "-a", app).Run(); err != nil {
+			b.log("Warning: failed to reopen %s: %v", app, err)
+		}
+	}
+}
+
+// createAPFSSnapshot takes a local APFS snapshot of the data volume and
+// mounts it read-only, so the sources are read in the state of a single
+// moment instead of while apps keep writing. The apps of quiesce_apps are
+// only closed while the snapshot is taken.
+func (b *Backup) createAPFSSnapshot() error {
+	quit := b.quiesceApps()
+	output, err := exec.Command("tmutil", "localsnapshot").CombinedOutput()
+	b.reopenApps(quit)
+	if err != nil {
+		return fmt.Errorf("tmutil localsnapshot failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	date := tmutilDateRe.FindString(string(output))
+	if date == "" {
+		return fmt.Errorf("unexpected output of tmutil localsnapshot: %s", strings.TrimSpace(string(output)))
+	}
+	b.apfsSnapshot = date
+
+	// Created after the snapshot, so it is not part of it
+	mount, err := os.MkdirTemp("", "go-rsync-backup-apfs-")
+	if err != nil {
+		return err
+	}
+	name := "com.apple.TimeMachine." + date + ".local"
+	if output, err := exec.Command("mount_apfs", "-o", "rdonly,nobrowse", "-s", name, DataVolume, mount).CombinedOutput(); err != nil {
+		os.Remove(mount)
+		return fmt.Errorf("failed to mount snapshot %s: %v: %s", name, err, strings.TrimSpace(string(output)))
+	}
+	b.snapshotRoot = mount
+	b.log("Reading sources from APFS snapshot %s", name)
+	return nil
+}
+
+// removeAPFSSnapshot unmounts and deletes the snapshot of createAPFSSnapshot.
+func (b *Backup) removeAPFSSnapshot() {
+	if b.snapshotRoot != "" {
+		if output, err := exec.Command("umount", b.snapshotRoot).CombinedOutput(); err != nil {
+			b.log("Warning: failed to unmount %s: %v: %s", b.snapshotRoot, err, strings.TrimSpace(string(output)))
+		} else {
+			os.Remove(b.snapshotRoot)
+		}
+		b.snapshotRoot = ""
+	}
+	if b.apfsSnapshot != "" {
+		if output, err := exec.Command("tmutil", "deletelocalsnapshots", b.apfsSnapshot).CombinedOutput(); err != nil {
+			b.log("Warning: failed to delete local snapshot %s: %v: %s", b.apfsSnapshot, err, strings.TrimSpace(string(output)))
+		}
+		b.apfsSnapshot = ""
+	}
+}
+
+// snapshotSource returns where rsync reads a source from: inside the
+// mounted APFS snapshot if the source lies on the data volume, the live
+// path otherwise (other volumes, remote sources, paths through symlinks).
+// The "/./" keeps the path inside the snapshot the same with --relative.
+func (b *Backup) snapshotSource(source string) string {
+	if b.snapshotRoot == "" || b.isSSHPath(source) || resolvePath(source) != filepath.Clean(source) {
+		return source
+	}
+	var st, data syscall.Stat_t
+	if syscall.Stat(source, &st) != nil || syscall.Stat(DataVolume, &data) != nil || st.Dev != data.Dev {
+		b.log("Source %s is not on the data volume, reading it live", source)
+		return source
+	}
+	return b.snapshotRoot + "/." + filepath.Clean(source)
+}
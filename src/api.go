@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// APIVersion is the version of the machine API served by serve-api. It is
+// raised on incompatible changes only; fields may be added at any time.
+const APIVersion = 1
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcServerError    = -32000
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// apiServer answers requests of a companion app (menu bar, tray icon) on
+// a Unix socket. Backups it triggers run as child processes with the same
+// global options, so a crash or cancel never takes the server down.
+type apiServer struct {
+	b          *Backup
+	globalArgs []string // Options before the command, e.g. -config
+	gid        string   // Group of api_socket_group, empty if not set
+
+	mu      sync.Mutex
+	run     *exec.Cmd // Backup started through the API, nil if none
+	started time.Time
+	last    map[string]any // Outcome of the last run started through the API
+}
+
+// runServeAPI serves the machine API until interrupted.
+func (b *Backup) runServeAPI(args []string) error {
+	fs := flag.NewFlagSet("serve-api", flag.ExitOnError)
+	socket := fs.String("socket", b.config.APISocket, "Unix socket to listen on")
+	parseArgs(fs, args)
+
+	if *socket == "" {
+		return fmt.Errorf("no socket given (api_socket or --socket)")
+	}
+	// A socket left behind by a crashed server refuses connections
+	if conn, err := net.Dial("unix", *socket); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another server", *socket)
+	}
+	os.Remove(*socket)
+	s := &apiServer{b: b, globalArgs: os.Args[1 : len(os.Args)-len(args)-1]}
+	if b.config.APISocketGroup != "" {
+		group, err := user.LookupGroup(b.config.APISocketGroup)
+		if err != nil {
+			return fmt.Errorf("api_socket_group: %v", err)
+		}
+		s.gid = group.Gid
+	}
+	if err := os.MkdirAll(filepath.Dir(*socket), 0755); err != nil {
+		return fmt.Errorf("failed to create socket directory: %v", err)
+	}
+
+	// Only the user running the server, and the members of
+	// api_socket_group, may connect: trigger and cancel act with the
+	// server's rights
+	oldMask := syscall.Umask(0077)
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: *socket, Net: "unix"})
+	syscall.Umask(oldMask)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", *socket, err)
+	}
+	defer os.Remove(*socket)
+	if s.gid != "" {
+		gid, _ := strconv.Atoi(s.gid)
+		if err := os.Chown(*socket, -1, gid); err != nil {
+			return fmt.Errorf("failed to set group of %s: %v", *socket, err)
+		}
+		if err := os.Chmod(*socket, 0660); err != nil {
+			return fmt.Errorf("failed to set mode of %s: %v", *socket, err)
+		}
+	}
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		listener.Close()
+	}()
+
+	fmt.Printf("Serving API version %d on %s\n", APIVersion, *socket)
+	for {
+		conn, err := listener.AcceptUnix()
+		if err != nil {
+			return nil // A running backup finishes on its own
+		}
+		go s.serve(conn)
+	}
+}
+
+// serve answers newline-delimited JSON-RPC requests until the client
+// closes the connection.
+func (s *apiServer) serve(conn *net.UnixConn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	encoder := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req rpcRequest
+		resp := rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null")}
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp.Error = &rpcError{rpcParseError, err.Error()}
+		} else if req.JSONRPC != "2.0" || req.Method == "" {
+			resp.Error = &rpcError{rpcInvalidRequest, "not a JSON-RPC 2.0 request"}
+		} else {
+			if req.ID != nil {
+				resp.ID = req.ID
+			}
+			resp.Result, resp.Error = s.call(conn, req.Method, req.Params)
+		}
+		if err := encoder.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// call runs one API method for the client on conn.
+func (s *apiServer) call(conn *net.UnixConn, method string, params json.RawMessage) (any, *rpcError) {
+	switch method {
+	case "version":
+		return map[string]any{"api_version": APIVersion, "app_version": AppVersion}, nil
+	case "status":
+		return s.status(), nil
+	case "trigger":
+		if err := s.authorize(conn); err != nil {
+			return nil, &rpcError{rpcServerError, err.Error()}
+		}
+		if err := s.trigger(); err != nil {
+			return nil, &rpcError{rpcServerError, err.Error()}
+		}
+		return s.status(), nil
+	case "cancel":
+		if err := s.authorize(conn); err != nil {
+			return nil, &rpcError{rpcServerError, err.Error()}
+		}
+		if !s.cancel() {
+			return nil, &rpcError{rpcServerError, "no backup started through the API is running"}
+		}
+		return s.status(), nil
+	case "history":
+		var p struct {
+			Limit int `json:"limit"`
+		}
+		if len(params) > 0 {
+			if err := json.Unmarshal(params, &p); err != nil || p.Limit < 0 {
+				return nil, &rpcError{rpcInvalidParams, "params must be {\"limit\": n}"}
+			}
+		}
+		if p.Limit == 0 {
+			p.Limit = 10
+		}
+		entries, err := s.b.readHistory(p.Limit)
+		if err != nil {
+			return nil, &rpcError{rpcServerError, err.Error()}
+		}
+		return map[string]any{"runs": entries}, nil
+	}
+	return nil, &rpcError{rpcMethodNotFound, fmt.Sprintf("unknown method %q", method)}
+}
+
+// authorize checks that the client on conn may start and cancel backups:
+// root, the user running the server and members of api_socket_group. The
+// socket's mode already keeps out everyone else; this check does not
+// depend on it.
+func (s *apiServer) authorize(conn *net.UnixConn) error {
+	uid, err := peerUID(conn)
+	if err != nil {
+		return fmt.Errorf("cannot identify the client: %v", err)
+	}
+	if uid == 0 || uid == os.Geteuid() {
+		return nil
+	}
+	if s.gid != "" {
+		if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+			if gids, err := u.GroupIds(); err == nil && slices.Contains(gids, s.gid) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("user %d may not start or cancel backups", uid)
+}
+
+// status describes the repository and whether a backup is running. Runs
+// started outside the API (schedule, terminal) are seen through the lock.
+func (s *apiServer) status() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := map[string]any{
+		"api_version": APIVersion,
+		"destination": s.b.config.Destination,
+		"running":     s.run != nil,
+		"triggered":   s.run != nil,
+	}
+	if s.run != nil {
+		status["run_started"] = s.started.Format(time.RFC3339)
+	} else if _, err := os.Stat(s.b.config.LockFile); err == nil {
+		status["running"] = true
+	}
+	if name, age, err := s.b.newestSnapshotAge(); err == nil {
+		status["latest_snapshot"] = name
+		status["latest_age_seconds"] = int(age.Seconds())
+	}
+	if s.last != nil {
+		status["last_triggered_run"] = s.last
+	}
+	return status
+}
+
+// trigger starts a backup in a child process.
+func (s *apiServer) trigger() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.run != nil {
+		return fmt.Errorf("a backup started through the API is already running")
+	}
+	if _, err := os.Stat(s.b.config.LockFile); err == nil {
+		return fmt.Errorf("a backup is already running (lock: %s)", s.b.config.LockFile)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(executable, s.globalArgs...)
+	cmd.Env = append(os.Environ(), "BACKUP_INITIATOR=api")
+	// Its own process group, so cancel reaches rsync and ssh as well
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start backup: %v", err)
+	}
+	s.run, s.started = cmd, time.Now()
+
+	go func() {
+		err := cmd.Wait()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.last = map[string]any{
+			"started":   s.started.Format(time.RFC3339),
+			"finished":  time.Now().Format(time.RFC3339),
+			"exit_code": cmd.ProcessState.ExitCode(),
+		}
+		if err != nil {
+			s.last["error"] = err.Error()
+		}
+		s.run = nil
+	}()
+	return nil
+}
+
+// cancel stops the backup started through the API the way Ctrl-C would:
+// the whole process group is signalled, so rsync stops writing while the
+// incomplete snapshot is quarantined and the lock removed. It reports
+// whether there was one to stop.
+func (s *apiServer) cancel() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.run == nil {
+		return false
+	}
+	syscall.Kill(-s.run.Process.Pid, syscall.SIGTERM)
+	return true
+}
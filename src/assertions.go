@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Assertion is a site-specific invariant checked before the transfer, e.g.
+// {"type": "mountpoint", "path": "/mnt/nas", "message": "NAS share not mounted"}.
+type Assertion struct {
+	Type    string `json:"type"`              // path_exists, file_exists, mountpoint, command, host_reachable
+	Path    string `json:"path,omitempty"`    // path_exists, file_exists, mountpoint
+	Command string `json:"command,omitempty"` // command (run with sh -c)
+	Host    string `json:"host,omitempty"`    // host_reachable (host or host:port, default port 22)
+	Message string `json:"message,omitempty"` // Optional text shown when the assertion fails
+}
+
+var assertionTypes = []string{"path_exists", "file_exists", "mountpoint", "command", "host_reachable"}
+
+// validate checks that the assertion is complete.
+func (a Assertion) validate() error {
+	switch a.Type {
+	case "path_exists", "file_exists", "mountpoint":
+		if a.Path == "" {
+			return fmt.Errorf("assertion %s requires path", a.Type)
+		}
+	case "command":
+		if a.Command == "" {
+			return fmt.Errorf("assertion command requires command")
+		}
+	case "host_reachable":
+		if a.Host == "" {
+			return fmt.Errorf("assertion host_reachable requires host")
+		}
+	default:
+		return fmt.Errorf("unknown assertion type %q (valid: %s)", a.Type, strings.Join(assertionTypes, ", "))
+	}
+	return nil
+}
+
+// String describes the assertion for plan output.
+func (a Assertion) String() string {
+	switch a.Type {
+	case "command":
+		return fmt.Sprintf("command %q exits 0", a.Command)
+	case "host_reachable":
+		return fmt.Sprintf("%s is reachable", a.Host)
+	default:
+		return fmt.Sprintf("%s %s", a.Type, a.Path)
+	}
+}
+
+// check evaluates the assertion and returns a descriptive error on failure.
+// Commands run with the given environment.
+func (a Assertion) check(env []string) error {
+	var err error
+	switch a.Type {
+	case "path_exists":
+		_, err = os.Stat(a.Path)
+	case "file_exists":
+		var info os.FileInfo
+		if info, err = os.Stat(a.Path); err == nil && !info.Mode().IsRegular() {
+			err = fmt.Errorf("%s is not a regular file", a.Path)
+		}
+	case "mountpoint":
+		err = checkMountpoint(a.Path)
+	case "command":
+		var output []byte
+		cmd := exec.Command("sh", "-c", a.Command)
+		cmd.Env = env
+		if output, err = cmd.CombinedOutput(); err != nil {
+			err = fmt.Errorf("%q failed: %v: %s", a.Command, err, strings.TrimSpace(string(output)))
+		}
+	case "host_reachable":
+		host := a.Host
+		if _, _, splitErr := net.SplitHostPort(host); splitErr != nil {
+			host = net.JoinHostPort(host, "22")
+		}
+		var conn net.Conn
+		if conn, err = net.DialTimeout("tcp", host, 10*time.Second); err == nil {
+			conn.Close()
+		}
+	}
+
+	if err == nil {
+		return nil
+	}
+	if a.Message != "" {
+		return fmt.Errorf("%s (%s: %v)", a.Message, a.Type, err)
+	}
+	return fmt.Errorf("%s: %v", a.Type, err)
+}
+
+// checkMountpoint succeeds if path is the root of a mounted filesystem, i.e.
+// it lives on a different device than its parent directory.
+func checkMountpoint(path string) error {
+	var st, parent syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return err
+	}
+	if err := syscall.Stat(filepath.Dir(filepath.Clean(path)), &parent); err != nil {
+		return err
+	}
+	if st.Dev == parent.Dev && st.Ino != parent.Ino {
+		return fmt.Errorf("%s is not a mountpoint", path)
+	}
+	return nil
+}
+
+// checkAssertions evaluates all configured assertions in order and stops at
+// the first failure.
+func (b *Backup) checkAssertions() error {
+	for _, a := range b.config.Assertions {
+		if err := a.check(b.childEnv()); err != nil {
+			return err
+		}
+	}
+	if len(b.config.Assertions) > 0 {
+		b.log("Pre-flight assertions: %d passed", len(b.config.Assertions))
+	}
+	return nil
+}
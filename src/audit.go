@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// initiator tells who started this run: "api" through serve-api, "manual"
+// from a terminal, "schedule" otherwise (cron, launchd, systemd timers).
+func initiator() string {
+	if os.Getenv("BACKUP_INITIATOR") == "api" {
+		return "api"
+	}
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		return "manual"
+	}
+	return "schedule"
+}
+
+// audit adds one line describing a destructive operation to audit_log, e.g.
+//
+//	time=2026-01-02T03:04:05+01:00 initiator=schedule action=prune snapshot=CET_2025-12-01_03.04.05 reason="keep 30"
+//
+// Actions are prune (retention moved a snapshot to the trash), delete (a
+// trashed or orphaned incomplete snapshot was deleted for good), quarantine
+// and rsync-delete (paths of the previous snapshot that rsync --delete left
+// out of the new one). The audit log is separate from the main log and only
+// ever appended to.
+func (b *Backup) audit(action, name, reason string) {
+	if b.config.AuditLog == "" || b.config.DryRun {
+		return
+	}
+
+	fields := []string{
+		"time=" + time.Now().Format(time.RFC3339),
+		"initiator=" + initiator(),
+		"action=" + action,
+		"snapshot=" + name,
+		"reason=" + strconv.Quote(reason),
+	}
+
+	if err := os.MkdirAll(filepath.Dir(b.config.AuditLog), 0755); err != nil {
+		b.log("Warning: failed to create audit log directory: %v", err)
+		return
+	}
+	f, err := os.OpenFile(b.config.AuditLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		b.log("Warning: failed to open audit log: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintln(f, strings.Join(fields, " ")); err != nil {
+		b.log("Warning: failed to write audit log: %v", err)
+	}
+}
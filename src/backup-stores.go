@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path/filepath"
+)
+
+// backupStore is a directory in which another backup or sync tool keeps
+// copies or caches of data that is backed up anyway.
+type backupStore struct {
+	Tool    string
+	Pattern string // Glob of the absolute path
+}
+
+var backupStores = []backupStore{
+	{"Time Machine local snapshots", "/.MobileBackups"},
+	{"Time Machine local snapshots", "/.MobileBackups.trash"},
+	{"Time Machine local snapshots", "/Volumes/com.apple.TimeMachine.localsnapshots"},
+	{"Time Machine", "/Volumes/*/Backups.backupdb"},
+	{"Backblaze", "/.bzvol"},
+	{"Backblaze", "/Volumes/*/.bzvol"},
+	{"Backblaze", "/Library/Backblaze.bzpkg/bzdata/bzbackup"},
+	{"Dropbox cache", "/Users/*/Dropbox/.dropbox.cache"},
+	{"Dropbox cache", "/Users/*/Library/CloudStorage/Dropbox*/.dropbox.cache"},
+	{"Dropbox cache", "/home/*/Dropbox/.dropbox.cache"},
+	{"OneDrive cache", "/Users/*/Library/Containers/com.microsoft.OneDrive-mac/Data/Library/Caches"},
+	{"OneDrive cache", "/Users/*/Library/Group Containers/UBF8T346G9.OneDriveStandaloneSuite/FileProviderCache"},
+	{"OneDrive cache", "/home/*/.cache/onedrive"},
+}
+
+// excludeBackupStores adds the stores of other backup and sync tools found
+// inside the sources to the automatic excludes (exclude_backup_stores).
+// The repository of this tool is excluded by checkOverlap in any case.
+func (b *Backup) excludeBackupStores() {
+	if !b.config.ExcludeBackupStores {
+		return
+	}
+	for _, store := range backupStores {
+		matches, _ := filepath.Glob(store.Pattern)
+		for _, match := range matches {
+			path := resolvePath(match)
+			for _, source := range b.sourcePaths() {
+				if b.isSSHPath(source) {
+					continue
+				}
+				src := resolvePath(source)
+				if path == src || !isWithin(path, src) {
+					continue
+				}
+				exclude := b.excludeWithin(source, src, path)
+				b.autoExcludes = append(b.autoExcludes, exclude)
+				b.log("%s lies inside source %s - excluding %s", store.Tool, source, exclude)
+			}
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Commands of the browse prompt
+const browseHelp = `  ls [dir]               List a directory (marked entries start with *)
+  cd <dir>               Change the directory (.. and / work as usual)
+  info <path>            Show the metadata of a file or directory
+  mark <path>...         Mark files or directories for restore
+  unmark <path>...|all   Remove marks
+  marked                 List the marked paths
+  snapshots              List the snapshots of this job
+  open <snapshot|latest> Browse another snapshot (clears the marks)
+  restore --to <dir> [restore options]
+                         Restore the marked paths, see the restore command
+  help                   Show this help
+  quit                   Leave the browser`
+
+// snapshotBrowser holds the state of a browse session: the snapshot, its
+// catalog as a directory tree, the current directory and the marked paths
+// (relative to the snapshot root, "." is the root).
+type snapshotBrowser struct {
+	b        *Backup
+	name     string
+	entries  map[string]CatalogEntry
+	children map[string][]string
+	cwd      string
+	marked   []string
+}
+
+// open loads the catalog of a snapshot into the browser.
+func (s *snapshotBrowser) open(name string) error {
+	snapshot, err := s.b.resolveSnapshot(name)
+	if err != nil {
+		return err
+	}
+	name = filepath.Base(snapshot)
+	catalog, err := s.b.loadCatalog(name)
+	if err != nil {
+		return fmt.Errorf("failed to load the catalog of %s: %v", name, err)
+	}
+
+	s.name, s.cwd, s.marked = name, ".", nil
+	s.entries = make(map[string]CatalogEntry, len(catalog))
+	s.children = make(map[string][]string)
+	for _, e := range catalog {
+		s.entries[e.Path] = e
+		parent := path.Dir(e.Path)
+		s.children[parent] = append(s.children[parent], e.Path)
+	}
+	for _, names := range s.children {
+		slices.Sort(names)
+	}
+	fmt.Printf("Snapshot %s: %d entries\n", name, len(catalog))
+	return nil
+}
+
+// resolve returns the path an argument names relative to the snapshot
+// root, with absolute arguments taken from the root.
+func (s *snapshotBrowser) resolve(arg string) (string, error) {
+	p := path.Join(s.cwd, arg)
+	if strings.HasPrefix(arg, "/") {
+		p = path.Clean(strings.TrimPrefix(arg, "/"))
+	}
+	if p == ".." || strings.HasPrefix(p, "../") {
+		p = "."
+	}
+	if _, ok := s.entries[p]; !ok && p != "." {
+		return "", fmt.Errorf("%s: not in snapshot %s", arg, s.name)
+	}
+	return p, nil
+}
+
+// isDir reports whether p is a directory of the snapshot.
+func (s *snapshotBrowser) isDir(p string) bool {
+	return p == "." || s.entries[p].Mode.IsDir()
+}
+
+// list prints the entries of a directory like ls -l.
+func (s *snapshotBrowser) list(dir string) {
+	names := s.children[dir]
+	if !s.isDir(dir) {
+		names = []string{dir}
+	}
+	for _, p := range names {
+		e := s.entries[p]
+		mark := " "
+		if slices.Contains(s.marked, p) {
+			mark = "*"
+		}
+		name := path.Base(p)
+		if e.Mode.IsDir() {
+			name += "/"
+		}
+		fmt.Printf("%s %-11s %5d %5d %12d %s  %s\n", mark, e.Mode, e.Uid, e.Gid, e.Size, e.MTime.Format("2006-01-02 15:04"), name)
+	}
+	if len(names) == 0 {
+		fmt.Println("(empty)")
+	}
+}
+
+// info prints the metadata of one path.
+func (s *snapshotBrowser) info(p string) {
+	if p == "." {
+		fmt.Printf("Root of snapshot %s with %d entries\n", s.name, len(s.entries))
+		return
+	}
+	e := s.entries[p]
+	fmt.Printf("Path:     /%s\n", p)
+	fmt.Printf("Mode:     %s\n", e.Mode)
+	fmt.Printf("Owner:    %d:%d\n", e.Uid, e.Gid)
+	fmt.Printf("Size:     %d bytes\n", e.Size)
+	fmt.Printf("Modified: %s\n", e.MTime.Format(time.RFC3339))
+	fmt.Printf("Inode:    %d\n", e.Inode)
+	if e.Mode.IsDir() {
+		fmt.Printf("Entries:  %d\n", len(s.children[p]))
+	}
+}
+
+// restore copies the marked paths with the restore command, one after
+// another, holding the repository lock shared like restore does.
+func (s *snapshotBrowser) restore(args []string) error {
+	if len(s.marked) == 0 {
+		return fmt.Errorf("nothing is marked")
+	}
+	unlock, err := s.b.lockRepository(false, true)
+	if err != nil {
+		return fmt.Errorf("failed to lock repository: %v", err)
+	}
+	defer unlock()
+	for _, p := range s.marked {
+		if err := s.b.runRestore(append([]string{s.name, p}, args...)); err != nil {
+			return fmt.Errorf("restore of %s: %v", p, err)
+		}
+	}
+	return nil
+}
+
+// browseWords splits a command line into words. Double or single quotes
+// and backslashes keep spaces in names.
+func browseWords(line string) []string {
+	var words []string
+	var word strings.Builder
+	inWord, quote, escaped := false, rune(0), false
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote, inWord = r, true
+		case quote == 0 && (r == ' ' || r == '\t'):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// runBrowse lets the user look through a snapshot from its catalog at a
+// prompt: list directories, show file metadata, switch snapshots and mark
+// files and directories, which are then restored together.
+func (b *Backup) runBrowse(args []string) error {
+	fs := flag.NewFlagSet("browse", flag.ExitOnError)
+	positional := parseArgs(fs, args)
+	if len(positional) > 1 {
+		return fmt.Errorf("usage: browse [snapshot|latest]")
+	}
+	name := "latest"
+	if len(positional) == 1 {
+		name = positional[0]
+	}
+
+	s := &snapshotBrowser{b: b}
+	if err := s.open(name); err != nil {
+		return err
+	}
+	fmt.Println(`Type "help" for the commands.`)
+
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Printf("%s:/%s> ", s.name, strings.TrimPrefix(s.cwd, "."))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			fmt.Println()
+			return nil
+		}
+		words := browseWords(strings.TrimSpace(line))
+		if len(words) == 0 {
+			continue
+		}
+		command, operands := words[0], words[1:]
+
+		switch command {
+		case "quit", "exit":
+			return nil
+		case "help", "?":
+			fmt.Println(browseHelp)
+		case "ls":
+			dir := s.cwd
+			if len(operands) > 0 {
+				if dir, err = s.resolve(operands[0]); err != nil {
+					break
+				}
+			}
+			s.list(dir)
+		case "cd":
+			dir := "."
+			if len(operands) > 0 {
+				if dir, err = s.resolve(operands[0]); err != nil {
+					break
+				}
+			}
+			if !s.isDir(dir) {
+				err = fmt.Errorf("%s: not a directory", operands[0])
+				break
+			}
+			s.cwd = dir
+		case "info":
+			if len(operands) != 1 {
+				err = fmt.Errorf("usage: info <path>")
+				break
+			}
+			var p string
+			if p, err = s.resolve(operands[0]); err == nil {
+				s.info(p)
+			}
+		case "mark":
+			for _, operand := range operands {
+				var p string
+				if p, err = s.resolve(operand); err != nil {
+					break
+				}
+				if !slices.Contains(s.marked, p) {
+					s.marked = append(s.marked, p)
+				}
+			}
+		case "unmark":
+			if len(operands) == 1 && operands[0] == "all" {
+				s.marked = nil
+				break
+			}
+			for _, operand := range operands {
+				var p string
+				if p, err = s.resolve(operand); err != nil {
+					break
+				}
+				s.marked = slices.DeleteFunc(s.marked, func(m string) bool { return m == p })
+			}
+		case "marked":
+			for _, p := range s.marked {
+				fmt.Printf("/%s\n", strings.TrimPrefix(p, "."))
+			}
+			fmt.Printf("%d marked\n", len(s.marked))
+		case "snapshots":
+			var snapshots []string
+			if snapshots, err = b.listSnapshots(); err == nil {
+				for _, snapshot := range snapshots {
+					current := " "
+					if snapshot == s.name {
+						current = ">"
+					}
+					fmt.Printf("%s %s\n", current, snapshot)
+				}
+			}
+		case "open":
+			if len(operands) != 1 {
+				err = fmt.Errorf("usage: open <snapshot|latest>")
+				break
+			}
+			err = s.open(operands[0])
+		case "restore":
+			err = s.restore(operands)
+		default:
+			err = fmt.Errorf("unknown command %s (try help)", command)
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// File in the snapshot's metadata directory holding the catalog
+const CatalogFile = "catalog.tsv.gz"
+
+// CatalogEntry describes one file, directory or link of a snapshot.
+type CatalogEntry struct {
+	Path  string // Relative to the snapshot root
+	Mode  fs.FileMode
+	Uid   uint32
+	Gid   uint32
+	Inode uint64
+	Size  int64
+	MTime time.Time
+}
+
+// buildCatalog walks a snapshot directory and returns an entry for every
+// item in it except the metadata directory.
+func buildCatalog(dir string) ([]CatalogEntry, error) {
+	var entries []CatalogEntry
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		if d.IsDir() && d.Name() == SnapshotMetaDir && filepath.Dir(path) == dir {
+			return filepath.SkipDir
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		entry := CatalogEntry{Path: rel, Mode: info.Mode(), Size: info.Size(), MTime: info.ModTime()}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			entry.Uid = st.Uid
+			entry.Gid = st.Gid
+			entry.Inode = uint64(st.Ino)
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// writeCatalog builds the catalog of the current snapshot and stores it in
+// the snapshot's metadata directory.
+func (b *Backup) writeCatalog() error {
+	if b.config.DryRun {
+		return nil
+	}
+	if b.isSSHPath(b.config.Destination) {
+		b.log("Catalog skipped for remote destination")
+		return nil
+	}
+
+	count, err := saveCatalog(b.snapDir)
+	if err != nil {
+		return err
+	}
+	b.log("Catalog written: %d entries", count)
+	return nil
+}
+
+// saveCatalog builds the catalog of a snapshot directory and stores it in
+// the snapshot's metadata directory. It returns the number of entries.
+func saveCatalog(snapDir string) (int, error) {
+	entries, err := buildCatalog(snapDir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to walk snapshot: %v", err)
+	}
+
+	metaDir := filepath.Join(snapDir, SnapshotMetaDir)
+	if err := os.MkdirAll(metaDir, 0755); err != nil {
+		return 0, fmt.Errorf("failed to create metadata directory: %v", err)
+	}
+	f, err := os.Create(filepath.Join(metaDir, CatalogFile))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	w := bufio.NewWriter(gz)
+	for _, e := range entries {
+		// Paths are quoted as they may contain tabs or newlines
+		fmt.Fprintf(w, "%o\t%d\t%d\t%d\t%d\t%d\t%s\n",
+			uint32(e.Mode), e.Uid, e.Gid, e.Inode, e.Size, e.MTime.UnixNano(), strconv.Quote(e.Path))
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	return len(entries), gz.Close()
+}
+
+// loadCatalog returns the catalog of a snapshot in the local repository.
+// Snapshots without a stored catalog are walked instead.
+func (b *Backup) loadCatalog(name string) ([]CatalogEntry, error) {
+	snapDir := filepath.Join(b.config.Destination, name)
+	f, err := os.Open(filepath.Join(snapDir, SnapshotMetaDir, CatalogFile))
+	if err != nil {
+		return buildCatalog(snapDir)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("catalog of %s is corrupt: %v", name, err)
+	}
+	defer gz.Close()
+
+	var entries []CatalogEntry
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 7)
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("catalog of %s is corrupt: %q", name, scanner.Text())
+		}
+		var nums [6]int64
+		for i := 0; i < 6; i++ {
+			base := 10
+			if i == 0 {
+				base = 8
+			}
+			if nums[i], err = strconv.ParseInt(fields[i], base, 64); err != nil {
+				return nil, fmt.Errorf("catalog of %s is corrupt: %v", name, err)
+			}
+		}
+		path, err := strconv.Unquote(fields[6])
+		if err != nil {
+			return nil, fmt.Errorf("catalog of %s is corrupt: %v", name, err)
+		}
+		entries = append(entries, CatalogEntry{
+			Path:  path,
+			Mode:  fs.FileMode(nums[0]),
+			Uid:   uint32(nums[1]),
+			Gid:   uint32(nums[2]),
+			Inode: uint64(nums[3]),
+			Size:  nums[4],
+			MTime: time.Unix(0, nums[5]),
+		})
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Full scan interval without full_scan_interval
+const defaultFullScanInterval = 7 * 24 * time.Hour
+
+// cachedFile is the state of a source path as recorded in the change cache.
+type cachedFile struct {
+	kind  byte // 'd' directory, 'l' symlink, 'f' anything else
+	size  int64
+	mtime int64 // Unix nanoseconds
+}
+
+// changeCache is the state of the source at the start of the run that
+// created a snapshot, kept in change_cache.
+type changeCache struct {
+	snapshot  string    // Snapshot the state belongs to
+	fullScan  time.Time // Last run that let rsync compare everything
+	signature string    // See sourceSignature
+	files     map[string]cachedFile
+}
+
+// scanSource lstats every path below a local source, relative to it.
+func scanSource(source string) (map[string]cachedFile, error) {
+	files := make(map[string]cachedFile)
+	err := filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == source {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		kind := byte('f')
+		switch {
+		case d.IsDir():
+			kind = 'd'
+		case d.Type()&fs.ModeSymlink != 0:
+			kind = 'l'
+		}
+		rel, _ := filepath.Rel(source, path)
+		files[rel] = cachedFile{kind: kind, size: info.Size(), mtime: info.ModTime().UnixNano()}
+		return nil
+	})
+	return files, err
+}
+
+// sourceSignature identifies what a snapshot is made of: the source and the
+// exclude rules. A cache with another signature does not describe the
+// previous snapshot.
+func (b *Backup) sourceSignature() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", b.config.Source, strings.Join(b.autoExcludes, "\n"))
+	if data, err := os.ReadFile(b.config.ExcludeList); err == nil {
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// loadChangeCache reads change_cache.
+func loadChangeCache(filename string) (*changeCache, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("change cache is corrupt: %v", err)
+	}
+	defer gz.Close()
+
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("change cache is empty")
+	}
+	cache := &changeCache{files: make(map[string]cachedFile)}
+	for _, field := range strings.Fields(scanner.Text()) {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "snapshot":
+			cache.snapshot = value
+		case "signature":
+			cache.signature = value
+		case "full_scan":
+			if t, err := time.Parse(time.RFC3339, value); err == nil {
+				cache.fullScan = t
+			}
+		}
+	}
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 4)
+		if len(fields) != 4 || len(fields[0]) != 1 {
+			return nil, fmt.Errorf("change cache is corrupt: %q", scanner.Text())
+		}
+		size, err1 := strconv.ParseInt(fields[1], 10, 64)
+		mtime, err2 := strconv.ParseInt(fields[2], 10, 64)
+		path, err3 := strconv.Unquote(fields[3])
+		if err1 != nil || err2 != nil || err3 != nil {
+			return nil, fmt.Errorf("change cache is corrupt: %q", scanner.Text())
+		}
+		cache.files[path] = cachedFile{kind: fields[0][0], size: size, mtime: mtime}
+	}
+	return cache, scanner.Err()
+}
+
+// save writes the cache atomically, so an interrupted write leaves the
+// previous cache, which the next run then finds outdated.
+func (c *changeCache) save(filename string) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	tmp := filename + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	w := bufio.NewWriter(gz)
+	fmt.Fprintf(w, "snapshot=%s full_scan=%s signature=%s\n", c.snapshot, c.fullScan.Format(time.RFC3339), c.signature)
+	for path, file := range c.files {
+		fmt.Fprintf(w, "%c\t%d\t%d\t%s\n", file.kind, file.size, file.mtime, strconv.Quote(path))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// changedPaths returns the paths whose size, modification time or type
+// differ from the cache or that are new, and the paths that were deleted,
+// only the topmost of a deleted tree. ok is false if a path changed its
+// type, which a run over only these paths cannot handle.
+func (c *changeCache) changedPaths(current map[string]cachedFile) (changed, deleted []string, ok bool) {
+	for path, file := range current {
+		old, found := c.files[path]
+		switch {
+		case !found:
+			changed = append(changed, path)
+		case old.kind != file.kind:
+			return nil, nil, false
+		case file.kind != 'd' && (old.size != file.size || old.mtime != file.mtime):
+			changed = append(changed, path)
+		}
+	}
+	for path := range c.files {
+		if _, found := current[path]; !found {
+			deleted = append(deleted, path)
+		}
+	}
+	slices.Sort(deleted)
+	var topmost []string
+	for _, path := range deleted {
+		if len(topmost) == 0 || !strings.HasPrefix(path, topmost[len(topmost)-1]+"/") {
+			topmost = append(topmost, path)
+		}
+	}
+	slices.Sort(changed)
+	return changed, topmost, true
+}
+
+// fullScanInterval returns full_scan_interval.
+func (b *Backup) fullScanInterval() time.Duration {
+	if b.config.FullScanInterval == "" {
+		return defaultFullScanInterval
+	}
+	interval, _ := ParseDuration(b.config.FullScanInterval)
+	return interval
+}
+
+// planFastRun decides whether this run can be a fast run: instead of
+// letting rsync compare the whole source against the previous snapshot,
+// the source is compared against change_cache, the previous snapshot is
+// cloned with hard links and rsync only transfers the changed paths and
+// deletes the deleted ones. Every full_scan_interval, and whenever the
+// cache does not match the previous snapshot, a normal run does a full
+// scan. Both record the state of the source for the next run.
+func (b *Backup) planFastRun(lastBackup string) {
+	if b.config.ChangeCache == "" || b.config.DryRun {
+		return
+	}
+	if b.relativeSources() || b.hasSSHSource() {
+		b.log("Warning: change_cache needs a single local source, scanning everything")
+		return
+	}
+
+	start := time.Now()
+	files, err := scanSource(b.config.Source)
+	if err != nil {
+		b.log("Warning: source scan for the change cache failed: %v", err)
+		return
+	}
+	b.sourceState = &changeCache{fullScan: b.started, signature: b.sourceSignature(), files: files}
+	b.log("Scanned %d source paths in %s", len(files), time.Since(start).Round(time.Second))
+
+	reason := ""
+	cache, err := loadChangeCache(b.config.ChangeCache)
+	b.probeRsync()
+	switch {
+	case err != nil:
+		reason = fmt.Sprintf("no usable change cache (%v)", err)
+	case lastBackup == "(none)" || b.seeding:
+		reason = "no previous snapshot"
+	case cache.snapshot != lastBackup:
+		reason = "the change cache belongs to " + cache.snapshot + ", not " + lastBackup
+	case cache.signature != b.sourceSignature():
+		reason = "source or excludes changed"
+	case time.Since(cache.fullScan) >= b.fullScanInterval():
+		reason = fmt.Sprintf("last full scan %s ago", time.Since(cache.fullScan).Round(time.Hour))
+	case len(b.rsyncOptions) > 0 && !b.rsyncOptions["delete-missing-args"]:
+		reason = b.config.RsyncBin + " does not support --delete-missing-args"
+	}
+	if partial, err := b.listPartial(); reason == "" && (err != nil || len(partial) > 0) {
+		reason = "a partial snapshot is continued"
+	}
+	if reason != "" {
+		b.log("Full scan: %s", reason)
+		return
+	}
+
+	changed, deleted, ok := cache.changedPaths(files)
+	if !ok {
+		b.log("Full scan: a path changed between file and directory")
+		return
+	}
+	list, err := os.CreateTemp("", "go-rsync-backup-*.files")
+	if err != nil {
+		b.log("Warning: full scan, cannot write the file list: %v", err)
+		return
+	}
+	defer list.Close()
+	w := bufio.NewWriter(list)
+	for _, path := range slices.Concat(changed, deleted) {
+		// NUL-separated (--from0), as paths may contain newlines
+		w.WriteString(path + "\x00")
+	}
+	if err := w.Flush(); err != nil {
+		os.Remove(list.Name())
+		b.log("Warning: full scan, cannot write the file list: %v", err)
+		return
+	}
+	b.sourceState.fullScan = cache.fullScan
+	b.fastRun = true
+	b.fastChanges = len(changed) + len(deleted)
+	b.fileList = list.Name()
+	b.log("Fast run: %d paths changed and %d deleted since %s", len(changed), len(deleted), lastBackup)
+}
+
+// fastRunArgs restricts the rsync arguments of a fast run to the paths in
+// the file list. Paths that no longer exist are deleted from the snapshot.
+func (b *Backup) fastRunArgs(args []string) []string {
+	var fast []string
+	paths := args[len(args)-2:] // Source and snapshot
+	for _, arg := range args[:len(args)-2] {
+		if !strings.HasPrefix(arg, "--delete") {
+			fast = append(fast, arg)
+		}
+	}
+	fast = append(fast, "--files-from="+b.fileList, "--from0", "--delete-missing-args")
+	return append(fast, paths...)
+}
+
+// cloneForFastRun fills the new snapshot with hard links to the previous
+// one, which rsync then updates. Changed files are written to new inodes,
+// so the previous snapshot keeps its content. On failure the run falls
+// back to a full scan.
+func (b *Backup) cloneForFastRun(lastBackup string) {
+	if !b.fastRun {
+		return
+	}
+	start := time.Now()
+	previous := filepath.Join(b.config.Destination, lastBackup)
+	var err error
+	if b.isSSHPath(b.config.Destination) {
+		host, from := splitSSHPath(previous)
+		_, to := splitSSHPath(b.snapDir)
+		if _, err = b.runRemote(host, "cp -al "+shellQuote(from)+" "+shellQuote(to)+" && rm -rf "+shellQuote(to+"/"+SnapshotMetaDir)); err != nil {
+			b.runRemote(host, "rm -rf "+shellQuote(to))
+		}
+	} else {
+		if _, err = cloneTree(previous, b.snapDir); err == nil {
+			err = os.RemoveAll(filepath.Join(b.snapDir, SnapshotMetaDir))
+		}
+		if err != nil {
+			os.RemoveAll(b.snapDir)
+		}
+	}
+	if err != nil {
+		b.log("Warning: full scan, cloning %s failed: %v", lastBackup, err)
+		b.sourceState.fullScan = b.started
+		b.endFastRun()
+		return
+	}
+	b.log("Cloned %s in %s", lastBackup, time.Since(start).Round(time.Second))
+}
+
+// endFastRun removes the file list; the rest of the run is a normal one.
+func (b *Backup) endFastRun() {
+	if b.fileList != "" {
+		os.Remove(b.fileList)
+	}
+	b.fastRun = false
+	b.fileList = ""
+}
+
+// saveChangeCache records the state of the source for the next run once
+// the snapshot is complete. The state was taken before the transfer, so a
+// file modified during it is seen as changed by the next run.
+func (b *Backup) saveChangeCache() {
+	if b.sourceState == nil {
+		return
+	}
+	b.sourceState.snapshot = b.timestamp
+	if err := b.sourceState.save(b.config.ChangeCache); err != nil {
+		b.log("Warning: failed to write change cache: %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// File in the snapshot's metadata directory listing what changed compared
+// to the previous snapshot
+const ChangesFile = "changes.tsv.gz"
+
+// snapshotChange is a path that was created, modified or deleted between two
+// snapshots.
+type snapshotChange struct {
+	Kind string // created, modified or deleted
+	Path string
+}
+
+// diffCatalogs compares two snapshot catalogs. Files count as modified if
+// their size, mtime, mode or owner differ, the same quick check rsync uses.
+// Directories are only reported when created or deleted, as their mtime
+// changes with every added or removed file.
+func diffCatalogs(older, newer []CatalogEntry) []snapshotChange {
+	previous := make(map[string]CatalogEntry, len(older))
+	for _, e := range older {
+		previous[e.Path] = e
+	}
+
+	var changes []snapshotChange
+	for _, e := range newer {
+		old, ok := previous[e.Path]
+		delete(previous, e.Path)
+		switch {
+		case !ok || old.Mode.Type() != e.Mode.Type():
+			changes = append(changes, snapshotChange{"created", e.Path})
+		case e.Mode.IsDir():
+		case e.Size != old.Size || !e.MTime.Equal(old.MTime) || e.Mode != old.Mode ||
+			e.Uid != old.Uid || e.Gid != old.Gid:
+			changes = append(changes, snapshotChange{"modified", e.Path})
+		}
+	}
+	for path := range previous {
+		changes = append(changes, snapshotChange{"deleted", path})
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// writeChanges records which paths changed compared to the previous
+// snapshot in the metadata directory of the current snapshot and returns
+// them.
+func (b *Backup) writeChanges(lastBackup string) ([]snapshotChange, error) {
+	if b.config.DryRun {
+		return nil, nil
+	}
+	if b.isSSHPath(b.config.Destination) {
+		return nil, nil // No catalog to compare
+	}
+
+	current, err := b.loadCatalog(filepath.Base(b.snapDir))
+	if err != nil {
+		return nil, err
+	}
+	var previous []CatalogEntry
+	if lastBackup != "(none)" {
+		if previous, err = b.loadCatalog(lastBackup); err != nil {
+			return nil, err
+		}
+	}
+
+	changes := diffCatalogs(previous, current)
+	if err := saveChanges(b.snapDir, changes); err != nil {
+		return nil, err
+	}
+	b.log("Changes recorded: %d paths", len(changes))
+	return changes, nil
+}
+
+// saveChanges stores a list of changes in the snapshot's metadata directory.
+func saveChanges(snapDir string, changes []snapshotChange) error {
+	metaDir := filepath.Join(snapDir, SnapshotMetaDir)
+	if err := os.MkdirAll(metaDir, 0755); err != nil {
+		return fmt.Errorf("failed to create metadata directory: %v", err)
+	}
+	f, err := os.Create(filepath.Join(metaDir, ChangesFile))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	w := bufio.NewWriter(gz)
+	for _, c := range changes {
+		fmt.Fprintf(w, "%s\t%s\n", c.Kind, strconv.Quote(c.Path))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// loadChanges returns the changes of a snapshot in the local repository
+// compared to its predecessor. Snapshots without a stored list are compared
+// using their catalogs.
+func (b *Backup) loadChanges(name string) ([]snapshotChange, error) {
+	f, err := os.Open(filepath.Join(b.config.Destination, name, SnapshotMetaDir, ChangesFile))
+	if err != nil {
+		return b.computeChanges(name)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("change list of %s is corrupt: %v", name, err)
+	}
+	defer gz.Close()
+
+	var changes []snapshotChange
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		kind, quoted, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok {
+			return nil, fmt.Errorf("change list of %s is corrupt: %q", name, scanner.Text())
+		}
+		path, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, fmt.Errorf("change list of %s is corrupt: %v", name, err)
+		}
+		changes = append(changes, snapshotChange{kind, path})
+	}
+	return changes, scanner.Err()
+}
+
+// computeChanges compares a snapshot with the snapshot before it.
+func (b *Backup) computeChanges(name string) ([]snapshotChange, error) {
+	snapshots, err := b.listSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	idx := slices.Index(snapshots, name)
+	if idx < 0 {
+		return nil, fmt.Errorf("snapshot %s not found", name)
+	}
+
+	current, err := b.loadCatalog(name)
+	if err != nil {
+		return nil, err
+	}
+	var previous []CatalogEntry
+	if idx > 0 {
+		if previous, err = b.loadCatalog(snapshots[idx-1]); err != nil {
+			return nil, err
+		}
+	}
+	return diffCatalogs(previous, current), nil
+}
+
+// runChanges prints what changed in a snapshot compared to the one before.
+func (b *Backup) runChanges(args []string) error {
+	fs := flag.NewFlagSet("changes", flag.ExitOnError)
+	kind := fs.String("only", "", "Show only created, modified or deleted paths")
+	positional := parseArgs(fs, args)
+
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: changes <snapshot|latest> [--only created|modified|deleted]")
+	}
+	if *kind != "" && *kind != "created" && *kind != "modified" && *kind != "deleted" {
+		return fmt.Errorf("--only must be created, modified or deleted")
+	}
+	snapshot, err := b.resolveSnapshot(positional[0])
+	if err != nil {
+		return err
+	}
+	changes, err := b.loadChanges(filepath.Base(snapshot))
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[string]int)
+	for _, c := range changes {
+		counts[c.Kind]++
+		if *kind == "" || *kind == c.Kind {
+			fmt.Printf("%-9s %s\n", c.Kind, c.Path)
+		}
+	}
+	fmt.Printf("\n%d created, %d modified, %d deleted\n", counts["created"], counts["modified"], counts["deleted"])
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// rateLimiter spreads reads over time so that all workers together stay
+// below a number of bytes per second. A nil limiter does not limit.
+type rateLimiter struct {
+	mu    sync.Mutex
+	rate  float64 // Bytes per second
+	ready time.Time
+}
+
+// newRateLimiter returns a limiter for verify_rate_limit, or nil without one.
+func (b *Backup) newRateLimiter() *rateLimiter {
+	if b.config.VerifyRateLimit == "" {
+		return nil
+	}
+	rate, _ := ParseSize(b.config.VerifyRateLimit)
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(rate)}
+}
+
+// wait accounts for n bytes read and sleeps until the rate allows them.
+func (l *rateLimiter) wait(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.ready.Before(now) {
+		l.ready = now
+	}
+	l.ready = l.ready.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	delay := l.ready.Sub(now)
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// limitedReader is an io.Reader throttled by a rateLimiter.
+type limitedReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (r limitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.limiter.wait(n)
+	return n, err
+}
+
+// forEachParallel calls fn for every index below n using verify_workers
+// goroutines, at most one per CPU. Each worker handles one item at a time,
+// so the number of workers bounds the CPUs and concurrent reads used.
+func (b *Backup) forEachParallel(n int, fn func(i int)) {
+	workers := min(max(b.config.VerifyWorkers, 1), runtime.NumCPU(), n)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+	for i := range n {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
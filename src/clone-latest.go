@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// runCloneLatest materializes the latest snapshot onto a fresh disk. It is
+// meant as a guided "my disk died, rebuild from backup" flow.
+func (b *Backup) runCloneLatest(args []string) error {
+	fs := flag.NewFlagSet("clone-latest", flag.ExitOnError)
+	target := fs.String("to", "", "Target directory (e.g. the mount point of the new disk)")
+	bootable := fs.Bool("bootable", false, "macOS: enable ownership on the target volume and preserve extended attributes and file flags")
+	yes := fs.Bool("yes", false, "Do not ask for confirmation")
+	parseArgs(fs, args)
+
+	if *target == "" {
+		return fmt.Errorf("missing --to <directory>")
+	}
+	if b.isSSHPath(b.config.Destination) {
+		return fmt.Errorf("clone-latest requires a local repository")
+	}
+
+	// Step 1: locate the latest snapshot
+	lastBackup := b.getLastBackup()
+	if lastBackup == "(none)" {
+		return fmt.Errorf("no latest snapshot found in %s", b.config.Destination)
+	}
+	snapshot := filepath.Join(b.config.Destination, lastBackup)
+	if _, err := os.Stat(snapshot); err != nil {
+		return fmt.Errorf("latest snapshot not accessible: %v", err)
+	}
+	fmt.Printf("Step 1: latest snapshot is %s\n", lastBackup)
+
+	// Step 2: check the target
+	info, err := os.Stat(*target)
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("target %s is not an existing directory (is the new disk mounted?)", *target)
+	}
+	entries, err := os.ReadDir(*target)
+	if err != nil {
+		return fmt.Errorf("failed to read target: %v", err)
+	}
+	fmt.Printf("Step 2: target is %s (%d existing entries)\n", *target, len(entries))
+	if len(entries) > 0 {
+		fmt.Println("Warning: the target is not empty. Existing files with the same names will be overwritten.")
+	}
+
+	// Step 3: confirm
+	if !*yes && !confirm(fmt.Sprintf("Step 3: copy %s to %s?", snapshot, *target)) {
+		return fmt.Errorf("aborted by user")
+	}
+
+	// Ownership is ignored on external macOS volumes by default
+	if *bootable && runtime.GOOS == "darwin" {
+		fmt.Println("Enabling ownership on the target volume")
+		if output, err := exec.Command("diskutil", "enableOwnership", *target).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to enable ownership: %v: %s", err, strings.TrimSpace(string(output)))
+		}
+	}
+
+	// Step 4: copy the snapshot
+	fmt.Println("Step 4: copying snapshot")
+	if err := b.findRsync(); err != nil {
+		return fmt.Errorf("failed to find rsync: %v", err)
+	}
+	args = b.cloneRsyncArgs(*bootable)
+	args = append(args, snapshot+"/", *target)
+
+	b.log("Running rsync: %s %s", b.config.RsyncBin, strings.Join(args, " "))
+	cmd := b.privileged(b.config.RsyncBin, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("rsync failed: %v", err)
+	}
+
+	b.log("Clone of %s to %s completed successfully", lastBackup, *target)
+	return nil
+}
+
+// cloneRsyncArgs returns the rsync arguments for copying a snapshot onto a
+// fresh disk or restoring from it: the backup preservation flags without
+// any deletion.
+func (b *Backup) cloneRsyncArgs(bootable bool) []string {
+	var args []string
+	for _, arg := range b.supportedArgs(RsyncBaseArgs) {
+		if !strings.HasPrefix(arg, "--delete") {
+			args = append(args, arg)
+		}
+	}
+
+	// The snapshot metadata describes the backup, not the restored system
+	args = append(args, "--exclude=/"+SnapshotMetaDir)
+
+	if b.config.ShowProgress {
+		args = append(args, "--progress")
+	}
+
+	if b.config.PreserveCrtimes {
+		args = append(args, b.crtimesArgs()...)
+	}
+	if runtime.GOOS == "darwin" {
+		args = append(args, b.supportedArgs(RsyncMacOSArgs)...)
+		if bootable {
+			args = append(args, b.supportedArgs([]string{"-X"})...)
+		}
+	}
+	return args
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Directory in the repository that holds working copies created by
+// clone-snapshot. It is not a snapshot, so retention and fsck leave it alone.
+const ClonesDir = ".clones"
+
+// cloneTree recreates the directory tree of src at dst with every file
+// hard-linked to the original (like cp -al). Directories and symlinks are
+// new, so renaming or deleting entries in the clone does not affect src.
+func cloneTree(src, dst string) (int, error) {
+	type dirTimes struct {
+		path  string
+		mode  fs.FileMode
+		mtime time.Time
+	}
+	var dirs []dirTimes
+	files := 0
+
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			// Writable until the tree is complete; the mode is set afterwards
+			if err := os.Mkdir(target, 0700); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirTimes{target, info.Mode().Perm() | info.Mode()&(fs.ModeSetgid|fs.ModeSticky), info.ModTime()})
+		case d.Type()&fs.ModeSymlink != 0:
+			// Not hard-linked: link(2) follows symlinks on some systems
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		default:
+			if err := os.Link(path, target); err != nil {
+				if errors.Is(err, syscall.EXDEV) {
+					return fmt.Errorf("%s is not on the same filesystem as the repository", dst)
+				}
+				return err
+			}
+			files++
+			return nil
+		}
+
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && os.Geteuid() == 0 {
+			if err := os.Lchown(target, int(st.Uid), int(st.Gid)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return files, err
+	}
+
+	// Innermost first, so setting a mode never blocks a directory below it
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i].path, dirs[i].mode); err != nil {
+			return files, err
+		}
+		os.Chtimes(dirs[i].path, dirs[i].mtime, dirs[i].mtime)
+	}
+	return files, nil
+}
+
+// runCloneSnapshot creates a working copy of a snapshot outside the
+// retention chain, hard-linked to the snapshot so it takes almost no space.
+func (b *Backup) runCloneSnapshot(args []string) error {
+	fs := flag.NewFlagSet("clone-snapshot", flag.ExitOnError)
+	positional := parseArgs(fs, args)
+
+	if len(positional) != 2 {
+		return fmt.Errorf("usage: clone-snapshot <snapshot|latest> <new-name|path>")
+	}
+	snapshot, err := b.resolveSnapshot(positional[0])
+	if err != nil {
+		return err
+	}
+
+	// A bare name is created in the clones directory of the repository
+	target := positional[1]
+	if !strings.ContainsRune(target, filepath.Separator) {
+		if target == "" || target == "." || target == ".." || isSnapshotName(target) {
+			return fmt.Errorf("invalid clone name %q", target)
+		}
+		target = filepath.Join(b.config.Destination, ClonesDir, target)
+	}
+	if isSnapshotName(filepath.Base(target)) {
+		return fmt.Errorf("clone name %q looks like a snapshot name", filepath.Base(target))
+	}
+	if _, err := os.Lstat(target); err == nil {
+		return fmt.Errorf("%s already exists", target)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", filepath.Dir(target), err)
+	}
+
+	fmt.Printf("Cloning %s to %s\n", filepath.Base(snapshot), target)
+	files, err := cloneTree(snapshot, target)
+	if err != nil {
+		os.RemoveAll(target)
+		return fmt.Errorf("clone failed: %v", err)
+	}
+
+	fmt.Printf("Created %s with %d hard-linked files\n", target, files)
+	fmt.Println("\nFiles in the clone share their data with the snapshot: replace files")
+	fmt.Println("(write a new file, then rename it) instead of editing them in place, or")
+	fmt.Println("the change shows up in every snapshot containing the file.")
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Commands that can be given after the global options
+var Commands = []struct {
+	Name        string
+	Description string
+}{
+	{"backup", "Run a backup (the default without a command); --dry-run to only show the changes"},
+	{"run", "Run the named jobs, or --all of them (--skip <job> leaves one out)"},
+	{"list", "List the snapshots of this job with their age and state"},
+	{"prune", "Apply the retention settings now (--dry-run to only show what would be removed)"},
+	{"compact", "Keep only one snapshot per quarter of those older than --years (default 2)"},
+	{"verify", "Check a snapshot against its catalog (--source-sample N to also compare with the source)"},
+	{"status", "Show whether a backup is running, the last run, the latest snapshot and free space"},
+	{"restore", "Copy a snapshot, or a path from it, into a directory (snapshot, latest or a date)"},
+	{"browse", "Look through a snapshot at a prompt and restore the files marked there"},
+	{"clone-latest", "Copy the latest snapshot onto a fresh disk (disaster recovery)"},
+	{"export", "Write a snapshot into a portable archive (.tar.zst, .tar.gz, .tar)"},
+	{"import", "Add a snapshot from an archive created by export"},
+	{"import-seed", "Start a new repository from a snapshot carried over on a portable disk"},
+	{"diff-export", "Write the files that differ between two snapshots into an archive"},
+	{"keygen", "Create a key pair for signing_key (--to <file>)"},
+	{"fsck", "Check the repository for problems (--repair to fix them)"},
+	{"audit-links", "Verify that unchanged files are hard-linked between snapshots"},
+	{"check-age", "Exit non-zero if the newest snapshot is older than max_snapshot_age"},
+	{"on-mount", "Run the backup if the destination disk is attached (for mount triggers)"},
+	{"plan", "Show what a backup run would do without executing anything"},
+	{"install-rsync", "Install a known-good rsync binary next to this tool"},
+	{"changes", "List paths created, modified or deleted in a snapshot"},
+	{"sysinfo", "Show the system manifest (disks, packages, services) stored in a snapshot"},
+	{"problems", "List source files rsync failed to read, with the date they first failed"},
+	{"exclude-report", "Show how much data each exclude rule filters and the largest directories"},
+	{"forecast", "Project when the destination reaches cleanup_at_percent at the current growth"},
+	{"stats", "Break down repository size, sharing and growth by directory"},
+	{"clone-snapshot", "Create a hard-linked working copy of a snapshot that is never pruned"},
+	{"mount-snapshot", "Mount a snapshot read-only at another directory for inspection"},
+	{"migrate-repository", "Copy the repository to a new disk, keeping hard links between snapshots"},
+	{"drift", "List how the live source differs from a snapshot (default: latest)"},
+	{"serve-api", "Serve the JSON-RPC API for menu bar and tray apps on a Unix socket"},
+}
+
+// Commands that run without root. They only see what the invoking user may
+// read; backups triggered through serve-api are checked when they start.
+var unprivilegedCommands = map[string]bool{
+	"list":      true,
+	"status":    true,
+	"check-age": true,
+	"changes":   true,
+	"sysinfo":   true,
+	"problems":  true,
+	"forecast":  true,
+	"stats":     true,
+	"keygen":    true,
+	"serve-api": true,
+}
+
+func printUsage() {
+	fmt.Println("Go Rsync Backup Tool")
+	fmt.Println("Usage: backup [options] [command] [command options]")
+	fmt.Println("\nWithout a command a backup is run.")
+	fmt.Println("\nCommands:")
+	for _, c := range Commands {
+		fmt.Printf("  %-18s %s\n", c.Name, c.Description)
+	}
+	fmt.Println("\nOptions:")
+	flag.PrintDefaults()
+}
+
+// runCommand dispatches a subcommand given on the command line.
+func runCommand(config Config, args []string) error {
+	b := NewBackup(config)
+
+	if readCommands[args[0]] {
+		unlock, err := b.lockRepository(false, true)
+		if err != nil {
+			return fmt.Errorf("failed to lock repository: %v", err)
+		}
+		defer unlock()
+	}
+
+	switch args[0] {
+	case "list":
+		return b.runList(args[1:])
+	case "prune":
+		return b.runPrune(args[1:])
+	case "verify":
+		return b.runVerify(args[1:])
+	case "compact":
+		return b.runCompact(args[1:])
+	case "status":
+		return b.runStatus(args[1:])
+	case "restore":
+		return b.runRestore(args[1:])
+	case "browse":
+		return b.runBrowse(args[1:])
+	case "clone-latest":
+		return b.runCloneLatest(args[1:])
+	case "export":
+		return b.runExport(args[1:])
+	case "import":
+		return b.runImport(args[1:])
+	case "import-seed":
+		return b.runImportSeed(args[1:])
+	case "diff-export":
+		return b.runDiffExport(args[1:])
+	case "keygen":
+		return b.runKeygen(args[1:])
+	case "fsck":
+		return b.runFsck(args[1:])
+	case "audit-links":
+		return b.runAuditLinks(args[1:])
+	case "check-age":
+		return b.runCheckAge(args[1:])
+	case "on-mount":
+		return b.runOnMount(args[1:])
+	case "plan":
+		return b.runPlan(args[1:])
+	case "install-rsync":
+		return b.runInstallRsync(args[1:])
+	case "changes":
+		return b.runChanges(args[1:])
+	case "sysinfo":
+		return b.runSysinfo(args[1:])
+	case "problems":
+		return b.runProblems(args[1:])
+	case "exclude-report":
+		return b.runExcludeReport(args[1:])
+	case "forecast":
+		return b.runForecast(args[1:])
+	case "stats":
+		return b.runStats(args[1:])
+	case "clone-snapshot":
+		return b.runCloneSnapshot(args[1:])
+	case "mount-snapshot":
+		return b.runMountSnapshot(args[1:])
+	case "migrate-repository":
+		return b.runMigrateRepository(args[1:])
+	case "drift":
+		return b.runDrift(args[1:])
+	case "serve-api":
+		return b.runServeAPI(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q (see -help)", args[0])
+	}
+}
+
+// parseArgs parses command flags that may appear before, between or after
+// positional arguments (e.g. "restore latest --to /tmp/restore") and
+// returns the positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// confirm asks a yes/no question on the terminal and returns true only if the
+// user answers "yes".
+func confirm(question string) bool {
+	fmt.Printf("%s Type 'yes' to continue: ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(answer) == "yes"
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// compactCandidates returns the snapshots compact removes: of the snapshots
+// created before cutoff only the newest of each calendar quarter is kept.
+// The newest snapshot of the job is never removed.
+func compactCandidates(snapshots []string, cutoff time.Time) map[string]bool {
+	remove := make(map[string]bool)
+	seen := make(map[string]bool)
+	for i := len(snapshots) - 2; i >= 0; i-- {
+		t, err := snapshotTime(snapshots[i])
+		if err != nil || !t.Before(cutoff) {
+			continue
+		}
+		quarter := fmt.Sprintf("%d-Q%d", t.Year(), (int(t.Month())+2)/3)
+		if seen[quarter] {
+			remove[snapshots[i]] = true
+		}
+		seen[quarter] = true
+	}
+	return remove
+}
+
+// runCompact thins out snapshots older than --years to one per quarter, so
+// ancient history takes less space without being deleted entirely. Files
+// that only the removed snapshots reference are freed; every snapshot that
+// lost its predecessor gets its change list rewritten against the snapshot
+// now before it.
+func (b *Backup) runCompact(args []string) error {
+	fs := flag.NewFlagSet("compact", flag.ExitOnError)
+	years := fs.Int("years", 2, "Compact snapshots older than this many years")
+	dryRun := fs.Bool("dry-run", false, "Show which snapshots would be removed without removing any")
+	parseArgs(fs, args)
+
+	if *years < 1 {
+		return fmt.Errorf("--years must be at least 1")
+	}
+	if b.isSSHPath(b.config.Destination) {
+		return fmt.Errorf("compact requires a local repository")
+	}
+	if err := b.checkRepositoryAccess(); err != nil {
+		return err
+	}
+	if *dryRun {
+		b.config.DryRun = true
+	} else {
+		if err := b.createLock(); err != nil {
+			return err
+		}
+		defer b.removeLock()
+		if err := b.setupLogging(); err != nil {
+			return err
+		}
+		defer b.closeLogging()
+	}
+	unlock, err := b.lockRepository(!*dryRun, true)
+	if err != nil {
+		return fmt.Errorf("failed to lock repository: %v", err)
+	}
+	defer unlock()
+
+	snapshots, err := b.listSnapshots()
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().AddDate(-*years, 0, 0)
+	remove := compactCandidates(snapshots, cutoff)
+	if len(remove) == 0 {
+		b.log("Nothing to compact: at most one snapshot per quarter before %s", cutoff.Format("2006-01-02"))
+		return nil
+	}
+
+	// Count the references of every inode to know what the removal frees
+	refs := make(map[uint64]int)
+	sizes := make(map[uint64]int64)
+	catalogs := make(map[string][]CatalogEntry, len(snapshots))
+	for _, name := range snapshots {
+		entries, err := b.loadCatalog(name)
+		if err != nil {
+			return fmt.Errorf("failed to read catalog of %s: %v", name, err)
+		}
+		catalogs[name] = entries
+		for _, e := range entries {
+			refs[e.Inode]++
+			sizes[e.Inode] = e.Size
+		}
+	}
+	for name := range remove {
+		for _, e := range catalogs[name] {
+			refs[e.Inode]--
+		}
+	}
+	var freed int64
+	for inode, count := range refs {
+		if count == 0 {
+			freed += sizes[inode]
+		}
+	}
+
+	var previous string
+	for i, name := range snapshots {
+		if remove[name] {
+			b.removeSnapshot(name, fmt.Sprintf("compact, one per quarter after %d years", *years))
+			continue
+		}
+		// The change list of the next kept snapshot referred to a removed one
+		if i > 0 && remove[snapshots[i-1]] && !b.config.DryRun {
+			changes := diffCatalogs(catalogs[previous], catalogs[name])
+			if err := saveChanges(filepath.Join(b.config.Destination, name), changes); err != nil {
+				b.log("Warning: failed to rewrite changes of %s: %v", name, err)
+			} else if previous == "" {
+				b.log("Changes of %s rewritten, it is now the oldest snapshot", name)
+			} else {
+				b.log("Changes of %s rewritten against %s: %d paths", name, previous, len(changes))
+			}
+		}
+		previous = name
+	}
+
+	if b.config.DryRun {
+		b.log("Compact dry run: %d snapshots would be removed, freeing %.2f GB", b.pruned, gib(freed))
+		return nil
+	}
+	b.purgeTrash()
+	if b.config.TrashRetention != "" {
+		b.log("Compacted %d snapshots, %.2f GB are freed when they leave the trash", b.pruned, gib(freed))
+	} else {
+		b.log("Compacted %d snapshots, freed %.2f GB", b.pruned, gib(freed))
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io/fs"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Compression algorithms rsync 3.2 and later accept for --compress-choice
+var compressChoices = []string{"zstd", "lz4", "zlibx", "zlib"}
+
+// Extensions of formats that are compressed already; rsync's own
+// skip-compress list without the rare ones
+var compressedExts = map[string]bool{
+	"7z": true, "aac": true, "avi": true, "bz2": true, "deb": true, "dmg": true,
+	"flac": true, "gz": true, "heic": true, "iso": true, "jpeg": true, "jpg": true,
+	"lz4": true, "m4a": true, "m4v": true, "mkv": true, "mov": true, "mp3": true,
+	"mp4": true, "mpeg": true, "mpg": true, "ogg": true, "opus": true, "png": true,
+	"rar": true, "rpm": true, "tgz": true, "webm": true, "webp": true, "xz": true,
+	"zip": true, "zst": true,
+}
+
+// Number of source files sampled, and the share of their bytes in
+// compressed formats from which compression is left off
+const (
+	mediaSampleFiles = 10000
+	mediaShare       = 0.8
+)
+
+// compressedShare returns the share of bytes in already compressed formats
+// among the first files of the sources, or 0 if nothing was sampled.
+func compressedShare(sources []string) float64 {
+	var total, compressed int64
+	files := 0
+	for _, source := range sources {
+		filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+			if files >= mediaSampleFiles {
+				return filepath.SkipAll
+			}
+			if err != nil || !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			files++
+			total += info.Size()
+			if compressedExts[strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))] {
+				compressed += info.Size()
+			}
+			return nil
+		})
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(compressed) / float64(total)
+}
+
+// rsyncCompressList returns the compression algorithms the rsync binary
+// was built with ("Compress list:" of rsync --version), nil if unknown.
+func (b *Backup) rsyncCompressList() []string {
+	output, err := exec.Command(b.config.RsyncBin, "--version").Output()
+	if err != nil {
+		return nil
+	}
+	_, list, ok := strings.Cut(string(output), "Compress list:")
+	if !ok {
+		return nil
+	}
+	line, _, _ := strings.Cut(strings.TrimLeft(list, " \n"), "\n")
+	return strings.Fields(line)
+}
+
+// compressArgs returns the rsync options compressing an SSH transfer:
+// compress with the algorithm and level of compress and compress_level,
+// zlib at level 6 without them. Compression is left off for compress
+// "none" and, unless compress_media is set, for sources that consist mostly
+// of photos, videos and archives, which do not get smaller and only cost
+// CPU time.
+func (b *Backup) compressArgs() []string {
+	choice := b.config.Compress
+	if choice == "none" {
+		return nil
+	}
+	if !b.config.CompressMedia && !b.hasSSHSource() {
+		if share := compressedShare(b.sourcePaths()); share >= mediaShare {
+			b.log("Source is %.0f%% compressed media - transferring without compression (compress_media to override)", share*100)
+			return nil
+		}
+	}
+
+	if choice == "" {
+		if b.config.CompressLevel == 0 {
+			return RsyncSSHArgs
+		}
+		return []string{"-z", "--compress-level=" + strconv.Itoa(b.config.CompressLevel)}
+	}
+
+	b.probeRsync()
+	available := b.rsyncCompressList()
+	switch {
+	case len(b.rsyncOptions) > 0 && !b.rsyncOptions["compress-choice"]:
+		b.log("Warning: %s does not support --compress-choice, using zlib instead of %s", b.config.RsyncBin, choice)
+	case available != nil && !slices.Contains(available, choice):
+		b.log("Warning: %s was built without %s compression (has: %s), using zlib", b.config.RsyncBin, choice, strings.Join(available, " "))
+	default:
+		args := []string{"-z", "--compress-choice=" + choice}
+		if b.config.CompressLevel != 0 {
+			args = append(args, "--compress-level="+strconv.Itoa(b.config.CompressLevel))
+		}
+		return args
+	}
+	// compress_level was chosen for the other algorithm, use zlib's default
+	return RsyncSSHArgs
+}
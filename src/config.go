@@ -1,63 +1,380 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
+	Name string
+	Jobs []Config // Run one after another, see loadJob
+
 	Source           string
+	Sources          []string
 	Destination      string
 	Keep             int
 	CleanupAtPercent int
 	ExcludeList      string
 	LogFile          string
+	CombinedLogFile  string
+	HistoryFile      string
+	AuditLog         string
+	APISocket        string
+	APISocketGroup   string
 	LockFile         string
 	DryRun           bool
 	ForceSystemRsync bool
 	ShowProgress     bool
 	RsyncBin         string
+	MinRsyncVersion  string
+
+	ExcludeBackupStores bool
+
+	PrivilegedCommand string
+
+	CPUQuota  string
+	IOWeight  int
+	MemoryMax string
+
+	SSHAddressFamily string
+
+	Compress      string
+	CompressLevel int
+	CompressMedia bool
+
+	SigningKey       string
+	SigningPublicKey string
+
+	ChangeCache      string
+	FullScanInterval string
+
+	RsyncOutputDir       string
+	RsyncOutputRetention string
+
+	APFSSnapshot bool
+	QuiesceApps  []string
+
+	SafetyDryRun     bool
+	MaxDeletePercent int
+
+	VerifySampleFiles  int
+	VerifySampleHash   bool
+	VerifyChangedFiles bool
+
+	VerifyWorkers   int
+	VerifyRateLimit string
+
+	MaxRepositorySize string
+	MinFreeSpace      string
+	MaxTransferPerRun string
+	MaxRunTime        string
+	Thinning          []ThinningRule
+	TrashRetention    string
+
+	MaxSnapshotAge string
+	MinInterval    string
+	SkipUnchanged  bool
+
+	MaxLoad       float64
+	MaxIOPressure int
+	MinIdle       string
+	DeferMaxWait  string
+
+	PerHostLayout   bool
+	SnapshotPrefix  string
+	RepositoryOwner string
+
+	CopyLinks       bool
+	KeepDirlinks    bool
+	PreserveCrtimes bool
+	LinkDestCount   int
+	DeleteMode      string
+	KeepExcluded    bool
+
+	MinSourceFiles int
+	CanaryFile     string
+
+	Assertions []Assertion
+
+	Env map[string]string
+
+	OffsiteDestination string
+	SeedRepository     string
+	SeedingMode        bool
+	SeedTransferCap    string
+
+	MaxChangedPercent int
+	AlertCommand      string
+
+	RetentionWarningDays int
+
+	SnapshotLog    bool
+	SystemManifest bool
+
+	AllowIndexing               bool
+	EjectAfterBackup            bool
+	RequireEncryptedDestination bool
+
+	// Set from the command line (-wait-lock, -deadline) only
+	WaitLock        bool
+	WaitLockTimeout time.Duration
+	Deadline        time.Time
+}
+
+// ThinningRule keeps at most one snapshot per Every for snapshots younger
+// than Within, e.g. {"within": "24h", "every": "1h"}.
+type ThinningRule struct {
+	Within string `json:"within"`
+	Every  string `json:"every"`
 }
 
 type ConfigFile struct {
-	Source           string `json:"source"`
-	Destination      string `json:"destination"`
-	Keep             int    `json:"keep"`
-	CleanupAtPercent int    `json:"cleanup_at_percent"`
-	ExcludeList      string `json:"exclude_list"`
-	LogFile          string `json:"log_file"`
-	LockFile         string `json:"lock_file"`
-	DryRun           bool   `json:"dry_run"`
-	ForceSystemRsync bool   `json:"force_system_rsync"`
-	ShowProgress     bool   `json:"show_progress"`
+	Name string            `json:"name"`
+	Jobs []json.RawMessage `json:"jobs"`
+
+	Source           string   `json:"source"`
+	Sources          []string `json:"sources"`
+	Destination      string   `json:"destination"`
+	Keep             int      `json:"keep"`
+	CleanupAtPercent int      `json:"cleanup_at_percent"`
+	ExcludeList      string   `json:"exclude_list"`
+	LogFile          string   `json:"log_file"`
+	CombinedLogFile  string   `json:"combined_log_file"`
+	HistoryFile      string   `json:"history_file"`
+	AuditLog         string   `json:"audit_log"`
+	APISocket        string   `json:"api_socket"`
+	APISocketGroup   string   `json:"api_socket_group"`
+	LockFile         string   `json:"lock_file"`
+	DryRun           bool     `json:"dry_run"`
+	ForceSystemRsync bool     `json:"force_system_rsync"`
+	RsyncBin         string   `json:"rsync_bin"`
+	MinRsyncVersion  string   `json:"min_rsync_version"`
+	ShowProgress     bool     `json:"show_progress"`
+
+	ExcludeBackupStores bool `json:"exclude_backup_stores"`
+
+	PrivilegedCommand string `json:"privileged_command"`
+
+	CPUQuota  string `json:"cpu_quota"`
+	IOWeight  int    `json:"io_weight"`
+	MemoryMax string `json:"memory_max"`
+
+	SSHAddressFamily string `json:"ssh_address_family"`
+
+	Compress      string `json:"compress"`
+	CompressLevel int    `json:"compress_level"`
+	CompressMedia bool   `json:"compress_media"`
+
+	SigningKey       string `json:"signing_key"`
+	SigningPublicKey string `json:"signing_public_key"`
+
+	ChangeCache      string `json:"change_cache"`
+	FullScanInterval string `json:"full_scan_interval"`
+
+	RsyncOutputDir       string `json:"rsync_output_dir"`
+	RsyncOutputRetention string `json:"rsync_output_retention"`
+
+	APFSSnapshot bool     `json:"apfs_snapshot"`
+	QuiesceApps  []string `json:"quiesce_apps"`
+
+	SafetyDryRun     bool `json:"safety_dry_run"`
+	MaxDeletePercent int  `json:"max_delete_percent"`
+
+	VerifySampleFiles  int  `json:"verify_sample_files"`
+	VerifySampleHash   bool `json:"verify_sample_hash"`
+	VerifyChangedFiles bool `json:"verify_changed_files"`
+
+	VerifyWorkers   int    `json:"verify_workers"`
+	VerifyRateLimit string `json:"verify_rate_limit"`
+
+	MaxRepositorySize string         `json:"max_repository_size"`
+	MinFreeSpace      string         `json:"min_free_space"`
+	MaxTransferPerRun string         `json:"max_transfer_per_run"`
+	MaxRunTime        string         `json:"max_run_time"`
+	Thinning          []ThinningRule `json:"thinning"`
+	TrashRetention    string         `json:"trash_retention"`
+
+	MaxSnapshotAge string `json:"max_snapshot_age"`
+	MinInterval    string `json:"min_interval"`
+	SkipUnchanged  bool   `json:"skip_unchanged"`
+
+	MaxLoad       float64 `json:"max_load"`
+	MaxIOPressure int     `json:"max_io_pressure"`
+	MinIdle       string  `json:"min_idle"`
+	DeferMaxWait  string  `json:"defer_max_wait"`
+
+	PerHostLayout   bool   `json:"per_host_layout"`
+	SnapshotPrefix  string `json:"snapshot_prefix"`
+	RepositoryOwner string `json:"repository_owner"`
+
+	CopyLinks       bool   `json:"copy_links"`
+	KeepDirlinks    bool   `json:"keep_dirlinks"`
+	PreserveCrtimes bool   `json:"preserve_crtimes"`
+	LinkDestCount   int    `json:"link_dest_count"`
+	DeleteMode      string `json:"delete_mode"`
+	KeepExcluded    bool   `json:"keep_excluded"`
+
+	MinSourceFiles int    `json:"min_source_files"`
+	CanaryFile     string `json:"canary_file"`
+
+	Assertions []Assertion `json:"assertions"`
+
+	Env map[string]string `json:"env"`
+
+	OffsiteDestination string `json:"offsite_destination"`
+	SeedRepository     string `json:"seed_repository"`
+	SeedingMode        bool   `json:"seeding_mode"`
+	SeedTransferCap    string `json:"seed_transfer_cap"`
+
+	MaxChangedPercent int    `json:"max_changed_percent"`
+	AlertCommand      string `json:"alert_command"`
+
+	RetentionWarningDays int `json:"retention_warning_days"`
+
+	SnapshotLog    bool `json:"snapshot_log"`
+	SystemManifest bool `json:"system_manifest"`
+
+	AllowIndexing               bool `json:"allow_indexing"`
+	EjectAfterBackup            bool `json:"eject_after_backup"`
+	RequireEncryptedDestination bool `json:"require_encrypted_destination"`
 }
 
 func LoadConfig(filename string) (Config, error) {
 	config := DefaultConfig
 
-	// Try to load from file
+	// Load from file (or conf.d style directory); any error is fatal so a
+	// typo never silently falls back to the defaults
 	if filename != "" {
-		if data, err := os.ReadFile(filename); err == nil {
-			var configFile ConfigFile
-			if err := json.Unmarshal(data, &configFile); err == nil {
-				config.Source = configFile.Source
-				config.Destination = configFile.Destination
-				config.Keep = configFile.Keep
-				config.CleanupAtPercent = configFile.CleanupAtPercent
-				config.ExcludeList = configFile.ExcludeList
-				config.LockFile = configFile.LockFile
-				config.LogFile = configFile.LogFile
-				config.DryRun = configFile.DryRun
-				config.ForceSystemRsync = configFile.ForceSystemRsync
-				config.ShowProgress = configFile.ShowProgress
+		data, err := readConfigData(filename)
+		if err != nil {
+			return config, fmt.Errorf("failed to read %s: %v", filename, err)
+		}
+		configFile, err := decodeConfigFile(data, filename)
+		if err != nil {
+			return config, err
+		}
+		applyConfigFile(&config, configFile)
+
+		// Each job inherits the top-level settings and overrides some
+		for i, raw := range configFile.Jobs {
+			job, err := loadJob(data, raw, filename, i)
+			if err != nil {
+				return config, err
 			}
+			config.Jobs = append(config.Jobs, job)
+		}
+		if len(config.Jobs) > 0 {
+			return config, checkJobs(config.Jobs)
 		}
 	}
+	return config, finishConfig(&config, filename)
+}
+
+// applyConfigFile copies the settings of a configuration file into config.
+func applyConfigFile(config *Config, configFile ConfigFile) {
+	config.Name = configFile.Name
+	config.Source = configFile.Source
+	config.Sources = configFile.Sources
+	config.Destination = configFile.Destination
+	config.Keep = configFile.Keep
+	config.CleanupAtPercent = configFile.CleanupAtPercent
+	config.ExcludeList = configFile.ExcludeList
+	config.ExcludeBackupStores = configFile.ExcludeBackupStores
+	config.PrivilegedCommand = configFile.PrivilegedCommand
+	config.CPUQuota = configFile.CPUQuota
+	config.IOWeight = configFile.IOWeight
+	config.MemoryMax = configFile.MemoryMax
+	config.SSHAddressFamily = configFile.SSHAddressFamily
+	config.Compress = configFile.Compress
+	config.CompressLevel = configFile.CompressLevel
+	config.CompressMedia = configFile.CompressMedia
+	config.SigningKey = configFile.SigningKey
+	config.SigningPublicKey = configFile.SigningPublicKey
+	config.ChangeCache = configFile.ChangeCache
+	config.FullScanInterval = configFile.FullScanInterval
+	config.RsyncOutputDir = configFile.RsyncOutputDir
+	config.RsyncOutputRetention = configFile.RsyncOutputRetention
+	config.APFSSnapshot = configFile.APFSSnapshot
+	config.QuiesceApps = configFile.QuiesceApps
+	config.SafetyDryRun = configFile.SafetyDryRun
+	config.MaxDeletePercent = configFile.MaxDeletePercent
+	config.LockFile = configFile.LockFile
+	config.LogFile = configFile.LogFile
+	config.CombinedLogFile = configFile.CombinedLogFile
+	config.HistoryFile = configFile.HistoryFile
+	config.AuditLog = configFile.AuditLog
+	config.APISocket = configFile.APISocket
+	config.APISocketGroup = configFile.APISocketGroup
+	config.DryRun = configFile.DryRun
+	config.ForceSystemRsync = configFile.ForceSystemRsync
+	config.RsyncBin = configFile.RsyncBin
+	config.MinRsyncVersion = configFile.MinRsyncVersion
+	config.ShowProgress = configFile.ShowProgress
+	config.VerifySampleFiles = configFile.VerifySampleFiles
+	config.VerifySampleHash = configFile.VerifySampleHash
+	config.VerifyChangedFiles = configFile.VerifyChangedFiles
+	config.VerifyWorkers = configFile.VerifyWorkers
+	config.VerifyRateLimit = configFile.VerifyRateLimit
+	config.MaxRepositorySize = configFile.MaxRepositorySize
+	config.MinFreeSpace = configFile.MinFreeSpace
+	config.MaxTransferPerRun = configFile.MaxTransferPerRun
+	config.MaxRunTime = configFile.MaxRunTime
+	config.Thinning = configFile.Thinning
+	config.TrashRetention = configFile.TrashRetention
+	config.MaxSnapshotAge = configFile.MaxSnapshotAge
+	config.MinInterval = configFile.MinInterval
+	config.SkipUnchanged = configFile.SkipUnchanged
+	config.MaxLoad = configFile.MaxLoad
+	config.MaxIOPressure = configFile.MaxIOPressure
+	config.MinIdle = configFile.MinIdle
+	config.DeferMaxWait = configFile.DeferMaxWait
+	config.PerHostLayout = configFile.PerHostLayout
+	config.SnapshotPrefix = configFile.SnapshotPrefix
+	config.RepositoryOwner = configFile.RepositoryOwner
+	config.CopyLinks = configFile.CopyLinks
+	config.KeepDirlinks = configFile.KeepDirlinks
+	config.PreserveCrtimes = configFile.PreserveCrtimes
+	config.LinkDestCount = configFile.LinkDestCount
+	config.DeleteMode = configFile.DeleteMode
+	config.KeepExcluded = configFile.KeepExcluded
+	config.MinSourceFiles = configFile.MinSourceFiles
+	config.CanaryFile = configFile.CanaryFile
+	config.Assertions = configFile.Assertions
+	config.Env = configFile.Env
+	config.OffsiteDestination = configFile.OffsiteDestination
+	config.SeedRepository = configFile.SeedRepository
+	config.SeedingMode = configFile.SeedingMode
+	config.SeedTransferCap = configFile.SeedTransferCap
+	config.MaxChangedPercent = configFile.MaxChangedPercent
+	config.AlertCommand = configFile.AlertCommand
+	config.RetentionWarningDays = configFile.RetentionWarningDays
+	config.SnapshotLog = configFile.SnapshotLog
+	config.SystemManifest = configFile.SystemManifest
+	config.AllowIndexing = configFile.AllowIndexing
+	config.EjectAfterBackup = configFile.EjectAfterBackup
+	config.RequireEncryptedDestination = configFile.RequireEncryptedDestination
+}
+
+// finishConfig expands the placeholders of a loaded configuration and
+// checks the required settings.
+func finishConfig(config *Config, filename string) error {
+	// Fill in {hostname}, {job} and {date} in path settings
+	if err := expandPlaceholders(config, filename); err != nil {
+		return err
+	}
 
 	// Basic validation
-	if config.Source == "" || config.Destination == "" {
-		return config, fmt.Errorf("source and destination paths are required")
+	if (config.Source == "" && len(config.Sources) == 0) || config.Destination == "" {
+		return fmt.Errorf("source and destination paths are required")
 	}
 	if config.Keep < 1 {
 		config.Keep = 7 // Set reasonable default
@@ -65,27 +382,289 @@ func LoadConfig(filename string) (Config, error) {
 	if config.CleanupAtPercent < 50 || config.CleanupAtPercent > 95 {
 		config.CleanupAtPercent = 90 // Set reasonable default
 	}
+	return nil
+}
+
+// decodeConfigFile parses configuration JSON strictly: unknown keys and
+// values of the wrong type are errors that name the key and its line.
+func decodeConfigFile(data []byte, filename string) (ConfigFile, error) {
+	var configFile ConfigFile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&configFile)
+	if err == nil {
+		if decoder.More() {
+			return configFile, fmt.Errorf("%s: unexpected data after the configuration object", filename)
+		}
+		return configFile, nil
+	}
 
-	return config, nil
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return configFile, fmt.Errorf("%s:%d: %v", filename, lineAt(data, syntaxErr.Offset), err)
+	case errors.As(err, &typeErr):
+		return configFile, fmt.Errorf("%s:%d: %q must be of type %s, not %s",
+			filename, lineAt(data, typeErr.Offset), typeErr.Field, typeErr.Type, typeErr.Value)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		key := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		offset := int64(bytes.Index(data, []byte(key)))
+		return configFile, fmt.Errorf("%s:%d: unknown option %s", filename, lineAt(data, offset), key)
+	}
+	return configFile, fmt.Errorf("%s: %v", filename, err)
+}
+
+// lineAt returns the 1-based line number of a byte offset in data.
+func lineAt(data []byte, offset int64) int {
+	if offset < 0 || offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// Extensions of configuration formats other than JSON. Such files in a
+// configuration directory are refused rather than silently skipped.
+var otherConfigExtensions = []string{".yaml", ".yml", ".toml", ".ini", ".conf"}
+
+// readConfigData returns the JSON configuration stored in filename. If
+// filename is a directory, all *.json files in it are merged in lexical
+// order; keys in later files override earlier ones (e.g. 10-base.json,
+// 50-nas.json), except jobs, which are collected from all files.
+func readConfigData(filename string) ([]byte, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return os.ReadFile(filename)
+	}
+
+	entries, err := os.ReadDir(filename)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		switch {
+		case entry.IsDir():
+		case ext == ".json":
+			files = append(files, filepath.Join(filename, entry.Name()))
+		case slices.Contains(otherConfigExtensions, ext):
+			return nil, fmt.Errorf("%s: only JSON configuration files (*.json) are supported", filepath.Join(filename, entry.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no *.json files in %s", filename)
+	}
+	sort.Strings(files)
+
+	merged := make(map[string]json.RawMessage)
+	var jobs []json.RawMessage
+	jobFiles := make(map[string]string)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		// Check every file on its own so errors point to the right line
+		configFile, err := decodeConfigFile(data, file)
+		if err != nil {
+			return nil, err
+		}
+		var part map[string]json.RawMessage
+		if err := json.Unmarshal(data, &part); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		for key, value := range part {
+			merged[key] = value
+		}
+
+		// Each file can add jobs, e.g. one file per job
+		for _, raw := range configFile.Jobs {
+			var job struct {
+				Name string `json:"name"`
+			}
+			json.Unmarshal(raw, &job)
+			if other, ok := jobFiles[job.Name]; ok && other != file && job.Name != "" {
+				return nil, fmt.Errorf("%s: job %s is already defined in %s", file, job.Name, other)
+			}
+			jobFiles[job.Name] = file
+			jobs = append(jobs, raw)
+		}
+	}
+	if len(jobs) > 0 {
+		data, err := json.Marshal(jobs)
+		if err != nil {
+			return nil, err
+		}
+		merged["jobs"] = data
+	}
+	return json.Marshal(merged)
+}
+
+// ParseSize converts a size like "500G", "1.5T", "200GB" or "1048576" into
+// bytes. Units are binary (1K = 1024 bytes).
+func ParseSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+
+	multiplier := int64(1)
+	if n := len(value); n > 0 {
+		if idx := strings.IndexByte("KMGTP", value[n-1]); idx >= 0 {
+			for i := 0; i <= idx; i++ {
+				multiplier *= 1024
+			}
+			value = value[:n-1]
+		}
+	}
+
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %q (use e.g. 500G or 1.5T)", s)
+	}
+	return int64(number * float64(multiplier)), nil
+}
+
+// ParseDuration extends time.ParseDuration with the units d (days) and
+// w (weeks), e.g. "30d" or "2w".
+func ParseDuration(s string) (time.Duration, error) {
+	value := strings.TrimSpace(s)
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if number, ok := strings.CutSuffix(value, suffix); ok {
+			n, err := strconv.ParseFloat(number, 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(n * float64(unit)), nil
+		}
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q (use e.g. 1h, 30d or 2w)", s)
+	}
+	return d, nil
 }
 
 func SaveConfig(config Config, filename string) error {
-	configFile := ConfigFile{
+	data, err := json.MarshalIndent(toConfigFile(config), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, data, 0644)
+}
+
+// toConfigFile converts a Config into its JSON representation.
+func toConfigFile(config Config) ConfigFile {
+	return ConfigFile{
+		Name: config.Name,
+
 		Source:           config.Source,
+		Sources:          config.Sources,
 		Destination:      config.Destination,
 		Keep:             config.Keep,
 		CleanupAtPercent: config.CleanupAtPercent,
 		ExcludeList:      config.ExcludeList,
 		LockFile:         config.LockFile,
 		LogFile:          config.LogFile,
+		CombinedLogFile:  config.CombinedLogFile,
+		HistoryFile:      config.HistoryFile,
+		AuditLog:         config.AuditLog,
+		APISocket:        config.APISocket,
+		APISocketGroup:   config.APISocketGroup,
 		DryRun:           config.DryRun,
 		ForceSystemRsync: config.ForceSystemRsync,
-	}
+		RsyncBin:         config.RsyncBin,
+		MinRsyncVersion:  config.MinRsyncVersion,
 
-	data, err := json.MarshalIndent(configFile, "", "  ")
-	if err != nil {
-		return err
-	}
+		ExcludeBackupStores: config.ExcludeBackupStores,
 
-	return os.WriteFile(filename, data, 0644)
+		PrivilegedCommand: config.PrivilegedCommand,
+
+		CPUQuota:  config.CPUQuota,
+		IOWeight:  config.IOWeight,
+		MemoryMax: config.MemoryMax,
+
+		SSHAddressFamily: config.SSHAddressFamily,
+
+		Compress:      config.Compress,
+		CompressLevel: config.CompressLevel,
+		CompressMedia: config.CompressMedia,
+
+		SigningKey:       config.SigningKey,
+		SigningPublicKey: config.SigningPublicKey,
+
+		ChangeCache:      config.ChangeCache,
+		FullScanInterval: config.FullScanInterval,
+
+		RsyncOutputDir:       config.RsyncOutputDir,
+		RsyncOutputRetention: config.RsyncOutputRetention,
+
+		APFSSnapshot: config.APFSSnapshot,
+		QuiesceApps:  config.QuiesceApps,
+
+		SafetyDryRun:     config.SafetyDryRun,
+		MaxDeletePercent: config.MaxDeletePercent,
+
+		VerifySampleFiles:  config.VerifySampleFiles,
+		VerifySampleHash:   config.VerifySampleHash,
+		VerifyChangedFiles: config.VerifyChangedFiles,
+
+		VerifyWorkers:   config.VerifyWorkers,
+		VerifyRateLimit: config.VerifyRateLimit,
+
+		MaxRepositorySize: config.MaxRepositorySize,
+		MinFreeSpace:      config.MinFreeSpace,
+		MaxTransferPerRun: config.MaxTransferPerRun,
+		MaxRunTime:        config.MaxRunTime,
+		Thinning:          config.Thinning,
+		TrashRetention:    config.TrashRetention,
+
+		MaxSnapshotAge: config.MaxSnapshotAge,
+		MinInterval:    config.MinInterval,
+		SkipUnchanged:  config.SkipUnchanged,
+
+		MaxLoad:       config.MaxLoad,
+		MaxIOPressure: config.MaxIOPressure,
+		MinIdle:       config.MinIdle,
+		DeferMaxWait:  config.DeferMaxWait,
+
+		PerHostLayout:   config.PerHostLayout,
+		SnapshotPrefix:  config.SnapshotPrefix,
+		RepositoryOwner: config.RepositoryOwner,
+
+		CopyLinks:       config.CopyLinks,
+		KeepDirlinks:    config.KeepDirlinks,
+		PreserveCrtimes: config.PreserveCrtimes,
+		LinkDestCount:   config.LinkDestCount,
+		DeleteMode:      config.DeleteMode,
+		KeepExcluded:    config.KeepExcluded,
+
+		MinSourceFiles: config.MinSourceFiles,
+		CanaryFile:     config.CanaryFile,
+
+		Assertions: config.Assertions,
+
+		Env: config.Env,
+
+		OffsiteDestination: config.OffsiteDestination,
+		SeedRepository:     config.SeedRepository,
+		SeedingMode:        config.SeedingMode,
+		SeedTransferCap:    config.SeedTransferCap,
+
+		MaxChangedPercent: config.MaxChangedPercent,
+		AlertCommand:      config.AlertCommand,
+
+		RetentionWarningDays: config.RetentionWarningDays,
+
+		SnapshotLog:    config.SnapshotLog,
+		SystemManifest: config.SystemManifest,
+
+		AllowIndexing:               config.AllowIndexing,
+		EjectAfterBackup:            config.EjectAfterBackup,
+		RequireEncryptedDestination: config.RequireEncryptedDestination,
+	}
 }
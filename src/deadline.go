@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// deadlineFlag implements -deadline: a duration counted from the start of
+// the invocation (e.g. 4h) or a time of day (e.g. 06:00, the next time it
+// comes). Jobs are not started after it and a running transfer is stopped
+// at it, so a run stays inside its maintenance window.
+type deadlineFlag struct {
+	value string
+	at    time.Time
+}
+
+func (f *deadlineFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.value
+}
+
+func (f *deadlineFlag) Set(value string) error {
+	now := time.Now()
+	if clock, err := time.ParseInLocation("15:04", value, time.Local); err == nil {
+		at := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, time.Local)
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+		f.value, f.at = value, at
+		return nil
+	}
+	duration, err := ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return fmt.Errorf("invalid deadline %q (use e.g. 4h or 06:00)", value)
+	}
+	f.value, f.at = value, now.Add(duration)
+	return nil
+}
+
+// deadlinePassed reports whether the -deadline of the invocation has
+// passed.
+func deadlinePassed(config Config) bool {
+	return !config.Deadline.IsZero() && !time.Now().Before(config.Deadline)
+}
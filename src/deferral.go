@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Interval in which the load and idle conditions are checked again while a
+// run is deferred
+const DeferCheckInterval = time.Minute
+
+// deferUntilQuiet waits until the machine is quiet enough for a backup: the
+// load average is at most max_load, the IO pressure at most max_io_pressure
+// and the user has been idle for at least min_idle. The conditions are
+// checked every minute for up to defer_max_wait. It returns false if they
+// were not met in time, in which case the run is skipped.
+func (b *Backup) deferUntilQuiet() bool {
+	if b.config.MaxLoad <= 0 && b.config.MaxIOPressure <= 0 && b.config.MinIdle == "" {
+		return true
+	}
+	var maxWait time.Duration
+	if b.config.DeferMaxWait != "" {
+		maxWait, _ = ParseDuration(b.config.DeferMaxWait)
+	}
+
+	deadline := time.Now().Add(maxWait)
+	for {
+		reason := b.busyReason()
+		if reason == "" {
+			return true
+		}
+		if time.Now().Add(DeferCheckInterval).After(deadline) {
+			b.log("Skipping backup: %s", reason)
+			return false
+		}
+		b.log("Deferring backup: %s", reason)
+		time.Sleep(DeferCheckInterval)
+	}
+}
+
+// busyReason returns why the machine is too busy for a backup right now, or
+// an empty string if all configured conditions are met. Conditions that
+// cannot be measured on this system are logged and ignored.
+func (b *Backup) busyReason() string {
+	if b.config.MaxLoad > 0 {
+		load, err := loadAverage()
+		if err != nil {
+			b.log("Warning: cannot determine load average: %v", err)
+		} else if load > b.config.MaxLoad {
+			return fmt.Sprintf("load average %.2f is above max_load %g", load, b.config.MaxLoad)
+		}
+	}
+
+	if b.config.MaxIOPressure > 0 {
+		pressure, err := ioPressure()
+		if err != nil {
+			b.log("Warning: cannot determine IO pressure: %v", err)
+		} else if pressure > float64(b.config.MaxIOPressure) {
+			return fmt.Sprintf("IO pressure %.1f%% is above max_io_pressure %d%%", pressure, b.config.MaxIOPressure)
+		}
+	}
+
+	if b.config.MinIdle != "" {
+		minIdle, _ := ParseDuration(b.config.MinIdle)
+		idle, err := idleTime()
+		if err != nil {
+			b.log("Warning: cannot determine idle time: %v", err)
+		} else if idle < minIdle {
+			return fmt.Sprintf("user was active %s ago (min_idle: %s)", idle.Round(time.Second), b.config.MinIdle)
+		}
+	}
+	return ""
+}
+
+// loadAverage returns the 1-minute load average.
+func loadAverage() (float64, error) {
+	var fields []string
+	switch runtime.GOOS {
+	case "linux":
+		data, err := os.ReadFile("/proc/loadavg")
+		if err != nil {
+			return 0, err
+		}
+		fields = strings.Fields(string(data))
+	case "darwin":
+		// Prints e.g. "{ 1.52 1.61 1.70 }"
+		output, err := exec.Command("sysctl", "-n", "vm.loadavg").Output()
+		if err != nil {
+			return 0, err
+		}
+		fields = strings.Fields(strings.Trim(strings.TrimSpace(string(output)), "{}"))
+	default:
+		return 0, fmt.Errorf("not supported on %s", runtime.GOOS)
+	}
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected format")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// ioPressure returns the share of the last 10 seconds in which at least one
+// task was stalled on IO, from the Linux pressure stall information.
+func ioPressure() (float64, error) {
+	data, err := os.ReadFile("/proc/pressure/io")
+	if err != nil {
+		return 0, err
+	}
+	// some avg10=1.23 avg60=0.80 avg300=0.40 total=123456
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "some" {
+			continue
+		}
+		if value, ok := strings.CutPrefix(fields[1], "avg10="); ok {
+			return strconv.ParseFloat(value, 64)
+		}
+	}
+	return 0, fmt.Errorf("unexpected format")
+}
+
+var hidIdleTimeRe = regexp.MustCompile(`"HIDIdleTime" = (\d+)`)
+
+// idleTime returns how long ago the user last used keyboard or mouse. It
+// uses the HID idle time on macOS and xprintidle for X11 sessions on Linux.
+func idleTime() (time.Duration, error) {
+	switch {
+	case runtime.GOOS == "darwin":
+		output, err := exec.Command("ioreg", "-c", "IOHIDSystem", "-d", "4").Output()
+		if err != nil {
+			return 0, err
+		}
+		m := hidIdleTimeRe.FindSubmatch(output)
+		if m == nil {
+			return 0, fmt.Errorf("HIDIdleTime not found")
+		}
+		ns, err := strconv.ParseInt(string(m[1]), 10, 64)
+		return time.Duration(ns), err
+	case runtime.GOOS == "linux" && commandExists("xprintidle"):
+		output, err := exec.Command("xprintidle").Output()
+		if err != nil {
+			return 0, err
+		}
+		ms, err := strconv.ParseInt(strings.TrimSpace(string(output)), 10, 64)
+		return time.Duration(ms) * time.Millisecond, err
+	default:
+		return 0, fmt.Errorf("not supported on %s (Linux requires xprintidle)", runtime.GOOS)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// Filesystem types of SMB/CIFS network shares (Linux, macOS).
+var smbFSTypes = []string{"cifs", "smb3", "smbfs"}
+
+// destCaps describes what the filesystem of a local destination supports.
+type destCaps struct {
+	fsType    string
+	hardLinks bool
+	symlinks  bool
+	metadata  bool // ACLs and macOS file flags
+}
+
+// fsType returns the type of the filesystem holding path (e.g. "ext4",
+// "apfs", "cifs"), or "" if it cannot be determined.
+func fsType(path string) string {
+	mount, err := mountPoint(path)
+	if err != nil {
+		return ""
+	}
+
+	if runtime.GOOS == "linux" {
+		data, err := os.ReadFile("/proc/self/mounts")
+		if err != nil {
+			return ""
+		}
+		unescape := strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+		fstype := ""
+		for _, line := range strings.Split(string(data), "\n") {
+			// The last entry wins for mount points mounted over
+			if fields := strings.Fields(line); len(fields) >= 3 && unescape.Replace(fields[1]) == mount {
+				fstype = fields[2]
+			}
+		}
+		return fstype
+	}
+
+	// BSD mount(8): "//user@server/share on /Volumes/share (smbfs, nodev, ...)"
+	output, err := exec.Command("mount").Output()
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		_, rest, ok := strings.Cut(line, " on "+mount+" (")
+		if ok {
+			fstype, _, _ := strings.Cut(rest, ",")
+			return strings.TrimSuffix(fstype, ")")
+		}
+	}
+	return ""
+}
+
+// destinationCaps probes the filesystem of a local destination once: hard
+// links and symlinks are tried out, ACLs and file flags are assumed to be
+// missing on SMB shares. It returns nil for remote destinations.
+func (b *Backup) destinationCaps() *destCaps {
+	if b.destCaps != nil || b.isSSHPath(b.config.Destination) {
+		return b.destCaps
+	}
+
+	caps := &destCaps{fsType: fsType(b.config.Destination), hardLinks: true, symlinks: true, metadata: true}
+	if slices.Contains(smbFSTypes, caps.fsType) {
+		caps.metadata = false
+	}
+	if f, err := os.CreateTemp(b.config.Destination, ".caps-probe-*"); err == nil {
+		f.Close()
+		defer os.Remove(f.Name())
+		link := f.Name() + ".link"
+		caps.hardLinks = os.Link(f.Name(), link) == nil
+		os.Remove(link)
+		caps.symlinks = os.Symlink(filepath.Base(f.Name()), link) == nil
+		os.Remove(link)
+	}
+	b.destCaps = caps
+
+	if !caps.metadata {
+		b.log("Destination is an SMB share (%s) - ACLs and file flags are not preserved", caps.fsType)
+	}
+	if !caps.hardLinks {
+		b.log("Warning: destination (%s) does not support hard links - every snapshot is a full copy; unchanged files are copied from the previous snapshot instead of being read from the source", caps.fsType)
+	}
+	if !caps.symlinks {
+		b.log("Destination (%s) does not support symlinks - no latest link, the newest snapshot is found by name", caps.fsType)
+	}
+	return caps
+}
+
+// adaptToDestination removes the rsync options the destination filesystem
+// cannot honour and replaces hard-linking against earlier snapshots by
+// copying from the previous one (--copy-dest).
+func (b *Backup) adaptToDestination(args []string) []string {
+	caps := b.destinationCaps()
+	if caps == nil {
+		return args
+	}
+
+	var adapted []string
+	linkDests := 0
+	for _, arg := range args {
+		switch {
+		case !caps.metadata && (arg == "-A" || arg == "--fileflags"):
+			continue
+		case !caps.hardLinks && arg == "-H":
+			continue
+		case !caps.hardLinks && strings.HasPrefix(arg, "--link-dest="):
+			// Copying from several snapshots gains nothing
+			if linkDests++; linkDests > 1 {
+				continue
+			}
+			arg = "--copy-dest=" + strings.TrimPrefix(arg, "--link-dest=")
+		}
+		adapted = append(adapted, arg)
+	}
+	return adapted
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// runDiffExport packages the paths created or modified between two
+// snapshots into an archive, so only the delta has to be handed over.
+// Deleted paths cannot be expressed in a tar archive and are written to a
+// text file next to it.
+func (b *Backup) runDiffExport(args []string) error {
+	fs := flag.NewFlagSet("diff-export", flag.ExitOnError)
+	to := fs.String("to", "", "Archive file to create (.tar.zst, .tar.gz or .tar)")
+	positional := parseArgs(fs, args)
+
+	if len(positional) != 2 || *to == "" {
+		return fmt.Errorf("usage: diff-export <older snapshot> <newer snapshot|latest> --to <file>")
+	}
+	older, err := b.resolveSnapshot(positional[0])
+	if err != nil {
+		return err
+	}
+	newer, err := b.resolveSnapshot(positional[1])
+	if err != nil {
+		return err
+	}
+	compressor, err := archiveCompressor(*to)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(*to); err == nil {
+		return fmt.Errorf("archive %s already exists", *to)
+	}
+
+	olderEntries, err := b.loadCatalog(filepath.Base(older))
+	if err != nil {
+		return err
+	}
+	newerEntries, err := b.loadCatalog(filepath.Base(newer))
+	if err != nil {
+		return err
+	}
+
+	var changed, deleted []string
+	for _, c := range diffCatalogs(olderEntries, newerEntries) {
+		if c.Kind == "deleted" {
+			deleted = append(deleted, c.Path)
+		} else {
+			changed = append(changed, c.Path)
+		}
+	}
+	if len(changed) == 0 && len(deleted) == 0 {
+		return fmt.Errorf("snapshots %s and %s do not differ", filepath.Base(older), filepath.Base(newer))
+	}
+
+	// Hand the paths to tar NUL-separated, as they may contain newlines
+	list, err := os.CreateTemp("", "diff-export-*.list")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(list.Name())
+	w := bufio.NewWriter(list)
+	for _, path := range changed {
+		fmt.Fprintf(w, "%s\x00", path)
+	}
+	if err := w.Flush(); err != nil {
+		list.Close()
+		return err
+	}
+	list.Close()
+
+	out, err := os.Create(*to)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %v", err)
+	}
+	defer out.Close()
+
+	tarArgs := append([]string{"-c", "-f", "-"}, tarMetadataArgs()...)
+	tarArgs = append(tarArgs, "-C", newer, "--no-recursion", "--null", "-T", list.Name())
+
+	b.log("Exporting %d changed paths between %s and %s to %s", len(changed), filepath.Base(older), filepath.Base(newer), *to)
+	if err := runPipeline(exec.Command("tar", tarArgs...), compressor, nil, out); err != nil {
+		os.Remove(*to)
+		return err
+	}
+
+	if len(deleted) > 0 {
+		deletedFile := *to + ".deleted.txt"
+		f, err := os.Create(deletedFile)
+		if err != nil {
+			return fmt.Errorf("failed to write list of deleted paths: %v", err)
+		}
+		defer f.Close()
+		w := bufio.NewWriter(f)
+		for _, path := range deleted {
+			fmt.Fprintln(w, path)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		b.log("%d deleted paths listed in %s", len(deleted), deletedFile)
+	}
+
+	b.log("Export completed: %s", *to)
+	return nil
+}
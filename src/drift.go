@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// driftKind classifies a path rsync would change in a snapshot as created
+// (new in the source), modified or deleted (gone from the source). Changed
+// directory attributes are not reported, they follow from their contents.
+func driftKind(c itemizedChange) string {
+	switch {
+	case c.deleted():
+		return "deleted"
+	case strings.Trim(c.Flags[2:], "+") == "":
+		return "created"
+	case c.Flags[1] == 'd':
+		return ""
+	}
+	return "modified"
+}
+
+// runDrift reports how the live source differs from a snapshot: what changed
+// since the backup, and what a full restore of the snapshot would overwrite.
+func (b *Backup) runDrift(args []string) error {
+	fs := flag.NewFlagSet("drift", flag.ExitOnError)
+	kind := fs.String("only", "", "Show only created, modified or deleted paths")
+	positional := parseArgs(fs, args)
+
+	if len(positional) > 1 {
+		return fmt.Errorf("usage: drift [snapshot|latest] [--only created|modified|deleted]")
+	}
+	if *kind != "" && *kind != "created" && *kind != "modified" && *kind != "deleted" {
+		return fmt.Errorf("--only must be created, modified or deleted")
+	}
+	name := "latest"
+	if len(positional) == 1 {
+		name = positional[0]
+	}
+	snapshot, err := b.resolveSnapshot(name)
+	if err != nil {
+		return err
+	}
+
+	if err := b.validateConfig(); err != nil {
+		return fmt.Errorf("config validation failed: %v", err)
+	}
+	if err := b.expandSources(); err != nil {
+		return fmt.Errorf("source expansion failed: %v", err)
+	}
+	if err := b.findRsync(); err != nil {
+		return fmt.Errorf("failed to find rsync: %v", err)
+	}
+	// Excludes a destination inside the source
+	if err := b.checkOverlap(); err != nil {
+		return err
+	}
+	b.excludeBackupStores()
+
+	changes, err := b.dryRunAgainst(filepath.Base(snapshot))
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[string]int)
+	for _, c := range changes {
+		k := driftKind(c)
+		if k == "" {
+			continue
+		}
+		counts[k]++
+		if *kind == "" || *kind == k {
+			fmt.Printf("%-9s %s\n", k, c.Path)
+		}
+	}
+	fmt.Printf("\nSince %s: %d created, %d modified, %d deleted\n",
+		filepath.Base(snapshot), counts["created"], counts["modified"], counts["deleted"])
+	fmt.Printf("A full restore would overwrite %d modified paths, bring back %d deleted ones and leave %d created ones (removed with --delete).\n",
+		counts["modified"], counts["deleted"], counts["created"])
+	return nil
+}
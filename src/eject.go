@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// mountPoint returns the root of the filesystem containing path.
+func mountPoint(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return "", err
+	}
+	for path != "/" {
+		parent := filepath.Dir(path)
+		var pst syscall.Stat_t
+		if err := syscall.Stat(parent, &pst); err != nil {
+			return "", err
+		}
+		if pst.Dev != st.Dev {
+			return path, nil
+		}
+		path = parent
+	}
+	return path, nil
+}
+
+// ejectDestination unmounts and ejects the volume holding the local
+// repository so a rotation disk can be unplugged right after the run. The
+// central log file is closed first, as it usually lives on that volume.
+func (b *Backup) ejectDestination() error {
+	if b.isSSHPath(b.config.Destination) {
+		return fmt.Errorf("eject_after_backup requires a local destination")
+	}
+	mount, err := mountPoint(b.config.Destination)
+	if err != nil {
+		return err
+	}
+	if mount == "/" {
+		return fmt.Errorf("destination %s is on the root filesystem", b.config.Destination)
+	}
+
+	b.log("Ejecting %s", mount)
+	b.closeLogging()
+
+	var output []byte
+	switch {
+	case runtime.GOOS == "darwin":
+		output, err = exec.Command("diskutil", "eject", mount).CombinedOutput()
+	case commandExists("udisksctl") && commandExists("findmnt"):
+		var device []byte
+		if device, err = exec.Command("findmnt", "-n", "-o", "SOURCE", "--target", mount).Output(); err != nil {
+			return fmt.Errorf("failed to find device of %s: %v", mount, err)
+		}
+		dev := strings.TrimSpace(string(device))
+		if output, err = exec.Command("udisksctl", "unmount", "-b", dev).CombinedOutput(); err == nil {
+			// Unmounted; spinning the drive down is optional
+			exec.Command("udisksctl", "power-off", "-b", dev).Run()
+		}
+	default:
+		output, err = exec.Command("umount", mount).CombinedOutput()
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	b.log("Ejected %s, the disk can be removed", mount)
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// volumeEncrypted reports whether the filesystem containing path is
+// encrypted at rest: FileVault or an encrypted APFS volume on macOS, a
+// dm-crypt (LUKS) device anywhere below the filesystem on Linux.
+func volumeEncrypted(path string) (bool, error) {
+	mount, err := mountPoint(path)
+	if err != nil {
+		return false, err
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		output, err := exec.Command("diskutil", "info", mount).Output()
+		if err != nil {
+			return false, fmt.Errorf("diskutil info %s failed: %v", mount, err)
+		}
+		for _, line := range strings.Split(string(output), "\n") {
+			key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+			if ok && (key == "FileVault" || key == "Encrypted") && strings.HasPrefix(strings.TrimSpace(value), "Yes") {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "linux":
+		source, err := exec.Command("findmnt", "-n", "-o", "SOURCE", "--target", mount).Output()
+		if err != nil {
+			return false, fmt.Errorf("findmnt %s failed: %v", mount, err)
+		}
+		// Btrfs subvolumes are shown as /dev/sda2[/home]
+		device, _, _ := strings.Cut(strings.TrimSpace(string(source)), "[")
+		if !strings.HasPrefix(device, "/dev/") {
+			return false, fmt.Errorf("%s is not on a block device (%s)", mount, device)
+		}
+		// -s lists the device and everything it is built on
+		types, err := exec.Command("lsblk", "-n", "-s", "-o", "TYPE", device).Output()
+		if err != nil {
+			return false, fmt.Errorf("lsblk %s failed: %v", device, err)
+		}
+		for _, t := range strings.Fields(string(types)) {
+			if t == "crypt" {
+				return true, nil
+			}
+		}
+		return false, nil
+	default:
+		return false, fmt.Errorf("not supported on %s", runtime.GOOS)
+	}
+}
+
+// checkDestinationEncryption warns when the backup is written to an
+// unencrypted volume, and refuses it if require_encrypted_destination is set.
+func (b *Backup) checkDestinationEncryption() error {
+	if b.isSSHPath(b.config.Destination) {
+		return nil // Encryption of the remote host's disk cannot be checked
+	}
+
+	encrypted, err := volumeEncrypted(b.config.Destination)
+	if err != nil {
+		if b.config.RequireEncryptedDestination {
+			return fmt.Errorf("cannot determine whether %s is encrypted: %v", b.config.Destination, err)
+		}
+		b.log("Warning: cannot determine whether the destination is encrypted: %v", err)
+		return nil
+	}
+	if encrypted {
+		b.log("Destination volume is encrypted")
+		return nil
+	}
+	if b.config.RequireEncryptedDestination {
+		return fmt.Errorf("destination %s is not on an encrypted volume (require_encrypted_destination is set)", b.config.Destination)
+	}
+	b.log("Warning: destination %s is not on an encrypted volume; anyone with the disk can read the backup", b.config.Destination)
+	return nil
+}
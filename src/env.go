@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// resolveEnvValue returns the value of an env entry. Values starting with
+// "file:" are read from that file and values starting with "command:" are
+// the output of that shell command (e.g. a keychain or password manager
+// lookup), so secrets do not have to be stored in the configuration.
+func resolveEnvValue(value string) (string, error) {
+	if path, ok := strings.CutPrefix(value, "file:"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	if command, ok := strings.CutPrefix(value, "command:"); ok {
+		output, err := exec.Command("sh", "-c", command).Output()
+		if err != nil {
+			return "", fmt.Errorf("%q failed: %v", command, err)
+		}
+		return strings.TrimRight(string(output), "\r\n"), nil
+	}
+	return value, nil
+}
+
+// childEnv returns the environment for rsync and the hook commands: the
+// environment of this process plus the configured env entries and extra.
+// The entries are resolved once per run; their values are never logged.
+func (b *Backup) childEnv(extra ...string) []string {
+	if b.env == nil {
+		b.env = os.Environ()
+		names := make([]string, 0, len(b.config.Env))
+		for name := range b.config.Env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			value, err := resolveEnvValue(b.config.Env[name])
+			if err != nil {
+				b.log("Warning: env %s not set: %v", name, err)
+				continue
+			}
+			b.env = append(b.env, name+"="+value)
+		}
+	}
+	return append(append([]string{}, b.env...), extra...)
+}
+
+// maskedEnv returns the env entries with literal values hidden, for output
+// of the configuration.
+func maskedEnv(env map[string]string) map[string]string {
+	if env == nil {
+		return nil
+	}
+	masked := make(map[string]string, len(env))
+	for name, value := range env {
+		if !strings.HasPrefix(value, "file:") && !strings.HasPrefix(value, "command:") {
+			value = "********"
+		}
+		masked[name] = value
+	}
+	return masked
+}
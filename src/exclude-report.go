@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var totalFileSizeRe = regexp.MustCompile(`Total file size: ([0-9,]+) bytes`)
+
+// readExcludeRules returns the rules of an rsync exclude file, without blank
+// lines and comments.
+func readExcludeRules(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		rules = append(rules, line)
+	}
+	return rules, scanner.Err()
+}
+
+// measureBackup runs rsync in dry-run mode into an empty directory with the
+// given exclude rules and returns the total size of the files it would back
+// up. If sizes is given, the size of every file is added to the directory
+// it is in, truncated to depth path components.
+func (b *Backup) measureBackup(rules []string, sizes map[string]int64, depth int) (int64, error) {
+	tmp, err := os.MkdirTemp("", "exclude-report")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tmp)
+
+	rulesFile := filepath.Join(tmp, "rules")
+	if err := os.WriteFile(rulesFile, []byte(strings.Join(rules, "\n")+"\n"), 0644); err != nil {
+		return 0, err
+	}
+	dest := filepath.Join(tmp, "dest")
+	if err := os.Mkdir(dest, 0755); err != nil {
+		return 0, err
+	}
+
+	args := []string{"-a", "--dry-run", "--stats", "--out-format=%l %n", "--exclude-from=" + rulesFile}
+	for _, exclude := range b.autoExcludes {
+		args = append(args, "--exclude="+exclude)
+	}
+	args = append(args, b.sourceArgs()...)
+	args = append(args, dest+"/")
+
+	cmd := b.privileged(b.config.RsyncBin, args...)
+	cmd.Env = b.childEnv()
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("rsync dry run failed: %v", err)
+	}
+	m := totalFileSizeRe.FindSubmatch(output)
+	if m == nil {
+		return 0, fmt.Errorf("rsync printed no total file size")
+	}
+	total, err := strconv.ParseInt(strings.ReplaceAll(string(m[1]), ",", ""), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if sizes != nil {
+		for _, line := range strings.Split(string(output), "\n") {
+			size, name, ok := strings.Cut(line, " ")
+			n, err := strconv.ParseInt(size, 10, 64)
+			if !ok || err != nil || strings.HasSuffix(name, "/") {
+				continue
+			}
+			parts := strings.Split(filepath.Dir(name), "/")
+			if len(parts) > depth {
+				parts = parts[:depth]
+			}
+			sizes[strings.Join(parts, "/")] += n
+		}
+	}
+	return total, nil
+}
+
+// runExcludeReport shows how much data each rule of the exclude list keeps
+// out of the backup, by comparing dry runs with and without the rule, and
+// lists the largest directories that are backed up.
+func (b *Backup) runExcludeReport(args []string) error {
+	fs := flag.NewFlagSet("exclude-report", flag.ExitOnError)
+	top := fs.Int("top", 10, "Number of largest backed up directories to list")
+	depth := fs.Int("depth", 3, "Path depth at which directory sizes are summed")
+	parseArgs(fs, args)
+
+	if err := b.validateConfig(); err != nil {
+		return fmt.Errorf("config validation failed: %v", err)
+	}
+	if b.hasSSHSource() {
+		return fmt.Errorf("exclude-report requires local sources")
+	}
+	if err := b.expandSources(); err != nil {
+		return fmt.Errorf("source expansion failed: %v", err)
+	}
+	if err := b.findRsync(); err != nil {
+		return fmt.Errorf("failed to find rsync: %v", err)
+	}
+	if !b.isSSHPath(b.config.Destination) {
+		// Excludes a destination inside the source
+		if err := b.checkOverlap(); err != nil {
+			return err
+		}
+	}
+	b.excludeBackupStores()
+
+	var rules []string
+	if b.config.ExcludeList != "" {
+		var err error
+		if rules, err = readExcludeRules(b.config.ExcludeList); err != nil {
+			return fmt.Errorf("failed to read exclude list: %v", err)
+		}
+	}
+
+	sizes := make(map[string]int64)
+	total, err := b.measureBackup(rules, sizes, *depth)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nBackup size with all %d rules: %.2f GB\n", len(rules), gib(total))
+
+	if len(rules) > 0 {
+		fmt.Printf("\n%12s  %s\n", "FILTERED", "RULE")
+		for i, rule := range rules {
+			without := append(append([]string{}, rules[:i]...), rules[i+1:]...)
+			size, err := b.measureBackup(without, nil, 0)
+			if err != nil {
+				return err
+			}
+			note := ""
+			if size == total {
+				note = "  (no effect)"
+			}
+			fmt.Printf("%9.2f GB  %s%s\n", gib(size-total), rule, note)
+		}
+		fmt.Println("\nFILTERED is how much larger the backup would be without the rule. Rules")
+		fmt.Println("overlapping with other rules show only the data no other rule filters;")
+		fmt.Println("include rules (+) show negative values.")
+	}
+
+	dirs := make([]string, 0, len(sizes))
+	for dir := range sizes {
+		dirs = append(dirs, dir)
+	}
+	sort.Slice(dirs, func(i, j int) bool { return sizes[dirs[i]] > sizes[dirs[j]] })
+	if len(dirs) > *top {
+		dirs = dirs[:*top]
+	}
+	fmt.Printf("\nLargest backed up directories (depth %d):\n", *depth)
+	for _, dir := range dirs {
+		fmt.Printf("%9.2f GB  %s\n", gib(sizes[dir]), dir)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// flushDestination writes everything still cached for the repository to
+// the disk before the run is reported successful, so a disk unplugged right
+// after the run does not hold a "successful" snapshot that was never
+// written. sync(2) writes out all filesystems (on Linux it waits until they
+// are written); on macOS it only schedules the writes, and the fsync that
+// follows uses F_FULLFSYNC, which waits for the drive's write cache too.
+func (b *Backup) flushDestination() error {
+	if b.config.DryRun {
+		return nil
+	}
+	started := time.Now()
+	if b.isSSHPath(b.config.Destination) {
+		host, _ := splitSSHPath(b.config.Destination)
+		if _, err := b.runRemote(host, "sync"); err != nil {
+			return fmt.Errorf("sync on %s failed: %v", host, err)
+		}
+	} else {
+		syscall.Sync()
+		f, err := os.Open(b.snapDir)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("failed to flush %s: %v", b.config.Destination, err)
+		}
+	}
+	b.log("Destination flushed to disk in %s", time.Since(started).Round(time.Millisecond))
+	return nil
+}
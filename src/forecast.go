@@ -0,0 +1,198 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"syscall"
+	"time"
+)
+
+// repositoryGrowth summarizes how a repository grew over its snapshots.
+type repositoryGrowth struct {
+	Snapshots      int
+	Span           time.Duration // Between the oldest and the newest snapshot
+	UniqueSize     int64         // Every hard-linked inode counted once
+	NewestSize     int64         // Size of the newest snapshot on its own
+	NewPerSnapshot float64       // Average bytes not hard-linked to the previous snapshot
+	SourcePerDay   float64       // Growth of the snapshot size per day
+}
+
+// measureGrowth reads the catalogs of all snapshots and measures how much
+// new data each snapshot added and how fast the backed up data grows.
+func (b *Backup) measureGrowth(snapshots []string) (repositoryGrowth, error) {
+	g := repositoryGrowth{Snapshots: len(snapshots)}
+	seen := make(map[uint64]bool)
+	var previous map[uint64]bool
+	var newTotal, oldestSize int64
+
+	for i, name := range snapshots {
+		entries, err := b.loadCatalog(name)
+		if err != nil {
+			return g, fmt.Errorf("failed to read catalog of %s: %v", name, err)
+		}
+		current := make(map[uint64]bool, len(entries))
+		var size, added int64
+		for _, e := range entries {
+			if current[e.Inode] {
+				continue // Hard link within the snapshot
+			}
+			current[e.Inode] = true
+			size += e.Size
+			if !seen[e.Inode] {
+				g.UniqueSize += e.Size
+				seen[e.Inode] = true
+			}
+			if previous != nil && !previous[e.Inode] {
+				added += e.Size
+			}
+		}
+		if i == 0 {
+			oldestSize = size
+		} else {
+			newTotal += added
+		}
+		g.NewestSize = size
+		previous = current
+	}
+
+	if len(snapshots) > 1 {
+		g.NewPerSnapshot = float64(newTotal) / float64(len(snapshots)-1)
+		oldest, err1 := snapshotTime(snapshots[0])
+		newest, err2 := snapshotTime(snapshots[len(snapshots)-1])
+		if err1 == nil && err2 == nil {
+			g.Span = newest.Sub(oldest)
+		}
+		if days := g.Span.Hours() / 24; days > 0 {
+			g.SourcePerDay = float64(g.NewestSize-oldestSize) / days
+		}
+	}
+	return g, nil
+}
+
+// runForecast projects when the destination reaches cleanup_at_percent at
+// the growth rate seen in the existing snapshots, for the configured keep
+// and for half and twice as many snapshots.
+func (b *Backup) runForecast(args []string) error {
+	fs := flag.NewFlagSet("forecast", flag.ExitOnError)
+	parseArgs(fs, args)
+
+	if b.isSSHPath(b.config.Destination) {
+		return fmt.Errorf("forecast requires a local repository")
+	}
+	snapshots, err := b.listSnapshots()
+	if err != nil {
+		return err
+	}
+	if len(snapshots) < 2 {
+		return fmt.Errorf("at least two snapshots are needed to measure growth")
+	}
+	g, err := b.measureGrowth(snapshots)
+	if err != nil {
+		return err
+	}
+
+	used, avail, limit, err := b.destinationUsage()
+	if err != nil {
+		return err
+	}
+	days := g.Span.Hours() / 24
+	perDay := g.runsPerDay()
+
+	fmt.Printf("\nRepository:  %d snapshots over %.1f days, %.2f GB unique, newest snapshot %.2f GB\n",
+		g.Snapshots, days, gib(g.UniqueSize), gib(g.NewestSize))
+	fmt.Printf("Growth:      %.2f GB new data per snapshot, %.1f snapshots per day, backed up data %+.2f GB per day\n",
+		gib(int64(g.NewPerSnapshot)), perDay, gib(int64(g.SourcePerDay)))
+	fmt.Printf("Destination: %.2f GB used of %.2f GB, cleanup threshold %d%% at %.2f GB\n\n",
+		gib(used), gib(used+avail), b.config.CleanupAtPercent, gib(int64(limit)))
+
+	// Other data on the disk stays as it is
+	other := math.Max(0, float64(used-g.UniqueSize))
+	fmt.Printf("%6s  %14s  %s\n", "KEEP", "STEADY SIZE", "THRESHOLD REACHED")
+	for _, keep := range []int{b.config.Keep / 2, b.config.Keep, b.config.Keep * 2} {
+		if keep < 1 {
+			continue
+		}
+		steady := g.steadySize(keep)
+		fmt.Printf("%6d  %11.2f GB  %s\n", keep, gib(int64(steady)), forecastThreshold(other, float64(g.UniqueSize), steady, limit, g, perDay, keep))
+	}
+	fmt.Println("\nThe steady size assumes every run adds as much new data as in the past and")
+	fmt.Println("pruning keeps exactly KEEP snapshots; thinning and quotas reduce it further.")
+	return nil
+}
+
+// steadySize returns the size the repository settles at: once keep
+// snapshots exist, pruning removes as much as each run adds.
+func (g repositoryGrowth) steadySize(keep int) float64 {
+	return float64(g.NewestSize) + float64(keep-1)*g.NewPerSnapshot
+}
+
+// runsPerDay returns how many snapshots were created per day on average.
+func (g repositoryGrowth) runsPerDay() float64 {
+	if days := g.Span.Hours() / 24; days > 0 {
+		return float64(g.Snapshots-1) / days
+	}
+	return 0
+}
+
+// destinationUsage returns the used and available bytes of the disk holding
+// the repository and the usage at which cleanup_at_percent is reached.
+func (b *Backup) destinationUsage() (int64, int64, float64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(b.config.Destination, &st); err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to get disk usage of %s: %v", b.config.Destination, err)
+	}
+	used := int64(st.Blocks-st.Bfree) * int64(st.Bsize)
+	avail := int64(st.Bavail) * int64(st.Bsize)
+	// Same base as df, which the cleanup threshold is checked with
+	limit := float64(used+avail) * float64(b.config.CleanupAtPercent) / 100
+	return used, avail, limit, nil
+}
+
+// forecastThreshold describes when the repository, growing from its current
+// size towards the steady size and beyond with the backed up data, makes the
+// disk usage reach the limit.
+func forecastThreshold(other, current, steady, limit float64, g repositoryGrowth, perDay float64, keep int) string {
+	days, reason := thresholdDays(other, current, steady, limit, g, perDay, keep)
+	switch {
+	case reason != "":
+		return reason
+	case days <= 0:
+		return "already reached"
+	}
+	return forecastDays(days)
+}
+
+// thresholdDays returns in how many days the disk usage reaches the limit
+// (0 if it already has), or why this cannot be told.
+func thresholdDays(other, current, steady, limit float64, g repositoryGrowth, perDay float64, keep int) (float64, string) {
+	if other+current >= limit {
+		return 0, ""
+	}
+
+	// Days until the repository reaches keep snapshots, growing by the new
+	// data of each run
+	fillDays := 0.0
+	if keep > g.Snapshots && perDay > 0 {
+		fillDays = float64(keep-g.Snapshots) / perDay
+	}
+	if steady > current && other+steady >= limit {
+		if perDay <= 0 || g.NewPerSnapshot <= 0 {
+			return 0, "unknown (no growth measured)"
+		}
+		return (limit - other - current) / (g.NewPerSnapshot * perDay), ""
+	}
+	if g.SourcePerDay <= 0 {
+		return 0, "never at the current growth"
+	}
+	return fillDays + (limit-other-math.Max(steady, current))/g.SourcePerDay, ""
+}
+
+// forecastDays formats a number of days from now as a duration and a date.
+func forecastDays(days float64) string {
+	if days > 3650 {
+		return "in more than 10 years"
+	}
+	date := time.Now().Add(time.Duration(days * 24 * float64(time.Hour)))
+	return fmt.Sprintf("in %.0f days (%s)", days, date.Format("2006-01-02"))
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fsckProblem is a finding of the repository check together with the action
+// that fixes it.
+type fsckProblem struct {
+	description string
+	repair      func() error
+}
+
+// runFsck checks the repository for inconsistencies and optionally repairs
+// them.
+func (b *Backup) runFsck(args []string) error {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "Fix the problems found")
+	parseArgs(flags, args)
+
+	if b.isSSHPath(b.config.Destination) {
+		return fmt.Errorf("fsck requires a local repository")
+	}
+
+	// Repairs must not race with a running backup
+	if *repair {
+		if err := b.createLock(); err != nil {
+			return err
+		}
+		defer b.removeLock()
+	}
+	unlock, err := b.lockRepository(*repair, true)
+	if err != nil {
+		return fmt.Errorf("failed to lock repository: %v", err)
+	}
+	defer unlock()
+
+	snapshots, err := b.listSnapshots()
+	if err != nil {
+		return fmt.Errorf("failed to read repository: %v", err)
+	}
+	b.log("Checking repository %s (%d snapshots)", b.config.Destination, len(snapshots))
+
+	var problems []fsckProblem
+	problems = append(problems, b.checkLatestLink(snapshots)...)
+	problems = append(problems, b.checkIncomplete(*repair)...)
+	problems = append(problems, b.checkSnapshotMeta(snapshots)...)
+	problems = append(problems, b.checkForeignDirs()...)
+	problems = append(problems, b.checkSignatures(snapshots)...)
+	if len(snapshots) >= 2 {
+		problems = append(problems, b.checkHardLinks(snapshots[len(snapshots)-2], snapshots[len(snapshots)-1])...)
+	}
+
+	if len(problems) == 0 {
+		b.log("Repository is consistent")
+		return nil
+	}
+
+	unresolved := 0
+	for _, p := range problems {
+		b.log("Problem: %s", p.description)
+		if !*repair {
+			unresolved++
+			continue
+		}
+		if p.repair == nil {
+			b.log("  cannot be repaired automatically")
+			unresolved++
+		} else if err := p.repair(); err != nil {
+			b.log("  repair failed: %v", err)
+			unresolved++
+		} else {
+			b.log("  repaired")
+		}
+	}
+
+	if unresolved > 0 {
+		if !*repair {
+			return fmt.Errorf("%d problems found (run with --repair to fix them)", unresolved)
+		}
+		return fmt.Errorf("%d problems could not be repaired", unresolved)
+	}
+	b.log("All %d problems repaired", len(problems))
+	return nil
+}
+
+// checkLatestLink verifies that the latest link exists and points to the
+// newest snapshot.
+func (b *Backup) checkLatestLink(snapshots []string) []fsckProblem {
+	if len(snapshots) == 0 {
+		return nil
+	}
+	newest := snapshots[len(snapshots)-1]
+	relink := func() error {
+		return b.setLatestLink(newest)
+	}
+
+	target, err := os.Readlink(b.latestLink)
+	if err != nil {
+		return []fsckProblem{{"latest link is missing", relink}}
+	}
+	if _, err := os.Stat(filepath.Join(b.config.Destination, target)); err != nil {
+		return []fsckProblem{{fmt.Sprintf("latest link is broken (points to %s)", target), relink}}
+	}
+	if filepath.Base(target) != newest {
+		return []fsckProblem{{fmt.Sprintf("latest link points to %s instead of the newest snapshot %s", target, newest), relink}}
+	}
+	return nil
+}
+
+// checkIncomplete reports _INCOMPLETE directories left behind by aborted
+// runs. Without the lock held they may belong to a running backup.
+func (b *Backup) checkIncomplete(locked bool) []fsckProblem {
+	incomplete, err := b.listIncomplete()
+	if err != nil {
+		return nil
+	}
+
+	var problems []fsckProblem
+	for _, name := range incomplete {
+		path := filepath.Join(b.config.Destination, name)
+		desc := fmt.Sprintf("orphaned incomplete snapshot %s", name)
+		if !locked {
+			desc += " (or a backup is running)"
+		}
+		problems = append(problems, fsckProblem{desc, func() error {
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+			b.audit("delete", name, "orphaned incomplete snapshot (fsck --repair)")
+			return nil
+		}})
+	}
+	return problems
+}
+
+// checkSnapshotMeta reports snapshots without readable metadata. These are
+// usually snapshots created before metadata was recorded; the repair writes
+// what is still known (name, host, source) and leaves the rest empty.
+func (b *Backup) checkSnapshotMeta(snapshots []string) []fsckProblem {
+	var problems []fsckProblem
+	for _, name := range snapshots {
+		snapDir := filepath.Join(b.config.Destination, name)
+		if _, err := os.Stat(filepath.Join(snapDir, SnapshotMetaDir, CatalogFile)); err != nil {
+			problems = append(problems, fsckProblem{fmt.Sprintf("snapshot %s has no catalog", name), func() error {
+				_, err := saveCatalog(snapDir)
+				return err
+			}})
+		}
+		if _, err := b.readSnapshotMeta(name); err == nil {
+			continue
+		}
+		problems = append(problems, fsckProblem{fmt.Sprintf("snapshot %s has no metadata", name), func() error {
+			hostname, _ := os.Hostname()
+			return b.saveSnapshotMeta(filepath.Join(b.config.Destination, name), SnapshotMeta{
+				Name:     name,
+				Source:   b.config.Source,
+				Sources:  b.config.Sources,
+				Hostname: hostname,
+			})
+		}})
+	}
+	return problems
+}
+
+// checkForeignDirs reports directories in the repository that are neither
+// snapshots nor incomplete or partial snapshots. The repair moves them to
+// .foreign/.
+func (b *Backup) checkForeignDirs() []fsckProblem {
+	entries, err := os.ReadDir(b.config.Destination)
+	if err != nil {
+		return nil
+	}
+
+	var problems []fsckProblem
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || isSnapshotName(name) || strings.HasSuffix(name, "_INCOMPLETE") ||
+			strings.HasSuffix(name, PartialSuffix) || strings.HasPrefix(name, ".") || name == "lost+found" {
+			continue
+		}
+		problems = append(problems, fsckProblem{fmt.Sprintf("foreign directory %s in repository", name), func() error {
+			foreignDir := filepath.Join(b.config.Destination, ".foreign")
+			if err := os.MkdirAll(foreignDir, 0755); err != nil {
+				return err
+			}
+			return os.Rename(filepath.Join(b.config.Destination, name), filepath.Join(foreignDir, name))
+		}})
+	}
+	return problems
+}
+
+// checkSignatures reports snapshots whose signature does not match their
+// catalog and content hashes, and broken signatures in history_file. It
+// does not read the snapshot files; verify compares them with the hashes.
+func (b *Backup) checkSignatures(snapshots []string) []fsckProblem {
+	if b.publicKeyPath() == "" {
+		return nil
+	}
+	key, err := b.loadPublicKey()
+	if err != nil {
+		return []fsckProblem{{fmt.Sprintf("cannot check signatures: %v", err), nil}}
+	}
+
+	var problems []fsckProblem
+	signed := b.mustBeSigned(key, snapshots)
+	for _, name := range snapshots {
+		if !signed[name] {
+			continue
+		}
+		if err := b.checkSnapshotSignature(key, name); err != nil {
+			problems = append(problems, fsckProblem{fmt.Sprintf("snapshot %s: %v", name, err), nil})
+		}
+	}
+	if b.config.HistoryFile != "" {
+		history, _, err := b.checkHistorySignatures(key)
+		if err != nil {
+			history = []string{fmt.Sprintf("cannot read history: %v", err)}
+		}
+		for _, problem := range history {
+			problems = append(problems, fsckProblem{problem, nil})
+		}
+	}
+	return problems
+}
+
+// checkHardLinks finds files that are unchanged between two consecutive
+// snapshots but are stored twice instead of being hard-linked. The repair
+// replaces the newer copy by a hard link once the contents are confirmed equal.
+func (b *Backup) checkHardLinks(older, newer string) []fsckProblem {
+	audit := b.auditLinks(older, newer)
+	if len(audit.duplicates) == 0 {
+		return nil
+	}
+
+	olderDir := filepath.Join(b.config.Destination, older)
+	newerDir := filepath.Join(b.config.Destination, newer)
+	desc := fmt.Sprintf("%d unchanged files in %s are not hard-linked to %s (%.2f GB duplicated)",
+		len(audit.duplicates), newer, older, float64(audit.wastedBytes)/(1024*1024*1024))
+	return []fsckProblem{{desc, func() error {
+		// The catalog records inodes, so the signature must be renewed
+		return b.resignAfter(newer, func() error {
+			for _, rel := range audit.duplicates {
+				if err := relinkFile(filepath.Join(olderDir, rel), filepath.Join(newerDir, rel)); err != nil {
+					return err
+				}
+			}
+			_, err := saveCatalog(newerDir)
+			return err
+		})
+	}}}
+}
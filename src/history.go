@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// appendHistory adds one line describing this run to history_file, e.g.
+//
+//	time=2026-01-02T03:04:05+01:00 status=ok snapshot=CET_2026-01-02_03.04.05 transferred_gb=1.25 transferred_files=310 duration=4m12s
+//
+// Unlike the log file, the history is never cleaned up.
+func (b *Backup) appendHistory(runErr error) {
+	if b.config.HistoryFile == "" {
+		return
+	}
+
+	fields := []string{
+		"time=" + b.started.Format(time.RFC3339),
+		"status=" + b.outcome(runErr),
+		"snapshot=" + b.runSnapshot(),
+		fmt.Sprintf("transferred_gb=%.2f", b.transferredGB),
+		fmt.Sprintf("transferred_files=%d", b.sentFiles),
+		"duration=" + time.Since(b.started).Round(time.Second).String(),
+	}
+	if b.config.Name != "" {
+		fields = append(fields, "job="+b.config.Name)
+	}
+	if runErr != nil {
+		fields = append(fields, "error="+strconv.Quote(runErr.Error()))
+	}
+	if b.config.SigningKey != "" {
+		signature, err := b.signHistoryLine(strings.Join(fields, " "))
+		if err != nil {
+			b.log("Warning: failed to sign history: %v", err)
+		} else {
+			fields = append(fields, signature)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(b.config.HistoryFile), 0755); err != nil {
+		b.log("Warning: failed to create history directory: %v", err)
+		return
+	}
+	f, err := os.OpenFile(b.config.HistoryFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		b.log("Warning: failed to open history file: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.WriteString(strings.Join(fields, " ") + "\n"); err != nil {
+		b.log("Warning: failed to write history: %v", err)
+	}
+}
+
+// runSnapshot returns the name of the snapshot a run created. An unchanged
+// run confirms the previous snapshot instead.
+func (b *Backup) runSnapshot() string {
+	if b.unchanged != "" {
+		return b.unchanged
+	}
+	return b.timestamp
+}
+
+// outcome returns the status of a run as recorded in the history.
+func (b *Backup) outcome(runErr error) string {
+	switch {
+	case runErr != nil:
+		return "failed"
+	case b.skipped:
+		return "skipped"
+	case b.unchanged != "":
+		return "unchanged"
+	case b.quotaReached || b.timedOut:
+		return "partial"
+	case b.config.DryRun:
+		return "dry-run"
+	}
+	return "ok"
+}
+
+// readHistory returns the last limit runs of history_file, newest first,
+// as key/value pairs with quoted values unquoted.
+func (b *Backup) readHistory(limit int) ([]map[string]string, error) {
+	if b.config.HistoryFile == "" {
+		return nil, fmt.Errorf("history_file is not set")
+	}
+	data, err := os.ReadFile(b.config.HistoryFile)
+	if os.IsNotExist(err) {
+		return []map[string]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	runs := []map[string]string{}
+	for i := len(lines) - 1; i >= 0 && len(runs) < limit; i-- {
+		if lines[i] != "" {
+			runs = append(runs, parseHistoryLine(lines[i]))
+		}
+	}
+	return runs, nil
+}
+
+// parseHistoryLine splits a history line into its key=value fields.
+func parseHistoryLine(line string) map[string]string {
+	fields := make(map[string]string)
+	for line != "" {
+		key, rest, ok := strings.Cut(line, "=")
+		if !ok {
+			break
+		}
+		value := rest
+		if strings.HasPrefix(rest, `"`) {
+			if quoted, err := strconv.QuotedPrefix(rest); err == nil {
+				value, _ = strconv.Unquote(quoted)
+				rest = rest[len(quoted):]
+			}
+		} else {
+			value, rest, _ = strings.Cut(rest, " ")
+		}
+		fields[key] = value
+		line = strings.TrimLeft(rest, " ")
+	}
+	return fields
+}
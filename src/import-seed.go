@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// runImportSeed adds the first snapshot of a repository from a portable
+// disk instead of sending it over the network: an archive created by
+// export, or a repository or snapshot directory on the disk. It runs on the
+// machine holding the repository. The seed becomes this job's latest
+// snapshot, so the next run (e.g. over SSH from the client) hard-links
+// against it and only transfers what changed since.
+func (b *Backup) runImportSeed(args []string) error {
+	fs := flag.NewFlagSet("import-seed", flag.ExitOnError)
+	positional := parseArgs(fs, args)
+
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: import-seed <archive|repository|snapshot-directory>")
+	}
+	if b.isSSHPath(b.config.Destination) {
+		return fmt.Errorf("import-seed runs on the machine holding the repository (with a local destination)")
+	}
+	if snapshots, err := b.listSnapshots(); err == nil && len(snapshots) > 0 {
+		return fmt.Errorf("%s already has %d snapshots of this job, a seed is for a new repository (use import)", b.config.Destination, len(snapshots))
+	}
+	if err := os.MkdirAll(b.config.Destination, 0755); err != nil {
+		return fmt.Errorf("failed to create repository: %v", err)
+	}
+
+	var name string
+	var err error
+	if info, statErr := os.Stat(positional[0]); statErr == nil && info.IsDir() {
+		name, err = b.importSeedDir(positional[0])
+	} else {
+		name, err = b.importArchive(positional[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	// Take the seed over as a snapshot of this job, or retention and
+	// link-dest would ignore it
+	if !b.ownSnapshot(name) {
+		ownName := b.timestamp
+		if _, timestamp, ok := splitSnapshotName(name); ok {
+			ownName = timestamp
+			if b.config.SnapshotPrefix != "" {
+				ownName = b.config.SnapshotPrefix + "_" + timestamp
+			}
+		}
+		if err := os.Rename(filepath.Join(b.config.Destination, name), filepath.Join(b.config.Destination, ownName)); err != nil {
+			return fmt.Errorf("failed to rename seed %s: %v", name, err)
+		}
+		b.log("Seed %s renamed to %s", name, ownName)
+		name = ownName
+	}
+
+	if err := b.setLatestLink(name); err != nil {
+		return fmt.Errorf("failed to update latest link: %v", err)
+	}
+	b.log("Seed imported as %s; the next backup hard-links against it", name)
+	return nil
+}
+
+// importSeedDir copies the newest snapshot of a repository on a portable
+// disk (or a single snapshot directory) into the repository and returns
+// its name.
+func (b *Backup) importSeedDir(dir string) (string, error) {
+	snapshot, err := findSeedSnapshot(dir)
+	if err != nil {
+		return "", err
+	}
+	if err := b.findRsync(); err != nil {
+		return "", fmt.Errorf("failed to find rsync: %v", err)
+	}
+
+	// Copy into a staging directory first so an interrupted import is
+	// never mistaken for a finished snapshot
+	name := filepath.Base(snapshot)
+	staging := filepath.Join(b.config.Destination, "import_"+b.timestamp+"_INCOMPLETE")
+	b.log("Importing seed %s", snapshot)
+	if err := b.runMigrateRsync(append(b.migrateRsyncArgs(), snapshot+"/", staging)); err != nil {
+		os.RemoveAll(staging)
+		return "", err
+	}
+	if !isSnapshotName(name) {
+		name = b.timestamp
+	}
+	if err := os.Rename(staging, filepath.Join(b.config.Destination, name)); err != nil {
+		os.RemoveAll(staging)
+		return "", fmt.Errorf("failed to move imported seed: %v", err)
+	}
+	return name, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// bundledRsyncPath returns where install-rsync places its rsync binary:
+// next to the backup executable, so it is found without any configuration.
+func bundledRsyncPath() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(exe), AppDir, "rsync")
+}
+
+// runInstallRsync provisions a known-good rsync binary (e.g. a static build
+// for the current platform) from a URL or local file. The binary must pass
+// the same checks as any other rsync: it has to run, must not be openrsync
+// and must be at least version 3.2.0.
+func (b *Backup) runInstallRsync(args []string) error {
+	fs := flag.NewFlagSet("install-rsync", flag.ExitOnError)
+	from := fs.String("from", "", "URL or local path of the rsync binary")
+	sha256 := fs.String("sha256", "", "Expected SHA-256 of the binary (required for URLs)")
+	parseArgs(fs, args)
+
+	if *from == "" {
+		return fmt.Errorf("usage: install-rsync --from <url|file> [--sha256 <hash>]")
+	}
+	// The binary is run as root by every backup, so a download must be
+	// protected against tampering on the way
+	if strings.HasPrefix(strings.ToLower(*from), "http://") {
+		return fmt.Errorf("refusing to download rsync over plain http, use https")
+	}
+	if strings.HasPrefix(strings.ToLower(*from), "https://") && *sha256 == "" {
+		return fmt.Errorf("--sha256 is required when installing rsync from a URL")
+	}
+	target := bundledRsyncPath()
+	if target == "" {
+		return fmt.Errorf("cannot determine the location of the backup executable")
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", filepath.Dir(target), err)
+	}
+
+	// Stage next to the target so the final rename is atomic
+	staging := target + ".new"
+	if err := fetchFile(*from, staging); err != nil {
+		os.Remove(staging)
+		return err
+	}
+	defer os.Remove(staging)
+
+	if *sha256 != "" {
+		sum, err := hashFile(staging)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(sum, *sha256) {
+			return fmt.Errorf("checksum mismatch: got %s, expected %s", sum, *sha256)
+		}
+	}
+	if err := os.Chmod(staging, 0755); err != nil {
+		return err
+	}
+
+	output, err := exec.Command(staging, "--version").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("downloaded rsync does not run on this system: %v", err)
+	}
+	if strings.Contains(string(output), "openrsync") {
+		return fmt.Errorf("downloaded binary is openrsync, not rsync")
+	}
+	version := regexp.MustCompile(`\d+\.\d+\.\d+`).FindString(string(output))
+	if b.isOldRsync(version) {
+		return fmt.Errorf("downloaded rsync is version %q, at least 3.2.0 is required", version)
+	}
+
+	if err := os.Rename(staging, target); err != nil {
+		return fmt.Errorf("failed to install rsync: %v", err)
+	}
+	b.log("Installed rsync %s to %s", version, target)
+	return nil
+}
+
+// fetchFile copies an https URL or a local file to dst. Redirects to
+// anything but https are refused.
+func fetchFile(src, dst string) error {
+	var in io.ReadCloser
+	if strings.HasPrefix(strings.ToLower(src), "https://") {
+		client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Scheme != "https" {
+				return fmt.Errorf("refusing redirect to %s", req.URL)
+			}
+			if len(via) >= 10 {
+				return fmt.Errorf("too many redirects")
+			}
+			return nil
+		}}
+		resp, err := client.Get(src)
+		if err != nil {
+			return fmt.Errorf("download failed: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return fmt.Errorf("download failed: %s", resp.Status)
+		}
+		in = resp.Body
+	} else {
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		in = f
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to write %s: %v", dst, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,130 @@
+// Package rsyncpath parses the location syntax rsync accepts for sources
+// and destinations:
+//
+//	/local/path, relative/path, ./file:with:colons
+//	[user@]host:path                 remote shell (SSH)
+//	[user@][2001:db8::1]:path        remote shell, IPv6 address
+//	[user@]host::module/path         rsync daemon
+//	rsync://[user@]host[:port]/module/path
+//
+// As in rsync, a path is local if it has no colon or a slash before the
+// first colon, so local file names may contain '@' and ':'.
+package rsyncpath
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Kind tells how a location is reached.
+type Kind int
+
+const (
+	Local  Kind = iota
+	SSH         // Through a remote shell: [user@]host:path
+	Daemon      // Through an rsync daemon: host::module or rsync://host/module
+)
+
+// Location is a parsed rsync source or destination.
+type Location struct {
+	Kind   Kind
+	User   string // Empty if not given
+	Host   string // Without the brackets around an IPv6 address
+	Port   int    // rsync:// URLs only, 0 if not given
+	Module string // Daemon locations only
+	Path   string // Local path, path on the host or path inside the module
+}
+
+// Parse parses a location in rsync syntax. Anything that is not a remote
+// location is a local path.
+func Parse(s string) Location {
+	if rest, ok := strings.CutPrefix(s, "rsync://"); ok {
+		return parseURL(s, rest)
+	}
+
+	var userHost, path string
+	if strings.HasPrefix(s, "[") || strings.Contains(s, "@[") {
+		// Bracketed IPv6 address, the colons inside do not count
+		end := strings.Index(s, "]:")
+		if end < 0 || strings.Contains(s[:end], "/") {
+			return Location{Kind: Local, Path: s}
+		}
+		userHost, path = s[:end+1], s[end+2:]
+	} else {
+		colon := strings.IndexByte(s, ':')
+		if colon <= 0 || strings.Contains(s[:colon], "/") {
+			return Location{Kind: Local, Path: s}
+		}
+		userHost, path = s[:colon], s[colon+1:]
+	}
+
+	user, host := splitUser(userHost)
+	host, ok := unbracket(host)
+	if !ok || host == "" {
+		return Location{Kind: Local, Path: s}
+	}
+	if rest, ok := strings.CutPrefix(path, ":"); ok {
+		module, modulePath, _ := strings.Cut(rest, "/")
+		return Location{Kind: Daemon, User: user, Host: host, Module: module, Path: modulePath}
+	}
+	return Location{Kind: SSH, User: user, Host: host, Path: path}
+}
+
+// parseURL parses the part of an rsync:// URL after the scheme.
+func parseURL(s, rest string) Location {
+	hostPort, modulePath, _ := strings.Cut(rest, "/")
+	module, path, _ := strings.Cut(modulePath, "/")
+	user, hostPort := splitUser(hostPort)
+
+	host, port := hostPort, 0
+	if i := strings.LastIndexByte(hostPort, ':'); i >= 0 && !strings.HasSuffix(hostPort, "]") {
+		n, err := strconv.Atoi(hostPort[i+1:])
+		if err != nil || n <= 0 || n > 65535 {
+			return Location{Kind: Local, Path: s}
+		}
+		host, port = hostPort[:i], n
+	}
+	host, ok := unbracket(host)
+	if !ok || host == "" {
+		return Location{Kind: Local, Path: s}
+	}
+	return Location{Kind: Daemon, User: user, Host: host, Port: port, Module: module, Path: path}
+}
+
+// splitUser splits "user@host" at the last '@'.
+func splitUser(userHost string) (string, string) {
+	if i := strings.LastIndexByte(userHost, '@'); i >= 0 {
+		return userHost[:i], userHost[i+1:]
+	}
+	return "", userHost
+}
+
+// unbracket removes the brackets around an IPv6 address. A host name
+// must not contain brackets or colons otherwise.
+func unbracket(host string) (string, bool) {
+	if inner, ok := strings.CutPrefix(host, "["); ok {
+		inner, ok = strings.CutSuffix(inner, "]")
+		return inner, ok && strings.Contains(inner, ":")
+	}
+	return host, !strings.ContainsAny(host, "[]:")
+}
+
+// Remote reports whether the location is on another host.
+func (l Location) Remote() bool {
+	return l.Kind != Local
+}
+
+// IsAbs reports whether the path is absolute. Relative remote paths start
+// at the login directory (SSH) or the module root (daemon).
+func (l Location) IsAbs() bool {
+	return strings.HasPrefix(l.Path, "/")
+}
+
+// Target returns the argument for ssh: user@host, or host without a user.
+// IPv6 addresses are not bracketed.
+func (l Location) Target() string {
+	if l.User == "" {
+		return l.Host
+	}
+	return l.User + "@" + l.Host
+}
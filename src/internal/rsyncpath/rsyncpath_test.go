@@ -0,0 +1,79 @@
+package rsyncpath
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Location
+	}{
+		// Local paths
+		{"/mnt/backup", Location{Kind: Local, Path: "/mnt/backup"}},
+		{"backup", Location{Kind: Local, Path: "backup"}},
+		{"", Location{Kind: Local, Path: ""}},
+		{"/mnt/disk:1/backup", Location{Kind: Local, Path: "/mnt/disk:1/backup"}},
+		{"./file:with:colons", Location{Kind: Local, Path: "./file:with:colons"}},
+		{"dir/user@host:path", Location{Kind: Local, Path: "dir/user@host:path"}},
+		{":path", Location{Kind: Local, Path: ":path"}},
+		{"/[2001:db8::1]:path", Location{Kind: Local, Path: "/[2001:db8::1]:path"}},
+
+		// Remote shell
+		{"nas:/backups", Location{Kind: SSH, Host: "nas", Path: "/backups"}},
+		{"nas:backups", Location{Kind: SSH, Host: "nas", Path: "backups"}},
+		{"nas:", Location{Kind: SSH, Host: "nas", Path: ""}},
+		{"alice@nas:/backups", Location{Kind: SSH, User: "alice", Host: "nas", Path: "/backups"}},
+		{"alice@example.com@nas:/b", Location{Kind: SSH, User: "alice@example.com", Host: "nas", Path: "/b"}},
+		{"nas:/path:with:colons", Location{Kind: SSH, Host: "nas", Path: "/path:with:colons"}},
+
+		// Remote shell, IPv6 address
+		{"[2001:db8::1]:/backups", Location{Kind: SSH, Host: "2001:db8::1", Path: "/backups"}},
+		{"alice@[fe80::1%eth0]:b", Location{Kind: SSH, User: "alice", Host: "fe80::1%eth0", Path: "b"}},
+		{"[2001:db8::1]", Location{Kind: Local, Path: "[2001:db8::1]"}},
+		{"[nas]:/backups", Location{Kind: Local, Path: "[nas]:/backups"}},
+
+		// rsync daemon
+		{"nas::backups", Location{Kind: Daemon, Host: "nas", Module: "backups"}},
+		{"alice@nas::backups/host/a", Location{Kind: Daemon, User: "alice", Host: "nas", Module: "backups", Path: "host/a"}},
+		{"[2001:db8::1]::backups", Location{Kind: Daemon, Host: "2001:db8::1", Module: "backups"}},
+		{"rsync://nas/backups", Location{Kind: Daemon, Host: "nas", Module: "backups"}},
+		{"rsync://alice@nas:8730/backups/host", Location{Kind: Daemon, User: "alice", Host: "nas", Port: 8730, Module: "backups", Path: "host"}},
+		{"rsync://[2001:db8::1]:873/backups", Location{Kind: Daemon, Host: "2001:db8::1", Port: 873, Module: "backups"}},
+		{"rsync://[2001:db8::1]/backups", Location{Kind: Daemon, Host: "2001:db8::1", Module: "backups"}},
+		{"rsync://nas:port/backups", Location{Kind: Local, Path: "rsync://nas:port/backups"}},
+		{"rsync:///backups", Location{Kind: Local, Path: "rsync:///backups"}},
+
+		// Like rsync, a drive letter is taken as a host name; Windows paths
+		// must be given as /cygdrive/c/... or ./C:...
+		{`C:\Users\alice`, Location{Kind: SSH, Host: "C", Path: `\Users\alice`}},
+		{"C:/Users/alice", Location{Kind: SSH, Host: "C", Path: "/Users/alice"}},
+		{"/cygdrive/c/Users/alice", Location{Kind: Local, Path: "/cygdrive/c/Users/alice"}},
+	}
+	for _, tt := range tests {
+		if got := Parse(tt.in); got != tt.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLocationMethods(t *testing.T) {
+	tests := []struct {
+		in     string
+		remote bool
+		abs    bool
+		target string
+	}{
+		{"/mnt/backup", false, true, ""},
+		{"backup", false, false, ""},
+		{"alice@nas:/backups", true, true, "alice@nas"},
+		{"nas:backups", true, false, "nas"},
+		{"[2001:db8::1]:/b", true, true, "2001:db8::1"},
+		{"nas::backups/host", true, false, "nas"},
+	}
+	for _, tt := range tests {
+		l := Parse(tt.in)
+		if l.Remote() != tt.remote || l.IsAbs() != tt.abs || l.Target() != tt.target {
+			t.Errorf("Parse(%q): Remote() = %v, IsAbs() = %v, Target() = %q; want %v, %v, %q",
+				tt.in, l.Remote(), l.IsAbs(), l.Target(), tt.remote, tt.abs, tt.target)
+		}
+	}
+}
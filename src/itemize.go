@@ -0,0 +1,48 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// itemizeRe matches a line of rsync --itemize-changes output, e.g.
+// ">f.st...... home/user/notes.txt" or "*deleting   home/user/old.txt".
+var itemizeRe = regexp.MustCompile(`^([<>ch.][fdLDS][.+?a-z]{9}|\*deleting  ) (.+)$`)
+
+// itemizedChange is one path reported by rsync --itemize-changes. Path is
+// relative to the snapshot root.
+type itemizedChange struct {
+	Flags string
+	Path  string
+}
+
+// transferred reports whether the file content was sent by rsync.
+func (c itemizedChange) transferred() bool {
+	return c.Flags[0] == '>' && c.Flags[1] == 'f'
+}
+
+// deleted reports whether the path no longer exists in the source.
+func (c itemizedChange) deleted() bool {
+	return c.Flags == "*deleting  "
+}
+
+// parseItemized extracts the itemized changes from rsync output and ignores
+// all other lines (progress, statistics, warnings).
+func parseItemized(output string) []itemizedChange {
+	var changes []itemizedChange
+	for _, line := range strings.Split(output, "\n") {
+		m := itemizeRe.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if m == nil {
+			continue
+		}
+		path := m[2]
+		if m[1][1] == 'L' {
+			// Symlinks are reported as "link -> target"
+			if i := strings.Index(path, " -> "); i >= 0 {
+				path = path[:i]
+			}
+		}
+		changes = append(changes, itemizedChange{Flags: m[1], Path: strings.TrimSuffix(path, "/")})
+	}
+	return changes
+}
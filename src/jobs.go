@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// loadJob returns the configuration of the i-th entry of jobs: the
+// top-level settings of the file with those of the job on top, e.g.
+//
+//	{"destination": "nas:/backups/{job}", "keep": 30,
+//	 "jobs": [{"name": "home", "source": "/home"},
+//	          {"name": "media", "source": "/srv/media", "keep": 7}]}
+func loadJob(data, raw []byte, filename string, i int) (Config, error) {
+	label := fmt.Sprintf("%s (job %d)", filename, i+1)
+
+	// Check the job on its own so errors point to it
+	jobFile, err := decodeConfigFile(raw, label)
+	if err != nil {
+		return Config{}, err
+	}
+	if jobFile.Name == "" {
+		return Config{}, fmt.Errorf("%s: missing name", label)
+	}
+	if len(jobFile.Jobs) > 0 {
+		return Config{}, fmt.Errorf("%s: jobs cannot contain jobs", label)
+	}
+
+	var merged, part map[string]json.RawMessage
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return Config{}, fmt.Errorf("%s: %v", filename, err)
+	}
+	if err := json.Unmarshal(raw, &part); err != nil {
+		return Config{}, fmt.Errorf("%s: %v", label, err)
+	}
+	delete(merged, "jobs")
+	for key, value := range part {
+		merged[key] = value
+	}
+	data, err = json.Marshal(merged)
+	if err != nil {
+		return Config{}, err
+	}
+	configFile, err := decodeConfigFile(data, label)
+	if err != nil {
+		return Config{}, err
+	}
+
+	job := DefaultConfig
+	applyConfigFile(&job, configFile)
+	if err := finishConfig(&job, filename); err != nil {
+		return job, fmt.Errorf("job %s: %v", job.Name, err)
+	}
+	return job, nil
+}
+
+// checkJobs refuses jobs with the same name, jobs that would write to the
+// same snapshot chain, whose retention would delete each other's
+// snapshots, and jobs sharing a change_cache or history_file.
+func checkJobs(jobs []Config) error {
+	names := make(map[string]bool)
+	chains := make(map[string]string)
+	statePaths := make(map[string]string)
+	for _, job := range jobs {
+		if names[job.Name] {
+			return fmt.Errorf("job name %s is used twice", job.Name)
+		}
+		names[job.Name] = true
+
+		chain := job.Destination + "\x00" + job.SnapshotPrefix
+		if other, ok := chains[chain]; ok {
+			return fmt.Errorf("jobs %s and %s both write to %s; give them different destinations or snapshot_prefix values", other, job.Name, job.Destination)
+		}
+		chains[chain] = job.Name
+
+		// State of one job that another job would overwrite or read as its own
+		for _, state := range []struct{ option, path string }{
+			{"change_cache", job.ChangeCache},
+			{"history_file", job.HistoryFile},
+		} {
+			if state.path == "" {
+				continue
+			}
+			if other, ok := statePaths[state.path]; ok {
+				return fmt.Errorf("jobs %s and %s both use %s %s; include {job} in the path", other, job.Name, state.option, state.path)
+			}
+			statePaths[state.path] = job.Name
+		}
+	}
+	return nil
+}
+
+// selectJobs returns the jobs to run: all jobs of the configuration
+// except those in skip, or the named ones in the given order. A
+// configuration without jobs is run itself.
+func selectJobs(config Config, names, skip []string) ([]Config, error) {
+	if len(config.Jobs) == 0 {
+		if len(names) > 0 || len(skip) > 0 {
+			return nil, fmt.Errorf("job %s given, but the configuration has no jobs", strings.Join(slices.Concat(names, skip), ", "))
+		}
+		return []Config{config}, nil
+	}
+
+	byName := make(map[string]Config)
+	var all []string
+	for _, job := range config.Jobs {
+		byName[job.Name] = job
+		all = append(all, job.Name)
+	}
+	for _, name := range slices.Concat(names, skip) {
+		if _, ok := byName[name]; !ok {
+			return nil, fmt.Errorf("no job %s (jobs: %s)", name, strings.Join(all, ", "))
+		}
+	}
+	if len(names) == 0 {
+		names = all
+	}
+	var jobs []Config
+	for _, name := range names {
+		if !slices.Contains(skip, name) && !slices.ContainsFunc(jobs, func(job Config) bool { return job.Name == name }) {
+			jobs = append(jobs, byName[name])
+		}
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("all selected jobs are skipped")
+	}
+	return jobs, nil
+}
+
+// jobList is a command flag that can be given several times or with
+// comma-separated names, e.g. --skip media --skip home or --skip media,home.
+type jobList []string
+
+func (l *jobList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *jobList) Set(value string) error {
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			*l = append(*l, name)
+		}
+	}
+	return nil
+}
+
+// parseRunArgs parses the options of the run command: the names of the
+// jobs to run or --all, and the jobs to --skip.
+func parseRunArgs(args []string) (names, skip []string, dryRun bool, err error) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	all := fs.Bool("all", false, "Run all jobs")
+	fs.Var((*jobList)(&skip), "skip", "Do not run this job (can be given several times)")
+	dry := fs.Bool("dry-run", false, "Perform a dry run (no changes)")
+	names = parseArgs(fs, args)
+	if len(names) > 0 == *all {
+		return nil, nil, false, fmt.Errorf("usage: run <job>... | --all [--skip <job>] [--dry-run]")
+	}
+	return names, skip, *dry, nil
+}
+
+// runJobs runs several jobs one after another. A failed job does not stop
+// the following ones unless failFast is set, and no job is started after
+// the -deadline; the summary lists the result of every job.
+func runJobs(jobs []Config, failFast bool) error {
+	type result struct {
+		status, snapshot, duration, gb string
+		err                            error
+	}
+	results := make([]result, len(jobs))
+	var failed, late []string
+	for i, job := range jobs {
+		if failFast && len(failed) > 0 {
+			results[i] = result{status: "not run", snapshot: "-", duration: "-", gb: "-", err: fmt.Errorf("skipped after job %s failed (-fail-fast)", failed[0])}
+			continue
+		}
+		if deadlinePassed(job) {
+			results[i] = result{status: "not run", snapshot: "-", duration: "-", gb: "-", err: fmt.Errorf("not run due to deadline %s", job.Deadline.Format("2006-01-02 15:04"))}
+			late = append(late, job.Name)
+			continue
+		}
+		fmt.Printf("\n== Job %s (%d/%d) ==\n", job.Name, i+1, len(jobs))
+		backup := NewBackup(job)
+		err := backup.Run()
+		results[i] = result{
+			status:   backup.outcome(err),
+			snapshot: backup.runSnapshot(),
+			duration: time.Since(backup.started).Round(time.Second).String(),
+			gb:       fmt.Sprintf("%.2f", backup.transferredGB),
+			err:      err,
+		}
+		if err != nil || backup.config.DryRun || backup.skipped {
+			results[i].snapshot = "-"
+		}
+		if err != nil {
+			failed = append(failed, job.Name)
+		}
+	}
+
+	var summary strings.Builder
+	fmt.Fprintf(&summary, "%-20s %-10s %10s %9s  %s\n", "JOB", "STATUS", "DURATION", "GB", "SNAPSHOT")
+	for i, job := range jobs {
+		r := results[i]
+		fmt.Fprintf(&summary, "%-20s %-10s %10s %9s  %s\n", job.Name, r.status, r.duration, r.gb, r.snapshot)
+		if r.err != nil {
+			fmt.Fprintf(&summary, "%-20s %v\n", "", r.err)
+		}
+	}
+	fmt.Print("\n== Summary ==\n" + summary.String())
+
+	// The combined log ends with the summary, so it shows every job at a glance
+	logged := make(map[string]bool)
+	for _, job := range jobs {
+		if job.CombinedLogFile == "" || job.DryRun || logged[job.CombinedLogFile] {
+			continue
+		}
+		logged[job.CombinedLogFile] = true
+		if f, err := os.OpenFile(job.CombinedLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err == nil {
+			fmt.Fprintf(f, "%s Summary of %d jobs:\n%s", time.Now().Format("2006-01-02 15:04:05"), len(jobs), summary.String())
+			f.Close()
+		}
+	}
+	var problems []string
+	if len(failed) > 0 {
+		problems = append(problems, fmt.Sprintf("%d of %d jobs failed: %s", len(failed), len(jobs), strings.Join(failed, ", ")))
+	}
+	if len(late) > 0 {
+		problems = append(problems, fmt.Sprintf("%d jobs not run due to deadline: %s", len(late), strings.Join(late, ", ")))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// setLatestLink points the latest link at the given snapshot. The new link is
+// created under a temporary name and renamed over the old one, so a crash
+// never leaves the repository without a latest link.
+func (b *Backup) setLatestLink(name string) error {
+	if b.isSSHPath(b.config.Destination) {
+		host, linkPath := splitSSHPath(b.latestLink)
+		tmp := shellQuote(linkPath + ".tmp")
+		// mv -T (GNU) and mv -h (BSD) replace the link instead of moving
+		// into the directory it points to
+		cmd := fmt.Sprintf("ln -sfn %s %s && { mv -Tf %s %s 2>/dev/null || mv -hf %s %s; }",
+			shellQuote(name), tmp, tmp, shellQuote(linkPath), tmp, shellQuote(linkPath))
+		_, err := b.runRemote(host, cmd)
+		return err
+	}
+
+	if caps := b.destinationCaps(); caps != nil && !caps.symlinks {
+		return nil // The newest snapshot is found by scanning instead
+	}
+	tmp := b.latestLink + ".tmp"
+	os.Remove(tmp) // Left over from an interrupted update
+	if err := os.Symlink(name, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, b.latestLink); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// newestSnapshot returns the name of the newest snapshot in the repository
+// by scanning its directories, or "" if there is none. Works for local and
+// remote destinations.
+func (b *Backup) newestSnapshot() string {
+	snapshots, err := b.scanSnapshots()
+	if err != nil || len(snapshots) == 0 {
+		return ""
+	}
+	return snapshots[len(snapshots)-1]
+}
+
+// scanSnapshots is listSnapshots for local and remote destinations.
+func (b *Backup) scanSnapshots() ([]string, error) {
+	if !b.isSSHPath(b.config.Destination) {
+		return b.listSnapshots()
+	}
+
+	host, path := splitSSHPath(b.config.Destination)
+	output, err := b.runRemote(host, "find "+shellQuote(path)+" -mindepth 1 -maxdepth 1 -type d")
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []string
+	for _, line := range strings.Split(output, "\n") {
+		if name := filepath.Base(strings.TrimSpace(line)); b.ownSnapshot(name) {
+			snapshots = append(snapshots, name)
+		}
+	}
+	sortSnapshots(snapshots)
+	return snapshots, nil
+}
+
+// repairLatestLink recreates a missing latest link from the newest snapshot,
+// so the next run still finds its link-dest.
+func (b *Backup) repairLatestLink() {
+	if b.readLatestLink() != "" {
+		return
+	}
+	newest := b.newestSnapshot()
+	if newest == "" {
+		return
+	}
+	if b.config.DryRun {
+		b.log("Warning: latest link is missing, newest snapshot is %s (not repaired in dry run)", newest)
+		return
+	}
+	if err := b.setLatestLink(newest); err != nil {
+		b.log("Warning: latest link is missing and could not be recreated: %v", err)
+		return
+	}
+	b.log("Latest link was missing, recreated it for %s", newest)
+}
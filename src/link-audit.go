@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// linkAudit is the result of comparing two consecutive snapshots.
+type linkAudit struct {
+	files       int      // Regular files present in both snapshots
+	linked      int      // Files sharing the inode with the older snapshot
+	duplicates  []string // Unchanged files stored twice
+	wastedBytes int64    // Size of the duplicated files
+}
+
+// auditLinks checks for every regular file of the newer snapshot that rsync
+// considers unchanged whether it is hard-linked to the older snapshot. The
+// snapshot catalogs are used where available.
+func (b *Backup) auditLinks(older, newer string) linkAudit {
+	var audit linkAudit
+	olderEntries, err := b.loadCatalog(older)
+	if err != nil {
+		b.log("Warning: %v", err)
+		return audit
+	}
+	newerEntries, err := b.loadCatalog(newer)
+	if err != nil {
+		b.log("Warning: %v", err)
+		return audit
+	}
+
+	previous := make(map[string]CatalogEntry, len(olderEntries))
+	for _, e := range olderEntries {
+		if e.Mode.IsRegular() {
+			previous[e.Path] = e
+		}
+	}
+
+	for _, e := range newerEntries {
+		old, ok := previous[e.Path]
+		if !ok || !e.Mode.IsRegular() {
+			continue
+		}
+		audit.files++
+		if e.Inode == old.Inode {
+			audit.linked++
+		} else if e.Size == old.Size && e.MTime.Equal(old.MTime) && e.Mode == old.Mode &&
+			e.Uid == old.Uid && e.Gid == old.Gid {
+			audit.duplicates = append(audit.duplicates, e.Path)
+			audit.wastedBytes += e.Size
+		}
+	}
+	return audit
+}
+
+// runAuditLinks verifies that unchanged files are hard-linked between all
+// consecutive snapshots, detecting silently broken deduplication.
+func (b *Backup) runAuditLinks(args []string) error {
+	flags := flag.NewFlagSet("audit-links", flag.ExitOnError)
+	last := flags.Int("last", 0, "Only audit the newest N snapshots (0 = all)")
+	parseArgs(flags, args)
+
+	if b.isSSHPath(b.config.Destination) {
+		return fmt.Errorf("audit-links requires a local repository")
+	}
+
+	snapshots, err := b.listSnapshots()
+	if err != nil {
+		return fmt.Errorf("failed to read repository: %v", err)
+	}
+	if *last > 0 && len(snapshots) > *last {
+		snapshots = snapshots[len(snapshots)-*last:]
+	}
+	if len(snapshots) < 2 {
+		b.log("At least two snapshots are needed for a hard-link audit")
+		return nil
+	}
+
+	var totalDuplicates int
+	var totalWasted int64
+	for i := 1; i < len(snapshots); i++ {
+		audit := b.auditLinks(snapshots[i-1], snapshots[i])
+		ratio := "-"
+		if audit.files > 0 {
+			ratio = strconv.FormatFloat(float64(audit.linked)*100/float64(audit.files), 'f', 1, 64) + "%"
+		}
+		b.log("%s -> %s: %d common files, %d hard-linked (%s), %d duplicated (%.2f GB)",
+			snapshots[i-1], snapshots[i], audit.files, audit.linked, ratio,
+			len(audit.duplicates), float64(audit.wastedBytes)/(1024*1024*1024))
+		totalDuplicates += len(audit.duplicates)
+		totalWasted += audit.wastedBytes
+	}
+
+	if totalDuplicates > 0 {
+		return fmt.Errorf("%d unchanged files are not hard-linked (%.2f GB wasted); run fsck --repair to relink the newest snapshot",
+			totalDuplicates, float64(totalWasted)/(1024*1024*1024))
+	}
+	b.log("Hard-link chain is intact")
+	return nil
+}
+
+// relinkFile replaces dup by a hard link to orig if both have equal contents.
+func relinkFile(orig, dup string) error {
+	same, err := sameContents(orig, dup)
+	if err != nil {
+		return err
+	}
+	if !same {
+		return nil // Contents differ, the copy is legitimate
+	}
+
+	tmp := dup + ".relink"
+	if err := os.Link(orig, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dup); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// sameContents compares two files byte by byte.
+func sameContents(a, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	bufA := make([]byte, 64*1024)
+	bufB := make([]byte, 64*1024)
+	for {
+		na, errA := io.ReadFull(fa, bufA)
+		nb, errB := io.ReadFull(fb, bufB)
+		if na != nb || !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
+}
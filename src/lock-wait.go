@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Interval in which a waiting run tries to take the lock again
+const LockRetryInterval = 10 * time.Second
+
+// waitLockFlag implements -wait-lock[=timeout]: without a value the run
+// waits for the lock without limit, with a duration at most that long.
+type waitLockFlag struct {
+	set     bool
+	timeout time.Duration
+}
+
+func (f *waitLockFlag) String() string {
+	if f == nil || !f.set {
+		return ""
+	}
+	if f.timeout == 0 {
+		return "true"
+	}
+	return f.timeout.String()
+}
+
+func (f *waitLockFlag) Set(value string) error {
+	switch value {
+	case "true":
+		f.set, f.timeout = true, 0
+	case "false":
+		f.set, f.timeout = false, 0
+	default:
+		timeout, err := ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		f.set, f.timeout = true, timeout
+	}
+	return nil
+}
+
+// IsBoolFlag allows -wait-lock to be given without a value.
+func (f *waitLockFlag) IsBoolFlag() bool { return true }
+
+// waitForLock retries to create the lock until the run holding it is done
+// or the -wait-lock timeout has passed.
+func (b *Backup) waitForLock() error {
+	start := time.Now()
+	lastReport := start
+	b.log("Backup already running (lock: %s), waiting for it to finish", b.config.LockFile)
+	for {
+		time.Sleep(LockRetryInterval)
+		err := os.Mkdir(b.config.LockFile, 0755)
+		if err == nil {
+			b.log("Lock acquired after %s", time.Since(start).Round(time.Second))
+			return nil
+		}
+		if !os.IsExist(err) {
+			return fmt.Errorf("failed to create lock: %v", err)
+		}
+
+		waited := time.Since(start)
+		if b.config.WaitLockTimeout > 0 && waited >= b.config.WaitLockTimeout {
+			return fmt.Errorf("backup still running after waiting %s (lock: %s)", waited.Round(time.Second), b.config.LockFile)
+		}
+		if time.Since(lastReport) >= time.Minute {
+			b.log("Still waiting for lock %s (%s so far)", b.config.LockFile, waited.Round(time.Second))
+			lastReport = time.Now()
+		}
+	}
+}
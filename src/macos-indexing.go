@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// excludeFromIndexing keeps Spotlight and Time Machine away from a local
+// repository on macOS: Spotlight would index millions of hard-linked files
+// and Time Machine would back up the backups. Both exclusions are sticky, so
+// this only does work on the first run against a destination.
+func (b *Backup) excludeFromIndexing() {
+	if runtime.GOOS != "darwin" || b.config.AllowIndexing || b.isSSHPath(b.config.Destination) {
+		return
+	}
+
+	neverIndex := filepath.Join(b.config.Destination, ".metadata_never_index")
+	if _, err := os.Stat(neverIndex); os.IsNotExist(err) {
+		if err := os.WriteFile(neverIndex, nil, 0644); err != nil {
+			b.log("Warning: failed to exclude repository from Spotlight: %v", err)
+		} else {
+			b.log("Excluded repository from Spotlight indexing")
+		}
+	}
+
+	output, err := exec.Command("tmutil", "isexcluded", b.config.Destination).CombinedOutput()
+	if err != nil || strings.Contains(string(output), "[Excluded]") {
+		return
+	}
+	if output, err := exec.Command("tmutil", "addexclusion", b.config.Destination).CombinedOutput(); err != nil {
+		b.log("Warning: failed to exclude repository from Time Machine: %v: %s", err, strings.TrimSpace(string(output)))
+		return
+	}
+	b.log("Excluded repository from Time Machine")
+}
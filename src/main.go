@@ -12,18 +12,48 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+
+	"go-rsync-backup/internal/rsyncpath"
 )
 
 type Backup struct {
-	config     Config
-	timestamp  string
-	snapDir    string
-	latestLink string
-	logFile    *os.File
+	config        Config
+	sources       []string
+	autoExcludes  []string
+	timestamp     string
+	snapDir       string
+	latestLink    string
+	logFile       *os.File
+	combinedLog   *os.File // combined_log_file, shared by all jobs
+	started       time.Time
+	rsyncVersion  string
+	rsyncOptions  map[string]bool // Long options supported by RsyncBin
+	changes       []itemizedChange
+	transferredGB float64
+	sentFiles     int              // Files transferred by rsync
+	quotaReached  bool             // rsync was stopped at max_transfer_per_run
+	timedOut      bool             // rsync was stopped at max_run_time
+	skipped       bool             // The run was skipped by min_interval or deferral
+	unchanged     string           // Previous snapshot, if the source had not changed since
+	reclaimed     int64            // Bytes freed by deleting pruned snapshots
+	pruned        int              // Snapshots removed by retention, or selected in a dry run
+	runLog        *strings.Builder // Log of this run, kept if snapshot_log is set
+	env           []string         // Environment for rsync and hooks, see childEnv
+	destCaps      *destCaps        // Destination filesystem features, see destinationCaps
+	apfsSnapshot  string           // Date of the local APFS snapshot read from, see createAPFSSnapshot
+	snapshotRoot  string           // Mount point of that snapshot
+	seeding       bool             // First copy into an empty repository, see startSeeding
+	fastRun       bool             // Only changed paths are transferred, see planFastRun
+	fastChanges   int              // Number of paths changed and deleted in a fast run
+	fileList      string           // File list of a fast run
+	sourceState   *changeCache     // Source state recorded for the next run
 }
 
 func main() {
@@ -32,13 +62,17 @@ func main() {
 	// Parse command line arguments
 	configFile := flag.String("config", "config.json", "Configuration file path")
 	dryRun := flag.Bool("dry-run", false, "Perform a dry run (no changes)")
+	jobName := flag.String("job", "", "Run only the job with this name (configuration with jobs)")
+	failFast := flag.Bool("fail-fast", false, "Do not run the remaining jobs after a job failed (configuration with jobs)")
+	waitLock := &waitLockFlag{}
+	deadline := &deadlineFlag{}
+	flag.Var(deadline, "deadline", "Start no further job and stop the running transfer after this, as a duration (4h) or time of day (06:00)")
+	flag.Var(waitLock, "wait-lock", "Wait for a running backup to finish instead of failing (optionally at most this long, e.g. -wait-lock=2h)")
 	help := flag.Bool("help", false, "Show help")
 	flag.Parse()
 
 	if *help {
-		fmt.Println("Go Rsync Backup Tool")
-		fmt.Println("Usage: backup [options]")
-		flag.PrintDefaults()
+		printUsage()
 		os.Exit(0)
 	}
 
@@ -62,12 +96,6 @@ func main() {
 		}
 	}
 
-	// Check if running as root
-	if os.Geteuid() != 0 {
-		fmt.Println("This program must be run as root")
-		os.Exit(1)
-	}
-
 	// Load configuration
 	config, err := LoadConfig(*configFile)
 	if err != nil {
@@ -75,12 +103,82 @@ func main() {
 		os.Exit(1)
 	}
 
+	// The backup command is the same as no command; run selects jobs
+	var names, skip []string
+	if *jobName != "" {
+		names = []string{*jobName}
+	}
+	args := flag.Args()
+	if len(args) > 0 && args[0] == "backup" {
+		backupDryRun, err := parseBackupArgs(args[1:])
+		if err != nil {
+			log.Printf("%v", err)
+			os.Exit(1)
+		}
+		*dryRun = *dryRun || backupDryRun
+		args = nil
+	} else if len(args) > 0 && args[0] == "run" {
+		var runDryRun bool
+		names, skip, runDryRun, err = parseRunArgs(args[1:])
+		if err == nil && *jobName != "" {
+			err = fmt.Errorf("-job cannot be combined with run")
+		}
+		if err != nil {
+			log.Printf("%v", err)
+			os.Exit(1)
+		}
+		*dryRun = *dryRun || runDryRun
+		args = nil
+	}
+
+	// A configuration with jobs runs all of them or the selected ones
+	jobs, err := selectJobs(config, names, skip)
+	if err != nil {
+		log.Printf("%v", err)
+		os.Exit(1)
+	}
+
+	// Backups need root unless privileged_command provides it
+	if os.Geteuid() != 0 && (len(args) == 0 || !unprivilegedCommands[args[0]]) {
+		for _, job := range jobs {
+			if job.PrivilegedCommand == "" {
+				fmt.Println("This program must be run as root (or with privileged_command)")
+				os.Exit(1)
+			}
+		}
+	}
+
 	// Override with command line flags
-	if *dryRun {
-		config.DryRun = true
+	for i := range jobs {
+		if *dryRun {
+			jobs[i].DryRun = true
+		}
+		jobs[i].WaitLock = waitLock.set
+		jobs[i].WaitLockTimeout = waitLock.timeout
+		jobs[i].Deadline = deadline.at
 	}
 
-	backup := NewBackup(config)
+	// Run a subcommand instead of a backup if one was given
+	if len(args) > 0 {
+		if len(jobs) > 1 {
+			log.Printf("The configuration has %d jobs, select one with -job", len(jobs))
+			os.Exit(1)
+		}
+		if err := runCommand(jobs[0], args); err != nil {
+			log.Printf("%s failed: %v", args[0], err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(jobs) > 1 {
+		if err := runJobs(jobs, *failFast); err != nil {
+			log.Printf("Backup failed: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+	backup := NewBackup(jobs[0])
 	if err := backup.Run(); err != nil {
 		log.Printf("Backup failed: %v", err)
 		os.Exit(1)
@@ -88,28 +186,226 @@ func main() {
 }
 
 func NewBackup(config Config) *Backup {
-	timestamp := time.Now().Format("MST_2006-01-02_15.04.05")
+	// Nest the repository under the short hostname if configured
+	if config.PerHostLayout {
+		config.Destination = filepath.Join(config.Destination, shortHostname())
+	}
+	// Each tenant of a shared destination gets a subtree of their own
+	if config.RepositoryOwner != "" {
+		config.Destination = filepath.Join(config.Destination, ownerName(config.RepositoryOwner))
+	}
+
+	// Jobs sharing a repository keep separate snapshot chains
+	started := time.Now()
+	timestamp := started.Format("MST_2006-01-02_15.04.05")
+	latestLink := "latest"
+	if config.SnapshotPrefix != "" {
+		timestamp = config.SnapshotPrefix + "_" + timestamp
+		latestLink += "-" + config.SnapshotPrefix
+	}
 	return &Backup{
 		config:     config,
 		timestamp:  timestamp,
 		snapDir:    filepath.Join(config.Destination, timestamp+"_INCOMPLETE"),
-		latestLink: filepath.Join(config.Destination, "latest"),
+		latestLink: filepath.Join(config.Destination, latestLink),
+		started:    started,
 	}
 }
 
 func (b *Backup) validateConfig() error {
-	if b.config.Source == "" {
+	if b.config.Source == "" && len(b.config.Sources) == 0 {
 		return fmt.Errorf("source path cannot be empty")
 	}
+	if b.config.Source != "" && len(b.config.Sources) > 0 {
+		return fmt.Errorf("use either source or sources, not both")
+	}
+	for _, source := range b.sourcePaths() {
+		// Paths inside a daemon module are relative to the module
+		loc := rsyncpath.Parse(source)
+		if b.relativeSources() && loc.Kind != rsyncpath.Daemon && !loc.IsAbs() {
+			return fmt.Errorf("sources and source patterns must be absolute paths: %s", source)
+		}
+	}
 	if b.config.Destination == "" {
 		return fmt.Errorf("destination path cannot be empty")
 	}
+	for _, dest := range []string{b.config.Destination, b.config.OffsiteDestination} {
+		if rsyncpath.Parse(dest).Kind == rsyncpath.Daemon {
+			return fmt.Errorf("%s is an rsync daemon location, destinations must be local or user@host:path (SSH)", dest)
+		}
+	}
 	if b.config.Keep < 1 {
 		return fmt.Errorf("keep must be at least 1")
 	}
 	if b.config.CleanupAtPercent < 50 || b.config.CleanupAtPercent > 95 {
 		return fmt.Errorf("cleanup_at_percent must be between 50-95")
 	}
+	if b.config.MaxRepositorySize != "" {
+		if _, err := ParseSize(b.config.MaxRepositorySize); err != nil {
+			return fmt.Errorf("max_repository_size: %v", err)
+		}
+	}
+	for _, rule := range b.config.Thinning {
+		if _, err := ParseDuration(rule.Within); err != nil {
+			return fmt.Errorf("thinning within: %v", err)
+		}
+		if every, err := ParseDuration(rule.Every); err != nil || every == 0 {
+			return fmt.Errorf("thinning every: invalid duration %q", rule.Every)
+		}
+	}
+	if b.config.MaxSnapshotAge != "" {
+		if _, err := ParseDuration(b.config.MaxSnapshotAge); err != nil {
+			return fmt.Errorf("max_snapshot_age: %v", err)
+		}
+	}
+	if b.config.OffsiteDestination != "" && b.config.OffsiteDestination == b.config.Destination {
+		return fmt.Errorf("offsite_destination must differ from destination")
+	}
+	switch b.config.DeleteMode {
+	case "", "before", "during", "delay", "after":
+	default:
+		return fmt.Errorf("delete_mode must be before, during, delay or after")
+	}
+	if b.config.LinkDestCount < 0 || b.config.LinkDestCount > 20 {
+		return fmt.Errorf("link_dest_count must be between 0 and 20 (rsync's limit)")
+	}
+	if b.config.SeedRepository != "" && (b.isSSHPath(b.config.SeedRepository) || b.isSSHPath(b.config.Destination)) {
+		return fmt.Errorf("seed_repository and destination must be local paths")
+	}
+	for _, a := range b.config.Assertions {
+		if err := a.validate(); err != nil {
+			return err
+		}
+	}
+	if b.config.EjectAfterBackup && b.isSSHPath(b.config.Destination) {
+		return fmt.Errorf("eject_after_backup requires a local destination")
+	}
+	if b.config.ForceSystemRsync && b.config.RsyncBin != "" {
+		return fmt.Errorf("rsync_bin cannot be combined with force_system_rsync")
+	}
+	if b.config.MinRsyncVersion != "" {
+		if _, err := versionNumber(b.config.MinRsyncVersion); err != nil {
+			return fmt.Errorf("min_rsync_version: %v", err)
+		}
+	}
+	if b.config.MaxChangedPercent < 0 || b.config.MaxChangedPercent > 100 {
+		return fmt.Errorf("max_changed_percent must be between 0 and 100")
+	}
+	switch {
+	case b.config.Compress != "" && b.config.Compress != "none" && !slices.Contains(compressChoices, b.config.Compress):
+		return fmt.Errorf("compress must be zstd, lz4, zlibx, zlib or none")
+	case (b.config.Compress == "" || strings.HasPrefix(b.config.Compress, "zlib")) && (b.config.CompressLevel < 0 || b.config.CompressLevel > 9):
+		return fmt.Errorf("compress_level must be between 1 and 9 for zlib")
+	case b.config.CompressLevel < 0 || b.config.CompressLevel > 22:
+		return fmt.Errorf("compress_level must be between 1 and 22")
+	}
+	if b.config.RetentionWarningDays < 0 {
+		return fmt.Errorf("retention_warning_days must not be negative")
+	}
+	if b.config.MaxDeletePercent < 0 || b.config.MaxDeletePercent > 100 {
+		return fmt.Errorf("max_delete_percent must be between 0 and 100")
+	}
+	if b.config.MinInterval != "" {
+		if _, err := ParseDuration(b.config.MinInterval); err != nil {
+			return fmt.Errorf("invalid min_interval: %v", err)
+		}
+	}
+	if b.config.MaxLoad < 0 {
+		return fmt.Errorf("max_load must not be negative")
+	}
+	if b.config.MaxIOPressure < 0 || b.config.MaxIOPressure > 100 {
+		return fmt.Errorf("max_io_pressure must be between 0 and 100")
+	}
+	for name := range b.config.Env {
+		if name == "" || strings.ContainsAny(name, "= ") {
+			return fmt.Errorf("invalid env variable name %q", name)
+		}
+	}
+	if b.config.VerifyWorkers < 0 {
+		return fmt.Errorf("verify_workers must not be negative")
+	}
+	if b.config.VerifyRateLimit != "" {
+		if _, err := ParseSize(b.config.VerifyRateLimit); err != nil {
+			return fmt.Errorf("invalid verify_rate_limit: %v", err)
+		}
+	}
+	if b.config.MaxTransferPerRun != "" {
+		if _, err := ParseSize(b.config.MaxTransferPerRun); err != nil {
+			return fmt.Errorf("invalid max_transfer_per_run: %v", err)
+		}
+	}
+	if b.config.SeedTransferCap != "" {
+		if _, err := ParseSize(b.config.SeedTransferCap); err != nil {
+			return fmt.Errorf("invalid seed_transfer_cap: %v", err)
+		}
+	}
+	if b.config.MaxRunTime != "" {
+		if _, err := ParseDuration(b.config.MaxRunTime); err != nil {
+			return fmt.Errorf("invalid max_run_time: %v", err)
+		}
+	}
+	if b.config.FullScanInterval != "" {
+		if _, err := ParseDuration(b.config.FullScanInterval); err != nil {
+			return fmt.Errorf("invalid full_scan_interval: %v", err)
+		}
+	}
+	if b.config.MinIdle != "" {
+		if _, err := ParseDuration(b.config.MinIdle); err != nil {
+			return fmt.Errorf("invalid min_idle: %v", err)
+		}
+	}
+	if b.config.DeferMaxWait != "" {
+		if _, err := ParseDuration(b.config.DeferMaxWait); err != nil {
+			return fmt.Errorf("invalid defer_max_wait: %v", err)
+		}
+	}
+	if b.config.MinFreeSpace != "" {
+		if _, err := ParseSize(b.config.MinFreeSpace); err != nil {
+			return fmt.Errorf("min_free_space: %v", err)
+		}
+	}
+	switch b.config.SSHAddressFamily {
+	case "", "any", "inet", "inet6":
+	default:
+		return fmt.Errorf("ssh_address_family must be any, inet or inet6")
+	}
+	if (b.config.APFSSnapshot || len(b.config.QuiesceApps) > 0) && runtime.GOOS != "darwin" {
+		return fmt.Errorf("apfs_snapshot and quiesce_apps are only supported on macOS")
+	}
+	if err := b.validateResourceLimits(); err != nil {
+		return err
+	}
+	if b.config.SigningKey != "" {
+		if _, err := b.loadSigningKey(); err != nil {
+			return fmt.Errorf("signing_key: %v", err)
+		}
+	}
+	if b.config.APFSSnapshot && b.isSSHPath(b.config.Source) {
+		return fmt.Errorf("apfs_snapshot requires a local source")
+	}
+	if b.config.RsyncOutputRetention != "" {
+		if _, err := ParseDuration(b.config.RsyncOutputRetention); err != nil {
+			return fmt.Errorf("rsync_output_retention: %v", err)
+		}
+	}
+	if b.config.TrashRetention != "" {
+		if _, err := ParseDuration(b.config.TrashRetention); err != nil {
+			return fmt.Errorf("trash_retention: %v", err)
+		}
+	}
+	if b.config.SnapshotPrefix != "" && !snapshotPrefixRe.MatchString(b.config.SnapshotPrefix) {
+		return fmt.Errorf("snapshot_prefix may only contain letters, digits, '.', '_' and '-'")
+	}
+	if owner := b.config.RepositoryOwner; owner != "" {
+		if name, group, _ := strings.Cut(owner, ":"); !snapshotPrefixRe.MatchString(name) || (group != "" && !snapshotPrefixRe.MatchString(group)) {
+			return fmt.Errorf("repository_owner must be user or user:group")
+		}
+		if !b.isSSHPath(b.config.Destination) {
+			if _, err := lookupOwner(owner); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
@@ -153,6 +449,10 @@ func (b *Backup) verifyBackup() error {
 		return nil // Skip verification for dry runs
 	}
 
+	if b.isSSHPath(b.config.Destination) {
+		return b.verifyRemoteBackup()
+	}
+
 	// Check if backup directory exists and has content
 	if _, err := os.Stat(b.snapDir); os.IsNotExist(err) {
 		return fmt.Errorf("backup directory not created: %s", b.snapDir)
@@ -172,20 +472,82 @@ func (b *Backup) verifyBackup() error {
 	return nil
 }
 
+func (b *Backup) verifyRemoteBackup() error {
+	host, path := splitSSHPath(b.snapDir)
+
+	// Check if backup directory exists and count its entries and files
+	cmd := fmt.Sprintf("test -d %[1]s && find %[1]s -mindepth 1 -maxdepth 1 | wc -l && find %[1]s -type f | wc -l",
+		shellQuote(path))
+	output, err := b.runRemote(host, cmd)
+	if err != nil {
+		return fmt.Errorf("backup directory not created: %s (%v)", b.snapDir, err)
+	}
+
+	fields := strings.Fields(output)
+	if len(fields) < 2 {
+		return fmt.Errorf("unexpected remote verification output: %q", output)
+	}
+	entries, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse remote entry count: %v", err)
+	}
+	files, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return fmt.Errorf("failed to parse remote file count: %v", err)
+	}
+
+	if entries == 0 {
+		return fmt.Errorf("backup directory is empty")
+	}
+
+	b.log("Backup verification: %d items in remote backup (%d files)", entries, files)
+	return nil
+}
+
+// Run performs a backup and records its outcome in the history file.
 func (b *Backup) Run() error {
+	err := b.run()
+	b.appendHistory(err)
+	return err
+}
+
+func (b *Backup) run() error {
 	// Validate configuration
 	if err := b.validateConfig(); err != nil {
 		return fmt.Errorf("config validation failed: %v", err)
 	}
 
+	// Do nothing if the last snapshot is recent enough
+	if b.skipIfRecent() {
+		b.skipped = true
+		return nil
+	}
+
+	// Wait until the machine is quiet enough, or skip this run
+	if !b.deferUntilQuiet() {
+		b.skipped = true
+		return nil
+	}
+
+	// Resolve glob patterns in the source paths
+	if err := b.expandSources(); err != nil {
+		return fmt.Errorf("source expansion failed: %v", err)
+	}
+
 	// Setup signal handling
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(c) // The next job installs its own handler
 	go func() {
 		sig := <-c
 		b.cleanup(sig, 1)
 	}()
 
+	// Check site-specific invariants before touching any path
+	if err := b.checkAssertions(); err != nil {
+		return fmt.Errorf("pre-flight assertion failed: %v", err)
+	}
+
 	// Validate paths
 	if err := b.validatePaths(); err != nil {
 		return fmt.Errorf("path validation failed: %v", err)
@@ -206,9 +568,29 @@ func (b *Backup) Run() error {
 	if err := b.setupLogging(); err != nil {
 		return fmt.Errorf("failed to setup logging: %v", err)
 	}
-	defer b.logFile.Close()
+	defer b.closeLogging()
+	if b.config.SnapshotLog {
+		b.runLog = &strings.Builder{}
+	}
 
 	b.log("Starting backup: %s", b.timestamp)
+	defer b.inhibitSleep()()
+	if !b.isSSHPath(b.config.Destination) {
+		b.logQuarantined()
+	}
+	b.excludeFromIndexing()
+
+	// Personal data should not end up on an unencrypted disk unnoticed
+	if err := b.checkDestinationEncryption(); err != nil {
+		return fmt.Errorf("destination check failed: %v", err)
+	}
+
+	// Make room before the transfer
+	if b.config.MinFreeSpace != "" {
+		if err := b.withRepositoryLock("Freeing space", b.ensureFreeSpace); err != nil {
+			b.log("Warning: free space check failed: %v", err)
+		}
+	}
 
 	// Find rsync binary
 	if err := b.findRsync(); err != nil {
@@ -216,56 +598,226 @@ func (b *Backup) Run() error {
 	}
 
 	// Get last backup
+	b.repairLatestLink()
 	lastBackup := b.getLastBackup()
 	b.log("Last backup: %s", lastBackup)
+	b.startSeeding(lastBackup)
+	if err := b.checkSnapshotAge(); err != nil && lastBackup != "(none)" {
+		b.log("Warning: %v", err)
+	}
+
+	// Find the changed paths without letting rsync compare everything
+	b.planFastRun(lastBackup)
+	defer b.endFastRun()
+
+	// Do not create a snapshot identical to the previous one
+	if b.config.SkipUnchanged && lastBackup != "(none)" && !b.config.DryRun {
+		var unchanged bool
+		var err error
+		if b.fastRun {
+			unchanged = b.fastChanges == 0
+		} else {
+			unchanged, err = b.sourceUnchanged(lastBackup)
+		}
+		if err != nil {
+			b.log("Warning: change check failed, creating a snapshot: %v", err)
+		} else if unchanged {
+			b.unchanged = lastBackup
+			b.log("Nothing changed since %s, no snapshot created", lastBackup)
+			return nil
+		}
+	}
+
+	// Check what the transfer would do before running it for real
+	if err := b.safetyDryRun(lastBackup); err != nil {
+		b.alert("critical", fmt.Sprintf("Backup of %s aborted: %v", strings.Join(b.sourcePaths(), ", "), err))
+		return fmt.Errorf("safety dry run failed: %v", err)
+	}
+
+	// Continue a snapshot stopped by the transfer quota
+	b.resumePartial()
+
+	// Read the sources in a consistent state
+	b.warnOpenDatabases()
+	if b.config.APFSSnapshot && !b.config.DryRun {
+		if err := b.createAPFSSnapshot(); err != nil {
+			b.log("Warning: reading sources live: %v", err)
+		}
+		defer b.removeAPFSSnapshot()
+	} else if len(b.config.QuiesceApps) > 0 && !b.config.DryRun {
+		defer b.reopenApps(b.quiesceApps())
+	}
+
+	// Start a fast run from a copy of the previous snapshot
+	b.cloneForFastRun(lastBackup)
 
 	// Run rsync
 	if err := b.runRsync(lastBackup); err != nil {
+		b.writeIncompleteMeta(err)
+		if b.quotaReached || b.timedOut {
+			return b.keepPartial(b.stopReason())
+		}
+		if b.seeding {
+			// Days of copying are not thrown away for a network outage
+			if keepErr := b.keepPartial("an rsync error"); keepErr != nil {
+				b.log("Warning: %v", keepErr)
+			}
+			return fmt.Errorf("rsync failed, the next run continues seeding: %v", err)
+		}
+		b.quarantineSnapshot(fmt.Sprintf("rsync failed: %v", err))
 		return fmt.Errorf("rsync failed: %v", err)
 	}
 
 	// Verify backup integrity
 	if err := b.verifyBackup(); err != nil {
+		b.quarantineSnapshot(fmt.Sprintf("backup verification failed: %v", err))
 		return fmt.Errorf("backup verification failed: %v", err)
 	}
 
+	// Compare a random sample of files against the source
+	if err := b.verifySample(); err != nil {
+		b.log("Warning: sample verification failed: %v", err)
+	}
+
+	// Compare the files written by this run against the source
+	if err := b.verifyChanged(); err != nil {
+		b.log("Warning: changed-file verification failed: %v", err)
+	}
+
+	// Record how the snapshot was produced
+	if err := b.writeSnapshotMeta(); err != nil {
+		b.log("Warning: failed to write snapshot metadata: %v", err)
+	}
+	if err := b.writeSysinfo(); err != nil {
+		b.log("Warning: failed to write system manifest: %v", err)
+	}
+
+	// Index the snapshot for fast queries
+	if err := b.writeCatalog(); err != nil {
+		b.log("Warning: failed to write catalog: %v", err)
+	}
+
+	// Record what changed since the previous snapshot
+	changes, err := b.writeChanges(lastBackup)
+	if err != nil {
+		b.log("Warning: failed to record changes: %v", err)
+	} else if err := b.checkChangeAnomaly(changes, lastBackup); err != nil {
+		// Keep a mass-changed snapshot from replacing good history
+		b.alert("critical", fmt.Sprintf("Backup of %s aborted: %v", strings.Join(b.sourcePaths(), ", "), err))
+		b.quarantineSnapshot(fmt.Sprintf("change anomaly: %v", err))
+		return fmt.Errorf("change anomaly detected: %v", err)
+	}
+	deleted := 0
+	for _, c := range changes {
+		if c.Kind == "deleted" {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		b.audit("rsync-delete", b.timestamp, fmt.Sprintf("%d paths deleted from the source since %s", deleted, lastBackup))
+	}
+
+	// Sign the snapshot so later changes to it are detected
+	if err := b.signSnapshot(lastBackup); err != nil {
+		b.log("Warning: failed to sign snapshot: %v", err)
+	}
+
 	// Finalize backup (remove _INCOMPLETE suffix)
 	if err := b.finalizeBackup(); err != nil {
 		return fmt.Errorf("failed to finalize backup: %v", err)
 	}
+	defer b.writeRunLog()
 
 	// Update latest link
 	if err := b.updateLatestLink(); err != nil {
 		return fmt.Errorf("failed to update latest link: %v", err)
 	}
+	b.seedingSummary()
+	b.saveChangeCache()
 
 	// Cleanup old backups
-	if err := b.cleanupOldBackups(); err != nil {
+	if err := b.withRepositoryLock("Pruning", b.cleanupOldBackups); err != nil {
 		b.log("Warning: cleanup failed: %v", err)
 	}
 
+	// Delete pruned snapshots in the background I/O class
+	b.purgeTrash()
+	if b.reclaimed > 0 {
+		b.log("Pruning reclaimed %.2f GB", gib(b.reclaimed))
+	}
+
+	// Warn before retention outgrows the disk
+	b.checkRetentionHealth()
+
+	// Only report success once the snapshot is on the disk
+	if err := b.flushDestination(); err != nil {
+		return fmt.Errorf("failed to flush destination: %v", err)
+	}
+
 	b.log("Backup completed successfully")
+
+	// Replicate the new snapshot offsite
+	if b.config.OffsiteDestination != "" {
+		if err := b.replicateOffsite(); err != nil {
+			return fmt.Errorf("offsite replication failed: %v", err)
+		}
+	}
+
+	// Eject the destination disk
+	if b.config.EjectAfterBackup && !b.config.DryRun {
+		b.writeRunLog() // While the disk is still attached
+		if err := b.ejectDestination(); err != nil {
+			return fmt.Errorf("backup succeeded but ejecting the destination failed: %v", err)
+		}
+	}
 	return nil
 }
 
 func (b *Backup) validatePaths() error {
-	// Create destination directory
-	if err := os.MkdirAll(b.config.Destination, 0755); err != nil {
+	// Create destination directory on the remote host
+	if b.isSSHPath(b.config.Destination) {
+		host, path := splitSSHPath(b.config.Destination)
+		if _, err := b.runRemote(host, "mkdir -p "+shellQuote(path)); err != nil {
+			return fmt.Errorf("failed to create remote destination: %v", err)
+		}
+	} else if err := os.MkdirAll(b.config.Destination, 0755); err != nil {
 		return fmt.Errorf("failed to create destination: %v", err)
 	}
+	if err := b.secureOwnerTree(); err != nil {
+		return err
+	}
+
+	for _, source := range b.sourcePaths() {
+		if b.isSSHPath(source) {
+			continue // Checked by rsync on the remote host
+		}
 
-	// Check source exists
-	if _, err := os.Stat(b.config.Source); os.IsNotExist(err) {
-		return fmt.Errorf("source does not exist: %s", b.config.Source)
+		// Check source exists
+		if _, err := os.Stat(source); os.IsNotExist(err) {
+			return fmt.Errorf("source does not exist: %s", source)
+		}
+
+		// Check if paths are accessible
+		if err := exec.Command("df", source).Run(); err != nil {
+			return fmt.Errorf("source path %s is not accessible or mounted", source)
+		}
 	}
 
-	// Check if paths are accessible
-	if err := exec.Command("df", b.config.Source).Run(); err != nil {
-		return fmt.Errorf("source path %s is not accessible or mounted", b.config.Source)
+	// Refuse to mirror an empty or unmounted source into a new snapshot
+	if err := b.checkSourcePopulated(); err != nil {
+		return err
 	}
 
-	if err := exec.Command("df", b.config.Destination).Run(); err != nil {
-		return fmt.Errorf("destination path %s is not accessible or mounted", b.config.Destination)
+	// Never back up the backups
+	if err := b.checkOverlap(); err != nil {
+		return err
+	}
+	b.excludeBackupStores()
+
+	if !b.isSSHPath(b.config.Destination) {
+		if err := exec.Command("df", b.config.Destination).Run(); err != nil {
+			return fmt.Errorf("destination path %s is not accessible or mounted", b.config.Destination)
+		}
 	}
 
 	return nil
@@ -273,6 +825,9 @@ func (b *Backup) validatePaths() error {
 
 func (b *Backup) createLock() error {
 	if err := os.Mkdir(b.config.LockFile, 0755); err != nil {
+		if os.IsExist(err) && b.config.WaitLock {
+			return b.waitForLock()
+		}
 		if os.IsExist(err) {
 			return fmt.Errorf("backup already running (lock: %s). If not, remove the lock directory manually", b.config.LockFile)
 		}
@@ -288,6 +843,7 @@ func (b *Backup) removeLock() {
 func (b *Backup) cleanup(sig os.Signal, exitCode int) {
 	if b.logFile != nil {
 		b.log("Backup interrupted by signal: %v", sig)
+		b.quarantineSnapshot(fmt.Sprintf("interrupted by signal: %v", sig))
 	}
 	b.removeLock()
 	os.Exit(exitCode)
@@ -305,6 +861,17 @@ func (b *Backup) setupLogging() error {
 		return fmt.Errorf("failed to open log file: %v", err)
 	}
 
+	// Jobs with their own log_file also write into the combined log
+	if b.config.CombinedLogFile != "" && b.config.CombinedLogFile != b.config.LogFile {
+		if err := os.MkdirAll(filepath.Dir(b.config.CombinedLogFile), 0755); err != nil {
+			return fmt.Errorf("failed to create log directory: %v", err)
+		}
+		b.combinedLog, err = os.OpenFile(b.config.CombinedLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open combined log file: %v", err)
+		}
+	}
+
 	// Add separator
 	fmt.Fprintf(b.logFile, "\n%s\n", strings.Repeat("=", 80))
 
@@ -314,15 +881,37 @@ func (b *Backup) setupLogging() error {
 	return nil
 }
 
+// closeLogging closes the log files opened by setupLogging.
+func (b *Backup) closeLogging() {
+	if b.logFile != nil {
+		b.logFile.Close()
+		b.logFile = nil
+	}
+	if b.combinedLog != nil {
+		b.combinedLog.Close()
+		b.combinedLog = nil
+	}
+}
+
 func (b *Backup) log(format string, args ...interface{}) {
 	timestamp := time.Now().Format("2006-01-02 15:04:05")
 	message := fmt.Sprintf(format, args...)
+	if b.config.Name != "" {
+		// Jobs may share a log file
+		message = "[" + b.config.Name + "] " + message
+	}
 	logLine := fmt.Sprintf("%s %s\n", timestamp, message)
 
 	fmt.Print(logLine)
 	if b.logFile != nil {
 		b.logFile.WriteString(logLine)
 	}
+	if b.combinedLog != nil {
+		b.combinedLog.WriteString(logLine)
+	}
+	if b.runLog != nil {
+		b.runLog.WriteString(logLine)
+	}
 }
 
 func (b *Backup) cleanupLog() {
@@ -355,20 +944,31 @@ func (b *Backup) cleanupLog() {
 func (b *Backup) findRsync() error {
 	if b.config.ForceSystemRsync {
 		b.config.RsyncBin = "/usr/bin/rsync"
+		if b.isOpenRsync() {
+			return fmt.Errorf("%s is openrsync, which does not support all rsync options this tool needs. Set force_system_rsync to false and install rsync with: brew install rsync", b.config.RsyncBin)
+		}
 		b.log("Using system rsync (forced by ForceSystemRsync=true)")
 		return nil
 	}
 
 	paths := []string{
+		bundledRsyncPath(),        // Installed by install-rsync
 		"/opt/homebrew/bin/rsync", // macOS Homebrew (Apple Silicon)
 		"/usr/local/bin/rsync",    // macOS Homebrew (Intel) / Linux
 		"/usr/bin/rsync",          // System rsync (macOS/Linux)
 	}
 
-	for _, path := range paths {
-		if _, err := os.Stat(path); err == nil {
-			b.config.RsyncBin = path
-			break
+	if b.config.RsyncBin != "" {
+		// Configured explicitly with rsync_bin
+		if info, err := os.Stat(b.config.RsyncBin); err != nil || info.IsDir() {
+			return fmt.Errorf("rsync_bin %s not found", b.config.RsyncBin)
+		}
+	} else {
+		for _, path := range paths {
+			if _, err := os.Stat(path); err == nil && path != "" {
+				b.config.RsyncBin = path
+				break
+			}
 		}
 	}
 
@@ -376,6 +976,11 @@ func (b *Backup) findRsync() error {
 		return fmt.Errorf("no rsync binary found")
 	}
 
+	// Newer macOS ships openrsync as /usr/bin/rsync, which rejects our flags
+	if b.isOpenRsync() {
+		return fmt.Errorf("%s is openrsync, which does not support all rsync options this tool needs. Please install rsync with: brew install rsync", b.config.RsyncBin)
+	}
+
 	// Check if it's the old system rsync and warn
 	if b.config.RsyncBin == "/usr/bin/rsync" && !b.config.ForceSystemRsync {
 		version, err := b.getRsyncVersion()
@@ -384,10 +989,32 @@ func (b *Backup) findRsync() error {
 		}
 	}
 
+	// Enforce the configured minimum version
+	if b.config.MinRsyncVersion != "" {
+		if err := b.checkMinRsyncVersion(); err != nil {
+			return err
+		}
+	}
+
 	b.log("Using rsync: %s", b.config.RsyncBin)
 	return nil
 }
 
+// checkMinRsyncVersion fails if the selected rsync is older than
+// min_rsync_version.
+func (b *Backup) checkMinRsyncVersion() error {
+	version, err := b.getRsyncVersion()
+	if err != nil || version == "" {
+		return fmt.Errorf("cannot determine version of %s (min_rsync_version: %s)", b.config.RsyncBin, b.config.MinRsyncVersion)
+	}
+	have, _ := versionNumber(version)
+	want, _ := versionNumber(b.config.MinRsyncVersion)
+	if have < want {
+		return fmt.Errorf("%s is version %s, but min_rsync_version is %s", b.config.RsyncBin, version, b.config.MinRsyncVersion)
+	}
+	return nil
+}
+
 func (b *Backup) getRsyncVersion() (string, error) {
 	cmd := exec.Command(b.config.RsyncBin, "--version")
 	output, err := cmd.Output()
@@ -400,72 +1027,235 @@ func (b *Backup) getRsyncVersion() (string, error) {
 	return version, nil
 }
 
+// isOpenRsync reports whether the selected rsync binary is openrsync (the
+// BSD reimplementation shipped with macOS 15 and later) instead of rsync
+// from rsync.samba.org.
+func (b *Backup) isOpenRsync() bool {
+	output, err := exec.Command(b.config.RsyncBin, "--version").CombinedOutput()
+	return err == nil && strings.Contains(string(output), "openrsync")
+}
+
 func (b *Backup) isOldRsync(version string) bool {
-	parts := strings.Split(version, ".")
-	if len(parts) < 3 {
+	if len(strings.Split(version, ".")) < 3 {
 		return true
 	}
-
-	major, _ := strconv.Atoi(parts[0])
-	minor, _ := strconv.Atoi(parts[1])
-	patch, _ := strconv.Atoi(parts[2])
-
-	versionNum := major*10000 + minor*100 + patch
+	versionNum, _ := versionNumber(version)
 	return versionNum < 30200 // Less than 3.2.0
 }
 
+// versionNumber converts a version like "3.2.7" into 30207 for comparisons.
+// Missing components count as 0.
+func versionNumber(version string) (int, error) {
+	parts := strings.Split(version, ".")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid version %q", version)
+	}
+	num := 0
+	for i := 0; i < 3; i++ {
+		n := 0
+		if i < len(parts) {
+			var err error
+			if n, err = strconv.Atoi(parts[i]); err != nil || n < 0 || n > 99 {
+				return 0, fmt.Errorf("invalid version %q", version)
+			}
+		}
+		num = num*100 + n
+	}
+	return num, nil
+}
+
 func (b *Backup) getLastBackup() string {
+	if name := b.readLatestLink(); name != "" {
+		return name
+	}
+
+	// Fall back to the newest snapshot if the link is missing
+	if name := b.newestSnapshot(); name != "" {
+		return name
+	}
+	return "(none)"
+}
+
+// readLatestLink returns the snapshot name the latest link points to, or ""
+// if there is no link.
+func (b *Backup) readLatestLink() string {
+	if b.isSSHPath(b.config.Destination) {
+		host, path := splitSSHPath(b.latestLink)
+		output, err := b.runRemote(host, "readlink "+shellQuote(path))
+		if err != nil || strings.TrimSpace(output) == "" {
+			return ""
+		}
+		return filepath.Base(strings.TrimSpace(output))
+	}
+
 	target, err := os.Readlink(b.latestLink)
 	if err != nil {
-		return "(none)"
+		return ""
 	}
 	return filepath.Base(target)
 }
 
+// isSSHPath reports whether path is on another host (user@host:path, or an
+// rsync daemon, which is only accepted for sources).
 func (b *Backup) isSSHPath(path string) bool {
-	return strings.Contains(path, "@") && strings.Contains(path, ":")
+	return rsyncpath.Parse(path).Remote()
 }
 
 func (b *Backup) runRsync(lastBackup string) error {
-	b.log("SRC=%s DST=%s", b.config.Source, b.config.Destination)
+	b.log("SRC=%s DST=%s", strings.Join(b.sourcePaths(), ","), b.config.Destination)
+
+	args := b.buildRsyncArgs(lastBackup)
+	if b.fastRun {
+		args = b.fastRunArgs(args)
+	}
+
+	cmdStr := b.config.RsyncBin + " " + strings.Join(args, " ")
+	b.log("Running rsync: %s", cmdStr)
+	time.Sleep(time.Millisecond * 3000)
+
+	cmd := b.rsyncCommand(args...)
+	cmd.Env = b.childEnv()
+
+	// Use buffers to capture output while displaying it
+	var stdoutBuf, stderrBuf strings.Builder
 
-	args := make([]string, len(RsyncBaseArgs))
-	copy(args, RsyncBaseArgs)
+	stdoutPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// Copy output to both console and buffer simultaneously
+	stdout := io.MultiWriter(os.Stdout, &stdoutBuf)
+	stop := func() { cmd.Process.Signal(syscall.SIGTERM) }
+	meter := b.newTransferMeter(stop)
+	if meter != nil {
+		stdout = io.MultiWriter(stdout, meter)
+	}
+	defer b.reportSeedingProgress(meter)()
+	var copying sync.WaitGroup
+	copying.Go(func() { io.Copy(stdout, stdoutPipe) })
+	copying.Go(func() { io.Copy(io.MultiWriter(os.Stderr, &stderrBuf), stderrPipe) })
+
+	// Stop the transfer at the end of the allowed run time
+	var timedOut atomic.Bool
+	if timeout, ok := b.remainingRunTime(); ok {
+		timer := time.AfterFunc(timeout, func() {
+			timedOut.Store(true)
+			stop()
+		})
+		defer timer.Stop()
+	}
+
+	// Read all output before waiting, Wait closes the pipes
+	copying.Wait()
+	err = cmd.Wait()
+	b.quotaReached = meter != nil && meter.stopped.Load()
+	b.timedOut = timedOut.Load()
+	b.saveRsyncOutput(cmdStr, stdoutBuf.String(), stderrBuf.String(), err)
+	b.trackProblemFiles(stderrBuf.String(), err)
+	if err != nil {
+		b.recordPartialProgress(stdoutBuf.String(), stderrBuf.String())
+		return err
+	}
+
+	// Parse transferred data from captured output
+	combinedOutput := stdoutBuf.String() + stderrBuf.String()
+	gb := b.parseTransferredGB(combinedOutput)
+	b.transferredGB = gb
+	b.changes = parseItemized(stdoutBuf.String())
+	b.sentFiles = countTransferred(b.changes)
+	msg := fmt.Sprintf("Data transferred: %.2f GB", gb)
+	fmt.Println(msg)
+	b.log("%s", msg)
+
+	return nil
+}
+
+// buildRsyncArgs assembles the complete rsync argument list for this run,
+// including source and destination.
+func (b *Backup) buildRsyncArgs(lastBackup string) []string {
+	args := b.deleteArgs(b.supportedArgs(RsyncBaseArgs))
 
 	// Add SSH args if source or destination is remote
-	if b.isSSHPath(b.config.Source) || b.isSSHPath(b.config.Destination) {
-		args = append(args, RsyncSSHArgs...)
+	if b.hasSSHSource() || b.isSSHPath(b.config.Destination) {
+		args = append(args, b.compressArgs()...)
+		args = append(args, "-e", "ssh "+strings.Join(b.sshArgs(), " "))
 		b.log("SSH transfer detected - added compression and SSH options")
 	}
 
-	// Add progress flag if enabled
-	if b.config.ShowProgress {
+	// Add progress flag if enabled; the transfer quota and the seeding
+	// progress are counted from it
+	if b.config.ShowProgress || b.config.MaxTransferPerRun != "" || b.seeding {
 		args = append(args, "--progress")
 	}
 
-	// Add macOS-specific flags based on rsync version and OS
-	version, err := b.getRsyncVersion()
-	if err == nil {
+	// Add symlink handling if configured
+	if b.config.CopyLinks {
+		args = append(args, "--copy-links")
+	}
+	if b.config.KeepDirlinks {
+		args = append(args, "--keep-dirlinks")
+	}
+
+	// Add macOS-specific flags the rsync binary supports
+	if version, err := b.getRsyncVersion(); err == nil {
+		b.rsyncVersion = version
 		b.log("Detected rsync version: %s", version)
-		if runtime.GOOS == "darwin" && !b.isOldRsync(version) {
-			args = append(args, RsyncMacOSArgs...)
-			b.log("Added macOS-specific flags (modern rsync with full macOS support)")
-		} else if runtime.GOOS == "darwin" {
-			b.log("Warning: Old rsync version - limited macOS support")
+	}
+	if runtime.GOOS == "darwin" {
+		macOSArgs := b.supportedArgs(RsyncMacOSArgs)
+		args = append(args, macOSArgs...)
+		if len(macOSArgs) < len(RsyncMacOSArgs) {
+			b.log("Warning: rsync lacks some macOS options - limited macOS support")
 		}
 	}
 
+	// Add creation times if configured and supported
+	if b.config.PreserveCrtimes {
+		args = append(args, b.crtimesArgs()...)
+	}
+
+	// Tolerate coarse timestamps of the destination filesystem (FAT)
+	args = append(args, b.modifyWindowArgs()...)
+
 	// Add link-dest if previous backup exists
-	if lastBackup != "(none)" {
+	if lastBackup != "(none)" && b.isSSHPath(b.config.Destination) {
+		// The remote latest link was resolved on the remote host, so the
+		// snapshot exists; link-dest takes the path as seen by the receiver
+		_, path := splitSSHPath(b.config.Destination)
+		lastBackupPath := filepath.Join(path, lastBackup)
+		args = append(args, "--link-dest="+lastBackupPath)
+		b.log("Using link-dest: %s", lastBackupPath)
+	} else if lastBackup != "(none)" {
 		lastBackupPath := filepath.Join(b.config.Destination, lastBackup)
 		if _, err := os.Stat(lastBackupPath); err == nil {
 			args = append(args, "--link-dest="+lastBackupPath)
 			b.log("Using link-dest: %s", lastBackupPath)
 		}
+	} else if b.config.SeedRepository != "" {
+		// First snapshot of a new repository
+		if arg, err := b.seedArg(); err == nil {
+			args = append(args, arg)
+		} else {
+			b.log("Warning: not seeding, reading everything from the source: %v", err)
+		}
 	} else {
 		b.log("No previous backup found for hard linking")
 	}
 
+	// Older snapshots find files that disappeared and came back
+	if lastBackup != "(none)" && b.config.LinkDestCount > 1 {
+		args = append(args, b.olderLinkDests(lastBackup)...)
+	}
+
 	// Add exclude file if it exists
 	if _, err := os.Stat(b.config.ExcludeList); err == nil {
 		args = append(args, "--exclude-from="+b.config.ExcludeList)
@@ -473,53 +1263,87 @@ func (b *Backup) runRsync(lastBackup string) error {
 		b.log("Warning: exclude list not found at %s — continuing without excludes", b.config.ExcludeList)
 	}
 
+	// Add excludes detected at run time
+	for _, exclude := range b.autoExcludes {
+		args = append(args, "--exclude="+exclude)
+	}
+
 	// Add dry-run if configured
 	if b.config.DryRun {
 		args = append(args, "--dry-run")
 		b.log("DRY RUN MODE - no changes will be made")
 	}
 
-	// Add source and destination
-	args = append(args, b.config.Source+"/", b.snapDir)
-
-	cmdStr := b.config.RsyncBin + " " + strings.Join(args, " ")
-	b.log("Running rsync: %s", cmdStr)
-	time.Sleep(time.Millisecond * 3000)
+	// Leave out what the destination filesystem cannot store
+	args = b.adaptToDestination(args)
 
-	cmd := exec.Command(b.config.RsyncBin, args...)
+	// Add source and destination
+	args = append(args, b.sourceArgs()...)
+	return append(args, b.snapDir)
+}
 
-	// Use buffers to capture output while displaying it
-	var stdoutBuf, stderrBuf strings.Builder
+// deleteArgs adjusts the deletion options of the base arguments to
+// delete_mode and keep_excluded. A mode the rsync binary does not support
+// falls back to plain --delete.
+func (b *Backup) deleteArgs(base []string) []string {
+	var args []string
+	for _, arg := range base {
+		switch {
+		case strings.HasPrefix(arg, "--delete") && b.seeding:
+			continue // Nothing to delete while the first copy is made
+		case arg == "--delete-excluded" && b.config.KeepExcluded:
+			continue
+		case arg == "--delete" && b.config.DeleteMode != "":
+			option := "delete-" + b.config.DeleteMode
+			b.probeRsync()
+			if len(b.rsyncOptions) > 0 && !b.rsyncOptions[option] {
+				b.log("Warning: %s does not support --%s, using --delete", b.config.RsyncBin, option)
+			} else {
+				arg = "--" + option
+			}
+		}
+		args = append(args, arg)
+	}
+	return args
+}
 
-	stdoutPipe, err := cmd.StdoutPipe()
+// olderLinkDests returns --link-dest options for the newest snapshots other
+// than lastBackup, newest first, up to link_dest_count snapshots in total.
+// rsync uses the first of them that has an identical file.
+func (b *Backup) olderLinkDests(lastBackup string) []string {
+	snapshots, err := b.scanSnapshots()
 	if err != nil {
-		return err
+		b.log("Warning: cannot list snapshots for additional link-dest: %v", err)
+		return nil
 	}
-	stderrPipe, err := cmd.StderrPipe()
-	if err != nil {
-		return err
+	dir := b.config.Destination
+	if b.isSSHPath(dir) {
+		_, dir = splitSSHPath(dir)
 	}
 
-	if err := cmd.Start(); err != nil {
-		return err
+	var args []string
+	for i := len(snapshots) - 1; i >= 0 && len(args) < b.config.LinkDestCount-1; i-- {
+		if snapshots[i] != lastBackup {
+			args = append(args, "--link-dest="+filepath.Join(dir, snapshots[i]))
+		}
 	}
-
-	// Copy output to both console and buffer simultaneously
-	go io.Copy(io.MultiWriter(os.Stdout, &stdoutBuf), stdoutPipe)
-	go io.Copy(io.MultiWriter(os.Stderr, &stderrBuf), stderrPipe)
-
-	if err := cmd.Wait(); err != nil {
-		return err
+	if len(args) > 0 {
+		b.log("Using %d older snapshots as additional link-dest", len(args))
 	}
+	return args
+}
 
-	// Parse transferred data from captured output
-	combinedOutput := stdoutBuf.String() + stderrBuf.String()
-	gb := b.parseTransferredGB(combinedOutput)
-	msg := fmt.Sprintf("Data transferred: %.2f GB", gb)
-	fmt.Println(msg)
-	b.log("%s", msg)
-
-	return nil
+// sourceArgs returns the rsync arguments naming the sources.
+func (b *Backup) sourceArgs() []string {
+	if b.relativeSources() {
+		// Each source keeps its full path inside the snapshot
+		args := []string{"--relative"}
+		for _, source := range b.sourcePaths() {
+			args = append(args, b.snapshotSource(source))
+		}
+		return args
+	}
+	return []string{b.snapshotSource(b.config.Source) + "/"}
 }
 
 func (b *Backup) parseTransferredGB(statsOutput string) float64 {
@@ -551,7 +1375,13 @@ func (b *Backup) finalizeBackup() error {
 
 	// Rename from _INCOMPLETE to final name
 	finalDir := filepath.Join(b.config.Destination, b.timestamp)
-	if err := os.Rename(b.snapDir, finalDir); err != nil {
+	if b.isSSHPath(b.config.Destination) {
+		host, snapPath := splitSSHPath(b.snapDir)
+		_, finalPath := splitSSHPath(finalDir)
+		if _, err := b.runRemote(host, "mv "+shellQuote(snapPath)+" "+shellQuote(finalPath)); err != nil {
+			return fmt.Errorf("failed to rename remote backup directory: %v", err)
+		}
+	} else if err := os.Rename(b.snapDir, finalDir); err != nil {
 		return fmt.Errorf("failed to rename backup directory: %v", err)
 	}
 
@@ -562,11 +1392,10 @@ func (b *Backup) finalizeBackup() error {
 }
 
 func (b *Backup) updateLatestLink() error {
-	// Remove existing link
-	os.Remove(b.latestLink)
-
-	// Create new link
-	return os.Symlink(b.timestamp, b.latestLink)
+	if b.config.DryRun {
+		return nil // No snapshot was created
+	}
+	return b.setLatestLink(b.timestamp)
 }
 
 func (b *Backup) cleanupOldBackups() error {
@@ -574,41 +1403,40 @@ func (b *Backup) cleanupOldBackups() error {
 		return nil
 	}
 
-	entries, err := os.ReadDir(b.config.Destination)
+	// Only snapshot directories count; foreign directories are never pruned
+	backups, err := b.listSnapshots()
 	if err != nil {
 		return err
 	}
 
-	var backups []string
-	for _, entry := range entries {
-		if entry.IsDir() && entry.Name() != "latest" && !strings.HasSuffix(entry.Name(), "_INCOMPLETE") {
-			backups = append(backups, entry.Name())
+	// Thin out snapshots that are closer together than the thinning rules allow
+	backups = b.thinSnapshots(backups)
+
+	// Remove oldest backups
+	if len(backups) > b.config.Keep {
+		toRemove := len(backups) - b.config.Keep
+		for i := 0; i < toRemove; i++ {
+			b.removeSnapshot(backups[i], fmt.Sprintf("keep %d", b.config.Keep))
 		}
+		backups = backups[toRemove:]
 	}
 
-	if len(backups) <= b.config.Keep {
-		return nil
+	// Remove more if the repository is still larger than its quota
+	if err := b.enforceRepositoryQuota(backups); err != nil {
+		return err
 	}
 
-	// Sort backups (oldest first)
-	// Simple string sort works for timestamp format
-	for i := 0; i < len(backups)-1; i++ {
-		for j := i + 1; j < len(backups); j++ {
-			if backups[i] > backups[j] {
-				backups[i], backups[j] = backups[j], backups[i]
-			}
-		}
-	}
+	// Remove more if the destination is still too full
+	return b.ensureFreeSpace()
+}
 
-	// Remove oldest backups
-	toRemove := len(backups) - b.config.Keep
-	for i := 0; i < toRemove; i++ {
-		backupPath := filepath.Join(b.config.Destination, backups[i])
-		b.log("Removing old backup: %s", backups[i])
-		if err := os.RemoveAll(backupPath); err != nil {
-			b.log("Warning: failed to remove %s: %v", backupPath, err)
-		}
+func (b *Backup) removeSnapshot(name, reason string) {
+	b.pruned++
+	if b.config.DryRun {
+		b.log("Would remove old backup: %s (%s)", name, reason)
+		return
 	}
-
-	return nil
+	b.log("Removing old backup: %s", name)
+	b.trashSnapshot(name)
+	b.audit("prune", name, reason)
 }
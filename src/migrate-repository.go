@@ -0,0 +1,155 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// runMigrateRepository copies the whole repository to another disk, e.g.
+// to replace an aging backup drive. Files hard-linked between snapshots
+// stay hard-linked, so the copy takes no more space than the original.
+func (b *Backup) runMigrateRepository(args []string) error {
+	fs := flag.NewFlagSet("migrate-repository", flag.ExitOnError)
+	target := fs.String("to", "", "Directory of the new repository (e.g. on the new disk)")
+	hardLinks := fs.Bool("hard-links", false, "Copy everything in a single rsync -H run, keeping all hard links (needs memory for every file)")
+	yes := fs.Bool("yes", false, "Do not ask for confirmation")
+	parseArgs(fs, args)
+
+	if *target == "" {
+		return fmt.Errorf("missing --to <directory>")
+	}
+	if b.isSSHPath(b.config.Destination) || b.isSSHPath(*target) {
+		return fmt.Errorf("migrate-repository requires a local repository and target")
+	}
+	if err := os.MkdirAll(*target, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", *target, err)
+	}
+	src := resolvePath(b.config.Destination)
+	dst := resolvePath(*target)
+	if isWithin(dst, src) || isWithin(src, dst) {
+		return fmt.Errorf("%s overlaps the repository %s", *target, b.config.Destination)
+	}
+
+	// Snapshots of all jobs sharing the repository, oldest first
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return fmt.Errorf("failed to read repository: %v", err)
+	}
+	var snapshots, others []string
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case entry.IsDir() && isSnapshotName(name):
+			snapshots = append(snapshots, name)
+		case name == TrashDir || strings.HasSuffix(name, "_INCOMPLETE"):
+			// Pruned or aborted, not worth copying
+		default:
+			others = append(others, name)
+		}
+	}
+	sortSnapshots(snapshots)
+	if len(snapshots) == 0 {
+		return fmt.Errorf("no snapshots found in %s", src)
+	}
+
+	if !*yes && !confirm(fmt.Sprintf("Copy %d snapshots from %s to %s?", len(snapshots), src, dst)) {
+		return fmt.Errorf("aborted by user")
+	}
+	if err := b.findRsync(); err != nil {
+		return fmt.Errorf("failed to find rsync: %v", err)
+	}
+
+	if *hardLinks {
+		args := b.migrateRsyncArgs()
+		args = append(args, "--exclude=/"+TrashDir, "--exclude=/*_INCOMPLETE", src+"/", dst)
+		if err := b.runMigrateRsync(args); err != nil {
+			return err
+		}
+	} else {
+		if err := b.migrateSnapshots(src, dst, snapshots); err != nil {
+			return err
+		}
+		// Latest links, quarantine, clones, partial snapshots. Hard links
+		// between these and the snapshots are not kept.
+		if len(others) > 0 {
+			fmt.Printf("Copying %s\n", strings.Join(others, ", "))
+			args := b.migrateRsyncArgs()
+			for _, name := range others {
+				args = append(args, filepath.Join(src, name))
+			}
+			if err := b.runMigrateRsync(append(args, dst+"/")); err != nil {
+				return err
+			}
+		}
+	}
+
+	b.log("Repository %s migrated to %s (%d snapshots)", src, dst, len(snapshots))
+	fmt.Printf("\nSet \"destination\" in the configuration to %s to use the new repository.\n", dst)
+	return nil
+}
+
+// migrateSnapshots copies the snapshots one by one, oldest first, each
+// hard-linked against the copy of the one before. This keeps the links
+// between consecutive snapshots, which is how backups share files, while
+// rsync only has to track the hard links within one snapshot at a time.
+// Snapshots already copied by an interrupted migration are skipped.
+func (b *Backup) migrateSnapshots(src, dst string, snapshots []string) error {
+	previous := ""
+	for i, name := range snapshots {
+		final := filepath.Join(dst, name)
+		if _, err := os.Stat(final); err == nil {
+			fmt.Printf("[%d/%d] %s already copied\n", i+1, len(snapshots), name)
+			previous = final
+			continue
+		}
+
+		fmt.Printf("[%d/%d] Copying %s\n", i+1, len(snapshots), name)
+		incomplete := final + "_INCOMPLETE"
+		args := b.migrateRsyncArgs()
+		if previous != "" {
+			args = append(args, "--link-dest="+previous)
+		}
+		args = append(args, filepath.Join(src, name)+"/", incomplete)
+		if err := b.runMigrateRsync(args); err != nil {
+			return fmt.Errorf("failed to copy %s: %v", name, err)
+		}
+		if err := os.Rename(incomplete, final); err != nil {
+			return err
+		}
+		previous = final
+	}
+	return nil
+}
+
+// migrateRsyncArgs returns the rsync arguments for copying repository data:
+// the backup preservation flags without deletion or per-file output.
+func (b *Backup) migrateRsyncArgs() []string {
+	var args []string
+	for _, arg := range b.supportedArgs(RsyncBaseArgs) {
+		if !strings.HasPrefix(arg, "--delete") && arg != "--itemize-changes" && arg != "--stats" {
+			args = append(args, arg)
+		}
+	}
+	if b.config.PreserveCrtimes {
+		args = append(args, b.crtimesArgs()...)
+	}
+	if runtime.GOOS == "darwin" {
+		args = append(args, b.supportedArgs(RsyncMacOSArgs)...)
+	}
+	return args
+}
+
+// runMigrateRsync runs rsync to copy repository data, with its errors shown.
+func (b *Backup) runMigrateRsync(args []string) error {
+	b.log("Running rsync: %s %s", b.config.RsyncBin, strings.Join(args, " "))
+	cmd := b.privileged(b.config.RsyncBin, args...)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("rsync failed: %v", err)
+	}
+	return nil
+}
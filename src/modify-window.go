@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// mtimeResolution measures how precisely the filesystem holding dir stores
+// modification times, by giving a probe file an odd, fractional mtime and
+// reading it back (e.g. 1ns on APFS and ext4, 2s on FAT).
+func mtimeResolution(dir string) (time.Duration, error) {
+	f, err := os.CreateTemp(dir, ".mtime-probe-*")
+	if err != nil {
+		return 0, err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	want := time.Unix(1700000001, 123456789)
+	if err := os.Chtimes(f.Name(), want, want); err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(f.Name())
+	if err != nil {
+		return 0, err
+	}
+	got := info.ModTime()
+
+	for _, r := range []time.Duration{time.Nanosecond, time.Microsecond, time.Millisecond, 10 * time.Millisecond, time.Second, 2 * time.Second} {
+		if got.Equal(want.Truncate(r)) || got.Equal(want.Round(r)) {
+			return r, nil
+		}
+	}
+	return want.Sub(got).Abs().Round(time.Second) + time.Second, nil
+}
+
+// modifyWindowArgs returns --modify-window if the destination stores
+// modification times more coarsely than rsync compares them (whole
+// seconds). Without it every file with an odd mtime would differ from its
+// copy in the previous snapshot on a FAT disk and be transferred again.
+// Remote destinations are not probed.
+func (b *Backup) modifyWindowArgs() []string {
+	if b.isSSHPath(b.config.Destination) {
+		return nil
+	}
+	resolution, err := mtimeResolution(b.config.Destination)
+	if err != nil || resolution <= time.Second {
+		return nil
+	}
+	window := int((resolution - 1) / time.Second)
+	b.log("Destination stores modification times with %s resolution - added --modify-window=%d", resolution, window)
+	return []string{fmt.Sprintf("--modify-window=%d", window)}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// runCommandOutput runs a command and includes its output in the error.
+func runCommandOutput(name string, args ...string) error {
+	if output, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %v: %s", name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// mountReadOnly makes dir a read-only view of src: a read-only bind mount
+// on Linux, a nullfs mount on FreeBSD and a bindfs (FUSE) mount elsewhere,
+// e.g. on macOS with macFUSE.
+func mountReadOnly(src, dir string) error {
+	switch {
+	case runtime.GOOS == "linux":
+		if err := runCommandOutput("mount", "--bind", src, dir); err != nil {
+			return err
+		}
+		// The bind itself ignores "ro" on older kernels, the remount does not
+		if err := runCommandOutput("mount", "-o", "remount,bind,ro", dir); err != nil {
+			exec.Command("umount", dir).Run()
+			return err
+		}
+		return nil
+	case runtime.GOOS == "freebsd":
+		return runCommandOutput("mount_nullfs", "-o", "ro", src, dir)
+	case commandExists("bindfs"):
+		return runCommandOutput("bindfs", "-r", src, dir)
+	}
+	return fmt.Errorf("not supported on %s (install bindfs, e.g. with macFUSE)", runtime.GOOS)
+}
+
+// unmountSnapshot removes a mount created by mountReadOnly.
+func unmountSnapshot(dir string) error {
+	return runCommandOutput("umount", dir)
+}
+
+// runMountSnapshot mounts a snapshot read-only at another directory, so
+// tools such as virus scanners or duplicate finders can inspect it without
+// any risk of modifying it (the repository itself stays writable for
+// backups).
+func (b *Backup) runMountSnapshot(args []string) error {
+	fs := flag.NewFlagSet("mount-snapshot", flag.ExitOnError)
+	readOnly := fs.Bool("read-only", true, "Mount read-only (the only mode supported; see clone-snapshot for a writable copy)")
+	unmount := fs.Bool("unmount", false, "Unmount a directory mounted by mount-snapshot")
+	positional := parseArgs(fs, args)
+
+	if *unmount {
+		if len(positional) != 1 {
+			return fmt.Errorf("usage: mount-snapshot --unmount <dir>")
+		}
+		if err := unmountSnapshot(positional[0]); err != nil {
+			return fmt.Errorf("unmount failed: %v", err)
+		}
+		fmt.Printf("Unmounted %s\n", positional[0])
+		return nil
+	}
+
+	if len(positional) != 2 {
+		return fmt.Errorf("usage: mount-snapshot <snapshot|latest> <dir> --read-only")
+	}
+	if !*readOnly {
+		return fmt.Errorf("snapshots can only be mounted read-only (use clone-snapshot for a writable copy)")
+	}
+	snapshot, err := b.resolveSnapshot(positional[0])
+	if err != nil {
+		return err
+	}
+
+	dir := positional[1]
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+	if entries, err := os.ReadDir(dir); err != nil {
+		return err
+	} else if len(entries) > 0 {
+		return fmt.Errorf("%s is not empty", dir)
+	}
+
+	if err := mountReadOnly(snapshot, dir); err != nil {
+		return fmt.Errorf("mount failed: %v", err)
+	}
+
+	// Never leave a writable view of a snapshot behind
+	probe := filepath.Join(dir, ".go-rsync-backup-write-test")
+	if f, err := os.Create(probe); err == nil {
+		f.Close()
+		os.Remove(probe)
+		unmountSnapshot(dir)
+		return fmt.Errorf("%s is writable after mounting, unmounted again", dir)
+	}
+
+	fmt.Printf("Mounted %s read-only at %s\n", filepath.Base(snapshot), dir)
+	fmt.Printf("Unmount with: backup mount-snapshot --unmount %s\n", dir)
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// offsiteStage returns a backup that replicates the snapshot just created
+// into offsite_destination. Its source is the local snapshot, so the live
+// system is read only once, and the replica keeps the snapshot's name and
+// metadata. Checks that only make sense against the live source are dropped.
+func (b *Backup) offsiteStage() *Backup {
+	config := b.config
+	config.Source = b.snapDir
+	config.Sources = nil
+	config.Destination = b.config.OffsiteDestination
+	config.PerHostLayout = false // Already applied to the local snapshot path
+	config.RepositoryOwner = ""
+	config.SeedRepository = ""
+	config.SeedingMode = false
+	config.ExcludeList = ""
+	config.MinSourceFiles = 0
+	config.CanaryFile = ""
+	config.Assertions = nil
+	config.VerifySampleFiles = 0
+	config.VerifyChangedFiles = false
+	config.MinFreeSpace = "" // Only enforced for the local repository
+
+	offsite := NewBackup(config)
+	offsite.timestamp = b.timestamp
+	offsite.snapDir = filepath.Join(config.Destination, b.timestamp+"_INCOMPLETE")
+	offsite.started = b.started
+	offsite.logFile = b.logFile
+	offsite.combinedLog = b.combinedLog
+	offsite.runLog = b.runLog
+	return offsite
+}
+
+// replicateOffsite runs the offsite stage after a successful local backup.
+// It runs under the lock of the local backup and writes to the same log.
+func (b *Backup) replicateOffsite() error {
+	if b.config.DryRun {
+		b.log("Offsite: skipped in dry run (no local snapshot to replicate)")
+		return nil
+	}
+
+	o := b.offsiteStage()
+	o.log("Offsite: replicating %s to %s", b.timestamp, o.config.Destination)
+
+	if err := o.validatePaths(); err != nil {
+		return fmt.Errorf("path validation failed: %v", err)
+	}
+
+	lastBackup := o.getLastBackup()
+	o.log("Offsite: last backup: %s", lastBackup)
+
+	if err := o.runRsync(lastBackup); err != nil {
+		o.quarantineSnapshot(fmt.Sprintf("offsite rsync failed: %v", err))
+		return fmt.Errorf("rsync failed: %v", err)
+	}
+	if err := o.verifyBackup(); err != nil {
+		o.quarantineSnapshot(fmt.Sprintf("offsite verification failed: %v", err))
+		return fmt.Errorf("verification failed: %v", err)
+	}
+	if err := o.finalizeBackup(); err != nil {
+		return fmt.Errorf("failed to finalize: %v", err)
+	}
+	if err := o.updateLatestLink(); err != nil {
+		return fmt.Errorf("failed to update latest link: %v", err)
+	}
+	if err := o.withRepositoryLock("Offsite pruning", o.cleanupOldBackups); err != nil {
+		o.log("Warning: offsite cleanup failed: %v", err)
+	}
+
+	o.log("Offsite: replicated %s", b.timestamp)
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// skipIfRecent reports whether the run can be skipped because the newest
+// snapshot is younger than min_interval. Triggers that fire often (disk
+// attach, wake from sleep, frequent timers) thereby produce at most one
+// snapshot per interval.
+func (b *Backup) skipIfRecent() bool {
+	if b.config.MinInterval == "" {
+		return false
+	}
+	interval, err := ParseDuration(b.config.MinInterval)
+	if err != nil {
+		return false
+	}
+	name, age, err := b.newestSnapshotAge()
+	if err != nil || age >= interval {
+		return false
+	}
+	b.log("Skipping backup: newest snapshot %s is only %s old (min_interval: %s)", name, age.Round(time.Minute), b.config.MinInterval)
+	return true
+}
+
+// runOnMount is meant to be started by the system whenever a volume is
+// mounted (launchd StartOnMount, a udev rule or a systemd mount unit). It
+// runs the backup only if the destination is present, i.e. the backup disk
+// is attached, and the min_interval guard allows it. It never creates the
+// destination, so a backup cannot end up on the internal disk.
+func (b *Backup) runOnMount(args []string) error {
+	if b.isSSHPath(b.config.Destination) {
+		return fmt.Errorf("on-mount requires a local destination")
+	}
+	if info, err := os.Stat(b.config.Destination); err != nil || !info.IsDir() {
+		fmt.Printf("Destination %s is not attached, nothing to do\n", b.config.Destination)
+		return nil
+	}
+	return b.Run()
+}
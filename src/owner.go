@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// repositoryOwner is the account a job's part of a shared repository
+// belongs to, from repository_owner ("user" or "user:group").
+type repositoryOwner struct {
+	uid   int
+	gid   int    // Owner's primary group, or the given group
+	group string // Group given in the option, "" if none
+}
+
+// ownerName returns the user name of a repository_owner value, which names
+// the job's subtree of the destination.
+func ownerName(spec string) string {
+	name, _, _ := strings.Cut(spec, ":")
+	return name
+}
+
+// lookupOwner resolves repository_owner to ids on this machine.
+func lookupOwner(spec string) (*repositoryOwner, error) {
+	name, group, _ := strings.Cut(spec, ":")
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("repository_owner: %v", err)
+	}
+	owner := &repositoryOwner{}
+	owner.uid, _ = strconv.Atoi(u.Uid)
+	owner.gid, _ = strconv.Atoi(u.Gid)
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return nil, fmt.Errorf("repository_owner: %v", err)
+		}
+		owner.gid, _ = strconv.Atoi(g.Gid)
+		owner.group = group
+	}
+	return owner, nil
+}
+
+// ownerMode is the mode of the subtree: only the owner may enter it, and
+// the group if one was given (e.g. for admins restoring on their behalf).
+func ownerMode(spec string) os.FileMode {
+	if strings.Contains(spec, ":") {
+		return 0750
+	}
+	return 0700
+}
+
+// secureOwnerTree gives the job's subtree of a shared destination to
+// repository_owner and makes it inaccessible to other users. Snapshots keep
+// the ownership of the source files, so the subtree's mode is what keeps
+// other tenants out.
+func (b *Backup) secureOwnerTree() error {
+	spec := b.config.RepositoryOwner
+	if spec == "" || b.config.DryRun {
+		return nil
+	}
+	mode := ownerMode(spec)
+
+	if b.isSSHPath(b.config.Destination) {
+		host, path := splitSSHPath(b.config.Destination)
+		cmd := fmt.Sprintf("chown %s %s && chmod %o %s", shellQuote(spec), shellQuote(path), mode, shellQuote(path))
+		if output, err := b.runRemote(host, cmd); err != nil {
+			return fmt.Errorf("failed to secure %s: %v: %s", b.config.Destination, err, strings.TrimSpace(output))
+		}
+		return nil
+	}
+
+	owner, err := lookupOwner(spec)
+	if err != nil {
+		return err
+	}
+	if err := os.Chown(b.config.Destination, owner.uid, owner.gid); err != nil {
+		return fmt.Errorf("failed to give %s to %s: %v", b.config.Destination, spec, err)
+	}
+	return os.Chmod(b.config.Destination, mode)
+}
+
+// invokingUser returns the uid of the user running the command; for sudo
+// the user who called sudo.
+func invokingUser() int {
+	if uid := os.Getuid(); uid != 0 {
+		return uid
+	}
+	if uid, err := strconv.Atoi(os.Getenv("SUDO_UID")); err == nil {
+		return uid
+	}
+	return 0
+}
+
+// checkRepositoryAccess refuses to show or restore snapshots of a job with
+// repository_owner to anyone but the owner, members of the given group and
+// root. It matters where the tool is allowed to run with more rights than
+// the user has (sudo rules for restores on a shared backup server).
+func (b *Backup) checkRepositoryAccess() error {
+	if b.config.RepositoryOwner == "" {
+		return nil
+	}
+	uid := invokingUser()
+	if uid == 0 {
+		return nil
+	}
+	owner, err := lookupOwner(b.config.RepositoryOwner)
+	if err != nil {
+		return err
+	}
+	if uid == owner.uid {
+		return nil
+	}
+	if owner.group != "" {
+		if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+			if groups, err := u.GroupIds(); err == nil && slices.Contains(groups, strconv.Itoa(owner.gid)) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("the snapshots in %s belong to %s", b.config.Destination, b.config.RepositoryOwner)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// countTransferred returns the number of files rsync itemized as sent.
+func countTransferred(changes []itemizedChange) int {
+	files := 0
+	for _, c := range changes {
+		if c.transferred() {
+			files++
+		}
+	}
+	return files
+}
+
+// recordPartialProgress records how far a failed rsync run got: from its
+// statistics if it still printed them, otherwise from the files it itemized
+// as sent, whose size is read from the unfinished snapshot (local
+// destinations only).
+func (b *Backup) recordPartialProgress(stdout, stderr string) {
+	b.changes = parseItemized(stdout)
+	b.sentFiles = countTransferred(b.changes)
+
+	if strings.Contains(stdout, "Total transferred file size") {
+		b.transferredGB = b.parseTransferredGB(stdout + stderr)
+	} else if !b.isSSHPath(b.config.Destination) {
+		var written int64
+		for _, c := range b.changes {
+			if !c.transferred() {
+				continue
+			}
+			if info, err := os.Lstat(filepath.Join(b.snapDir, c.Path)); err == nil {
+				written += info.Size()
+			}
+		}
+		b.transferredGB = gib(written)
+	}
+	b.log("rsync stopped after transferring %d files (%.2f GB)", b.sentFiles, b.transferredGB)
+}
+
+// writeIncompleteMeta records in a snapshot rsync did not finish how far
+// the transfer got and why it stopped, so it can be judged whether resuming
+// is worthwhile. The metadata moves with the snapshot into quarantine or
+// the partial snapshot.
+func (b *Backup) writeIncompleteMeta(rsyncErr error) {
+	if b.config.DryRun {
+		return
+	}
+	if !b.isSSHPath(b.config.Destination) {
+		if _, err := os.Stat(b.snapDir); err != nil {
+			return // rsync never created the snapshot
+		}
+	}
+	meta := b.snapshotMeta()
+	meta.Error = rsyncErr.Error()
+	if err := b.saveSnapshotMeta(b.snapDir, meta); err != nil {
+		b.log("Warning: failed to write metadata of the incomplete snapshot: %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// Socket option returning the credentials of the peer (sys/un.h)
+const (
+	solLocal      = 0
+	localPeerCred = 0x001
+)
+
+// xucred is struct xucred of sys/ucred.h
+type xucred struct {
+	Version uint32
+	UID     uint32
+	NGroups int16
+	Groups  [16]uint32
+}
+
+// peerUID returns the user ID of the process on the other end of a Unix
+// socket connection (LOCAL_PEERCRED).
+func peerUID(conn *net.UnixConn) (int, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	var cred xucred
+	var errno syscall.Errno
+	if err := raw.Control(func(fd uintptr) {
+		size := uint32(unsafe.Sizeof(cred))
+		_, _, errno = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, solLocal, localPeerCred,
+			uintptr(unsafe.Pointer(&cred)), uintptr(unsafe.Pointer(&size)), 0)
+	}); err != nil {
+		return -1, err
+	}
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(cred.UID), nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var placeholderRe = regexp.MustCompile(`\{[a-z]+\}`)
+
+// expandPlaceholders replaces {hostname} (short hostname), {job} (name of
+// the job, or else of the configuration file or directory without
+// extension) and {date} (YYYY-MM-DD) in the path settings, so one
+// configuration can be shared by several machines or jobs, e.g.
+// "destination": "/mnt/backups/{hostname}".
+func expandPlaceholders(config *Config, filename string) error {
+	job := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	if config.Name != "" {
+		job = config.Name
+	}
+	values := map[string]string{
+		"{hostname}": shortHostname(),
+		"{job}":      job,
+		"{date}":     time.Now().Format("2006-01-02"),
+	}
+
+	var unknown error
+	expand := func(s string) string {
+		return placeholderRe.ReplaceAllStringFunc(s, func(p string) string {
+			if value, ok := values[p]; ok {
+				return value
+			}
+			unknown = fmt.Errorf("unknown placeholder %s in %q (use {hostname}, {job} or {date})", p, s)
+			return p
+		})
+	}
+
+	config.Source = expand(config.Source)
+	for i := range config.Sources {
+		config.Sources[i] = expand(config.Sources[i])
+	}
+	config.Destination = expand(config.Destination)
+	config.OffsiteDestination = expand(config.OffsiteDestination)
+	config.SeedRepository = expand(config.SeedRepository)
+	config.ExcludeList = expand(config.ExcludeList)
+	config.LogFile = expand(config.LogFile)
+	config.CombinedLogFile = expand(config.CombinedLogFile)
+	config.HistoryFile = expand(config.HistoryFile)
+	config.AuditLog = expand(config.AuditLog)
+	config.APISocket = expand(config.APISocket)
+	config.ChangeCache = expand(config.ChangeCache)
+	config.SigningKey = expand(config.SigningKey)
+	config.SigningPublicKey = expand(config.SigningPublicKey)
+	config.RsyncOutputDir = expand(config.RsyncOutputDir)
+	config.LockFile = expand(config.LockFile)
+	config.CanaryFile = expand(config.CanaryFile)
+	return unknown
+}
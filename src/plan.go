@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// runPlan prints what a backup run with the current configuration would do:
+// the resolved configuration, the rsync binary and its full argument list,
+// the link-dest snapshot and the retention actions. Nothing is transferred,
+// created or removed.
+func (b *Backup) runPlan(args []string) error {
+	if err := b.validateConfig(); err != nil {
+		return fmt.Errorf("config validation failed: %v", err)
+	}
+	if err := b.expandSources(); err != nil {
+		return fmt.Errorf("source expansion failed: %v", err)
+	}
+	if err := b.findRsync(); err != nil {
+		return fmt.Errorf("failed to find rsync: %v", err)
+	}
+
+	// Overlap detection may add excludes or refuse the run
+	var overlapErr error
+	if !b.isSSHPath(b.config.Destination) {
+		overlapErr = b.checkOverlap()
+	}
+	b.excludeBackupStores()
+
+	lastBackup := b.getLastBackup()
+	rsyncArgs := b.buildRsyncArgs(lastBackup)
+
+	configFile := toConfigFile(b.config)
+	configFile.Env = maskedEnv(configFile.Env)
+	config, err := json.MarshalIndent(configFile, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("\n== Configuration ==")
+	fmt.Println(string(config))
+
+	fmt.Println("\n== Pre-flight ==")
+	if len(b.config.Assertions) == 0 {
+		fmt.Println("No assertions configured")
+	}
+	for _, a := range b.config.Assertions {
+		fmt.Printf("Assert %s\n", a)
+	}
+	if overlapErr != nil {
+		fmt.Printf("The run would be refused: %v\n", overlapErr)
+	}
+
+	fmt.Println("\n== Transfer ==")
+	fmt.Printf("Sources:     %s\n", strings.Join(b.sourcePaths(), ", "))
+	fmt.Printf("Snapshot:    %s\n", b.snapDir)
+	fmt.Printf("Link-dest:   %s\n", lastBackup)
+	fmt.Printf("Rsync:       %s (version %s)\n", b.config.RsyncBin, b.rsyncVersion)
+	fmt.Printf("Command:     %s\n", strings.Join(b.privilegedArgs(b.config.RsyncBin, rsyncArgs...), " "))
+	if b.config.DryRun {
+		fmt.Println("Dry run:     rsync runs with --dry-run, no snapshot is kept")
+	}
+
+	fmt.Println("\n== Retention ==")
+	b.printRetentionPlan()
+	return nil
+}
+
+// printRetentionPlan lists the snapshots the thinning and keep rules would
+// remove after the run. Size-based pruning depends on the outcome of the
+// transfer and is only described.
+func (b *Backup) printRetentionPlan() {
+	if b.config.Keep <= 0 {
+		fmt.Println("Retention disabled (keep <= 0)")
+		return
+	}
+	if b.isSSHPath(b.config.Destination) {
+		fmt.Printf("Keep %d snapshots (not evaluated for remote destinations)\n", b.config.Keep)
+		return
+	}
+
+	snapshots, err := b.listSnapshots()
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Cannot list snapshots: %v\n", err)
+		return
+	}
+	if !b.config.DryRun {
+		snapshots = append(snapshots, b.timestamp) // The snapshot of this run
+	}
+
+	thinned := b.thinningCandidates(snapshots)
+	var remaining []string
+	for _, name := range snapshots {
+		if thinned[name] {
+			fmt.Printf("Remove %s (thinning)\n", name)
+		} else {
+			remaining = append(remaining, name)
+		}
+	}
+	if len(remaining) > b.config.Keep {
+		for _, name := range remaining[:len(remaining)-b.config.Keep] {
+			fmt.Printf("Remove %s (keep %d)\n", name, b.config.Keep)
+		}
+		remaining = remaining[len(remaining)-b.config.Keep:]
+	}
+	fmt.Printf("%d snapshots would remain\n", len(remaining))
+
+	if b.config.MaxRepositorySize != "" {
+		fmt.Printf("Further oldest snapshots are removed while the repository exceeds %s\n", b.config.MaxRepositorySize)
+	}
+	if b.config.MinFreeSpace != "" {
+		fmt.Printf("Further oldest snapshots are removed while less than %s is free\n", b.config.MinFreeSpace)
+	}
+	if b.config.TrashRetention != "" {
+		fmt.Printf("Removed snapshots are kept in %s for %s\n", TrashDir, b.config.TrashRetention)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// privilegedArgs returns the command line running name with args through
+// privileged_command (e.g. "sudo -n"), so the process itself can run as an
+// unprivileged service account and only rsync and the deletion of pruned
+// snapshots get root rights.
+func (b *Backup) privilegedArgs(name string, args ...string) []string {
+	argv := strings.Fields(b.config.PrivilegedCommand)
+	argv = append(argv, name)
+	return append(argv, args...)
+}
+
+// privileged returns the command running name with args through
+// privileged_command, if configured.
+func (b *Backup) privileged(name string, args ...string) *exec.Cmd {
+	argv := b.privilegedArgs(name, args...)
+	return exec.Command(argv[0], argv[1:]...)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Number of chronic problem files listed in the log of each run
+const MaxLoggedProblemFiles = 10
+
+// problemFile is a source file rsync failed to read, tracked across runs.
+type problemFile struct {
+	Error     string    `json:"error"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Runs      int       `json:"runs"` // Consecutive runs that failed on this file
+}
+
+// Matches sender-side errors naming a file, e.g.
+// rsync: [sender] send_files failed to open "/home/a/x": Permission denied (13)
+// rsync: [sender] read errors mapping "/home/a/y": Input/output error (5)
+// rsync: opendir "/home/a/private" failed: Permission denied (13)
+var rsyncFileErrorRe = regexp.MustCompile(`^rsync:(?: \[sender\])? ([^\[].*?)"(.+)"(?: failed)?: (.+)$`)
+
+// parseProblemFiles extracts the files rsync could not read from its error
+// output. Files that vanished during the transfer are not problems.
+func parseProblemFiles(stderr string) map[string]string {
+	failed := make(map[string]string)
+	for _, line := range strings.Split(stderr, "\n") {
+		m := rsyncFileErrorRe.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil || strings.Contains(line, "vanished") {
+			continue
+		}
+		failed[m[2]] = strings.TrimSpace(m[1]) + ": " + m[3]
+	}
+	return failed
+}
+
+// problemFilesPath returns the file the problem files are tracked in. It is
+// kept next to the log file, which is always local.
+func (b *Backup) problemFilesPath() string {
+	return strings.TrimSuffix(b.config.LogFile, filepath.Ext(b.config.LogFile)) + ".problems.json"
+}
+
+// loadProblemFiles reads the tracked problem files; a missing file means
+// there are none.
+func (b *Backup) loadProblemFiles() (map[string]problemFile, error) {
+	problems := make(map[string]problemFile)
+	data, err := os.ReadFile(b.problemFilesPath())
+	if os.IsNotExist(err) {
+		return problems, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &problems); err != nil {
+		return nil, fmt.Errorf("%s is corrupt: %v", b.problemFilesPath(), err)
+	}
+	return problems, nil
+}
+
+// trackProblemFiles updates the problem files with the result of this run's
+// transfer: files that failed again keep their first-seen date, files that
+// were read successfully are dropped. Only complete transfers (success or
+// rsync's partial-transfer exit code 23) are taken into account.
+func (b *Backup) trackProblemFiles(stderr string, rsyncErr error) {
+	if b.config.DryRun || b.quotaReached || b.timedOut {
+		return
+	}
+	if exitErr, ok := rsyncErr.(*exec.ExitError); rsyncErr != nil && (!ok || exitErr.ExitCode() != 23) {
+		return
+	}
+
+	previous, err := b.loadProblemFiles()
+	if err != nil {
+		b.log("Warning: %v", err)
+		previous = make(map[string]problemFile)
+	}
+	now := time.Now()
+	problems := make(map[string]problemFile)
+	for path, message := range parseProblemFiles(stderr) {
+		p, ok := previous[path]
+		if !ok {
+			p.FirstSeen = now
+		}
+		p.Error = message
+		p.LastSeen = now
+		p.Runs++
+		problems[path] = p
+	}
+
+	data, err := json.MarshalIndent(problems, "", "  ")
+	if err == nil {
+		err = os.WriteFile(b.problemFilesPath(), data, 0644)
+	}
+	if err != nil {
+		b.log("Warning: failed to save problem files: %v", err)
+	}
+	if len(problems) == 0 {
+		return
+	}
+
+	// Report files failing in more than one run, longest first
+	paths := sortedProblemFiles(problems)
+	chronic := 0
+	for _, path := range paths {
+		p := problems[path]
+		if p.Runs < 2 {
+			continue
+		}
+		if chronic++; chronic <= MaxLoggedProblemFiles {
+			b.log("Warning: %s not backed up since %s (%d runs): %s", path, p.FirstSeen.Format("2006-01-02"), p.Runs, p.Error)
+		}
+	}
+	b.log("Problem files: %d could not be read, %d of them in earlier runs too (see problems)", len(problems), chronic)
+}
+
+// sortedProblemFiles returns the paths ordered by first-seen date.
+func sortedProblemFiles(problems map[string]problemFile) []string {
+	paths := make([]string, 0, len(problems))
+	for path := range problems {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		a, b := problems[paths[i]], problems[paths[j]]
+		if !a.FirstSeen.Equal(b.FirstSeen) {
+			return a.FirstSeen.Before(b.FirstSeen)
+		}
+		return paths[i] < paths[j]
+	})
+	return paths
+}
+
+// runProblems lists the files the last runs could not read.
+func (b *Backup) runProblems(args []string) error {
+	fs := flag.NewFlagSet("problems", flag.ExitOnError)
+	parseArgs(fs, args)
+
+	problems, err := b.loadProblemFiles()
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		fmt.Println("No problem files: the last run read every source file")
+		return nil
+	}
+
+	fmt.Printf("%-10s  %-10s  %4s  %s\n", "FIRST SEEN", "LAST SEEN", "RUNS", "PATH")
+	for _, path := range sortedProblemFiles(problems) {
+		p := problems[path]
+		fmt.Printf("%-10s  %-10s  %4d  %s\n", p.FirstSeen.Format("2006-01-02"), p.LastSeen.Format("2006-01-02"), p.Runs, path)
+		fmt.Printf("%28s%s\n", "", p.Error)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Directory in the repository that holds failed or suspicious snapshots.
+// Quarantined snapshots are not counted or pruned by retention.
+const QuarantineDir = ".quarantine"
+
+// quarantineSnapshot moves the snapshot of the current run into the
+// quarantine area and stores the reason next to it.
+func (b *Backup) quarantineSnapshot(reason string) {
+	if b.config.DryRun {
+		return // Nothing was written for dry runs
+	}
+
+	quarantine := filepath.Join(b.config.Destination, QuarantineDir)
+	target := filepath.Join(quarantine, b.timestamp)
+	text := fmt.Sprintf("%s %s\n", time.Now().Format("2006-01-02 15:04:05"), reason)
+
+	if b.isSSHPath(b.config.Destination) {
+		host, snapPath := splitSSHPath(b.snapDir)
+		_, quarantinePath := splitSSHPath(quarantine)
+		_, targetPath := splitSSHPath(target)
+		cmd := fmt.Sprintf("test -d %[1]s || exit 0; mkdir -p %[2]s && mv %[1]s %[3]s && printf '%%s' %[4]s > %[5]s",
+			shellQuote(snapPath), shellQuote(quarantinePath), shellQuote(targetPath), shellQuote(text), shellQuote(targetPath+".reason"))
+		if _, err := b.runRemote(host, cmd); err != nil {
+			b.log("Warning: failed to quarantine snapshot: %v", err)
+			return
+		}
+		b.log("Snapshot quarantined: %s (%s)", target, reason)
+		b.audit("quarantine", b.timestamp, reason)
+		return
+	}
+
+	if _, err := os.Stat(b.snapDir); err != nil {
+		return // rsync never created the snapshot
+	}
+	if err := os.MkdirAll(quarantine, 0755); err != nil {
+		b.log("Warning: failed to create quarantine directory: %v", err)
+		return
+	}
+	if err := os.Rename(b.snapDir, target); err != nil {
+		b.log("Warning: failed to quarantine snapshot: %v", err)
+		return
+	}
+	if err := os.WriteFile(target+".reason", []byte(text), 0644); err != nil {
+		b.log("Warning: failed to write quarantine reason: %v", err)
+	}
+	b.log("Snapshot quarantined: %s (%s)", target, reason)
+	b.audit("quarantine", b.timestamp, reason)
+}
+
+// logQuarantined reports snapshots waiting in the local quarantine area so
+// they are not forgotten.
+func (b *Backup) logQuarantined() {
+	entries, err := os.ReadDir(filepath.Join(b.config.Destination, QuarantineDir))
+	if err != nil {
+		return
+	}
+
+	count := 0
+	for _, entry := range entries {
+		if entry.IsDir() {
+			count++
+		}
+	}
+	if count > 0 {
+		b.log("Warning: %d quarantined snapshots in %s - inspect and remove them manually",
+			count, filepath.Join(b.config.Destination, QuarantineDir))
+	}
+}
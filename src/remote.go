@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"go-rsync-backup/internal/rsyncpath"
+)
+
+// splitSSHPath splits a remote rsync path of the form [user@]host:/path into
+// the SSH target (user@host, without brackets around an IPv6 address) and
+// the path on the remote host. Other paths are returned unchanged.
+func splitSSHPath(path string) (string, string) {
+	loc := rsyncpath.Parse(path)
+	if loc.Kind != rsyncpath.SSH {
+		return "", path
+	}
+	return loc.Target(), loc.Path
+}
+
+// sshArgs returns the ssh options used for rsync transfers and remote
+// commands alike.
+func (b *Backup) sshArgs() []string {
+	args := append([]string{}, SSHArgs...)
+	if family := b.config.SSHAddressFamily; family != "" && family != "any" {
+		args = append(args, "-o", "AddressFamily="+family)
+	}
+	return args
+}
+
+// shellQuote quotes s for safe use as a single word in a remote shell command.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// runRemote executes a shell command on the given SSH target using the same
+// SSH options as the rsync transfer and returns its combined output.
+func (b *Backup) runRemote(target, command string) (string, error) {
+	args := append(b.sshArgs(), target, command)
+	output, err := exec.Command("ssh", args...).CombinedOutput()
+	if err != nil {
+		return string(output), fmt.Errorf("remote command on %s failed: %v: %s", target, err, strings.TrimSpace(string(output)))
+	}
+	return string(output), nil
+}
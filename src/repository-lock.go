@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Lock file in the repository. Commands reading snapshots hold it shared
+// and operations removing snapshots exclusively, so a snapshot is not
+// pruned while it is restored or exported. Unlike lock_file, which keeps
+// backups and other writers apart, it lets any number of readers run
+// alongside a backup.
+const RepositoryLockFile = ".go-rsync-backup.lock"
+
+// Commands that hold the repository lock shared while they run; prune,
+// compact and fsck take it themselves
+var readCommands = map[string]bool{
+	"list":               true,
+	"status":             true,
+	"verify":             true,
+	"restore":            true,
+	"clone-latest":       true,
+	"export":             true,
+	"diff-export":        true,
+	"audit-links":        true,
+	"changes":            true,
+	"sysinfo":            true,
+	"stats":              true,
+	"clone-snapshot":     true,
+	"migrate-repository": true,
+	"drift":              true,
+}
+
+// lockRepository takes the repository lock, shared or exclusive, and
+// returns the function releasing it. Without wait it returns
+// syscall.EWOULDBLOCK if the lock is held. Remote repositories, and
+// repositories the lock file cannot be created in, are not locked.
+func (b *Backup) lockRepository(exclusive, wait bool) (func(), error) {
+	if b.isSSHPath(b.config.Destination) {
+		return func() {}, nil
+	}
+	path := filepath.Join(b.config.Destination, RepositoryLockFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		// Readers may lack write access to the repository
+		if f, err = os.Open(path); err != nil {
+			return func() {}, nil
+		}
+	}
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	err = syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK && wait {
+		if exclusive {
+			b.log("Waiting for commands reading the repository to finish")
+		} else {
+			b.log("Waiting for snapshots to be removed from the repository")
+		}
+		for err = syscall.Flock(int(f.Fd()), how); err == syscall.EINTR; {
+			err = syscall.Flock(int(f.Fd()), how)
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() { f.Close() }, nil
+}
+
+// withRepositoryLock runs fn, which removes snapshots, under the exclusive
+// repository lock. A backup does not wait for a long restore or export to
+// finish; it leaves the removal to the next run instead.
+func (b *Backup) withRepositoryLock(what string, fn func() error) error {
+	unlock, err := b.lockRepository(true, false)
+	if err == syscall.EWOULDBLOCK {
+		b.log("Warning: %s postponed, the repository is being read by another command", what)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer unlock()
+	return fn()
+}
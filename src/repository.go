@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshot directories are named after NewBackup's timestamp format
+// (MST_2006-01-02_15.04.05), preceded by the job's snapshot_prefix and an
+// underscore if set; zones without abbreviation use offsets like +03
+var snapshotNameRe = regexp.MustCompile(`^(?:([A-Za-z0-9][A-Za-z0-9._-]*)_)?([A-Za-z0-9+-]+_\d{4}-\d{2}-\d{2}_\d{2}\.\d{2}\.\d{2})$`)
+
+// Valid values of snapshot_prefix
+var snapshotPrefixRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// splitSnapshotName returns the prefix ("" for snapshots of jobs without
+// snapshot_prefix) and the timestamp part of a snapshot name.
+func splitSnapshotName(name string) (prefix, timestamp string, ok bool) {
+	m := snapshotNameRe.FindStringSubmatch(name)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// isSnapshotName reports whether name is a snapshot of any job.
+func isSnapshotName(name string) bool {
+	return snapshotNameRe.MatchString(name)
+}
+
+// ownSnapshot reports whether name is a snapshot of this job. Jobs sharing
+// a repository only see, link against and prune their own snapshots.
+func (b *Backup) ownSnapshot(name string) bool {
+	prefix, _, ok := splitSnapshotName(name)
+	return ok && prefix == b.config.SnapshotPrefix
+}
+
+// snapshotTime returns the creation time encoded in a snapshot name. Zone
+// abbreviations are resolved against the local time zone.
+func snapshotTime(name string) (time.Time, error) {
+	if _, timestamp, ok := splitSnapshotName(name); ok {
+		name = timestamp
+	}
+	return time.ParseInLocation("MST_2006-01-02_15.04.05", name, time.Local)
+}
+
+// sortSnapshots sorts snapshot names oldest first by the time in the name.
+// The names start with the zone abbreviation, so as strings every CEST
+// snapshot sorts before every CET one. Unfinished snapshots (_PARTIAL,
+// _INCOMPLETE) sort by their time as well.
+func sortSnapshots(names []string) {
+	created := func(name string) time.Time {
+		t, _ := snapshotTime(strings.TrimSuffix(strings.TrimSuffix(name, "_INCOMPLETE"), PartialSuffix))
+		return t
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		ti, tj := created(names[i]), created(names[j])
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return names[i] < names[j]
+	})
+}
+
+// shortHostname returns the hostname without domain (e.g. "MacBook-Pro" for
+// "MacBook-Pro.local"), which names the per-host repository.
+func shortHostname() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "unknown-host"
+	}
+	hostname, _, _ = strings.Cut(hostname, ".")
+	return hostname
+}
+
+// listSnapshots returns the names of all complete snapshots of this job in
+// the local repository, oldest first.
+func (b *Backup) listSnapshots() ([]string, error) {
+	entries, err := os.ReadDir(b.config.Destination)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []string
+	for _, entry := range entries {
+		if entry.IsDir() && b.ownSnapshot(entry.Name()) {
+			snapshots = append(snapshots, entry.Name())
+		}
+	}
+
+	sortSnapshots(snapshots)
+	return snapshots, nil
+}
+
+// listIncomplete returns the names of all _INCOMPLETE directories of this
+// job in the local repository.
+func (b *Backup) listIncomplete() ([]string, error) {
+	entries, err := os.ReadDir(b.config.Destination)
+	if err != nil {
+		return nil, err
+	}
+
+	var incomplete []string
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasSuffix(entry.Name(), "_INCOMPLETE") && b.ownSnapshot(strings.TrimSuffix(entry.Name(), "_INCOMPLETE")) {
+			incomplete = append(incomplete, entry.Name())
+		}
+	}
+	return incomplete, nil
+}
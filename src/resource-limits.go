@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Valid values of cpu_quota, e.g. "50%" or "200%" for two cores
+var cpuQuotaRe = regexp.MustCompile(`^[1-9][0-9]*%$`)
+
+// hasResourceLimits reports whether any of cpu_quota, io_weight and
+// memory_max is set.
+func (b *Backup) hasResourceLimits() bool {
+	return b.config.CPUQuota != "" || b.config.IOWeight != 0 || b.config.MemoryMax != ""
+}
+
+// validateResourceLimits checks cpu_quota, io_weight and memory_max.
+func (b *Backup) validateResourceLimits() error {
+	if !b.hasResourceLimits() {
+		return nil
+	}
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("cpu_quota, io_weight and memory_max are only supported on Linux")
+	}
+	if b.config.CPUQuota != "" && !cpuQuotaRe.MatchString(b.config.CPUQuota) {
+		return fmt.Errorf("cpu_quota must be a percentage like 50%% (100%% per core)")
+	}
+	if b.config.IOWeight < 0 || b.config.IOWeight > 10000 {
+		return fmt.Errorf("io_weight must be between 1 and 10000")
+	}
+	if b.config.MemoryMax != "" {
+		if size, err := ParseSize(b.config.MemoryMax); err != nil || size <= 0 {
+			return fmt.Errorf("invalid memory_max %q", b.config.MemoryMax)
+		}
+	}
+	return nil
+}
+
+// rsyncCommand returns the command running rsync with args. With resource
+// limits it runs in a transient systemd scope, a cgroup that caps the CPU
+// time, I/O share and memory of rsync and everything it starts (e.g. ssh)
+// instead of relying on nice. Without systemd the limits are skipped with
+// a warning rather than failing the backup.
+func (b *Backup) rsyncCommand(args ...string) *exec.Cmd {
+	if !b.hasResourceLimits() {
+		return b.privileged(b.config.RsyncBin, args...)
+	}
+	if _, err := os.Stat("/run/systemd/system"); err != nil || !commandExists("systemd-run") {
+		b.log("Warning: systemd is not running, rsync runs without cpu_quota, io_weight and memory_max")
+		return b.privileged(b.config.RsyncBin, args...)
+	}
+
+	var properties []string
+	if b.config.CPUQuota != "" {
+		properties = append(properties, "CPUQuota="+b.config.CPUQuota)
+	}
+	if b.config.IOWeight != 0 {
+		properties = append(properties, "IOWeight="+strconv.Itoa(b.config.IOWeight))
+	}
+	if b.config.MemoryMax != "" {
+		size, _ := ParseSize(b.config.MemoryMax)
+		properties = append(properties, "MemoryMax="+strconv.FormatInt(size, 10))
+	}
+	unit := fmt.Sprintf("go-rsync-backup-%d", time.Now().UnixNano())
+	b.log("rsync runs in scope %s.scope: %s", unit, strings.Join(properties, " "))
+
+	scope := []string{"--scope", "--quiet", "--collect", "--unit=" + unit}
+	for _, property := range properties {
+		scope = append(scope, "-p", property)
+	}
+	scope = append(scope, "--", b.config.RsyncBin)
+	return b.privileged("systemd-run", append(scope, args...)...)
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"go-rsync-backup/internal/rsyncpath"
+)
+
+// Points in time accepted by restore instead of a snapshot name
+var restoreTimeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// rsync arguments and description of each restore --on-conflict policy,
+// i.e. what happens to an existing file on the target
+var restoreConflictPolicies = map[string]struct {
+	args        []string
+	description string
+}{
+	"overwrite": {nil, "will be overwritten"},
+	"skip":      {[]string{"--ignore-existing"}, "are left alone"},
+	"newer":     {[]string{"--update"}, "are only overwritten if the snapshot's copy is newer"},
+	"keep-both": {[]string{"--backup"}, "are kept with the suffix %s"},
+	"ask":       {nil, "are asked about one by one"},
+}
+
+// restoreItemRe matches a regular file in the --itemize-changes output of
+// rsync and captures its path
+var restoreItemRe = regexp.MustCompile(`^>f\S+ (.+)$`)
+
+// Characters that make a path an rsync wildcard pattern
+var rsyncWildcardRe = regexp.MustCompile(`[\\*?[]`)
+
+// snapshotAt returns the newest snapshot of this job created at or before
+// the point in time given as a date ("2026-03-01", meaning the end of that
+// day) or a date and time ("2026-03-01 14:30"). ok is false if at is not a
+// point in time.
+func (b *Backup) snapshotAt(at string) (name string, ok bool, err error) {
+	for _, layout := range restoreTimeLayouts {
+		t, err := time.ParseInLocation(layout, at, time.Local)
+		if err != nil {
+			continue
+		}
+		if layout == "2006-01-02" {
+			t = t.AddDate(0, 0, 1).Add(-time.Second)
+		}
+		snapshots, err := b.scanSnapshots()
+		if err != nil {
+			return "", true, err
+		}
+		for _, snapshot := range snapshots {
+			if created, err := snapshotTime(snapshot); err == nil && !created.After(t) {
+				name = snapshot
+			}
+		}
+		if name == "" {
+			return "", true, fmt.Errorf("no snapshot in %s was created before %s", b.config.Destination, t.Format("2006-01-02 15:04:05"))
+		}
+		return name, true, nil
+	}
+	return "", false, nil
+}
+
+// restorePath maps the path argument of restore to a path relative to the
+// snapshot root. Absolute paths are taken as paths of the source, so the
+// original path of a file can be given, e.g. /home/alice/notes.txt.
+func (b *Backup) restorePath(path string) (string, error) {
+	if filepath.IsAbs(path) && !b.relativeSources() {
+		rel, err := filepath.Rel(b.config.Source, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			return "", fmt.Errorf("%s is not inside the source %s", path, b.config.Source)
+		}
+		path = rel
+	}
+	rel := filepath.Clean(strings.TrimPrefix(path, "/"))
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("invalid path %q", path)
+	}
+	if rel == SnapshotMetaDir || strings.HasPrefix(rel, SnapshotMetaDir+"/") {
+		return "", fmt.Errorf("%s holds the snapshot metadata, not backed up files", SnapshotMetaDir)
+	}
+	return rel, nil
+}
+
+// resolveRestoreSnapshot is resolveSnapshot for local and remote
+// repositories.
+func (b *Backup) resolveRestoreSnapshot(name string) (string, error) {
+	if !b.isSSHPath(b.config.Destination) {
+		return b.resolveSnapshot(name)
+	}
+	if name == "latest" {
+		name = b.getLastBackup()
+		if name == "(none)" {
+			return "", fmt.Errorf("no latest snapshot found in %s", b.config.Destination)
+		}
+	}
+	if name == "" || strings.ContainsRune(name, filepath.Separator) {
+		return "", fmt.Errorf("invalid snapshot name %q", name)
+	}
+	path := filepath.Join(b.config.Destination, name)
+	host, remotePath := splitSSHPath(path)
+	if _, err := b.runRemote(host, "test -d "+shellQuote(remotePath)); err != nil {
+		return "", fmt.Errorf("snapshot %s not found in %s", name, b.config.Destination)
+	}
+	return path, nil
+}
+
+// restoreSourceExists reports whether a path exists in a snapshot, on the
+// remote host for remote repositories.
+func (b *Backup) restoreSourceExists(path string) bool {
+	if !b.isSSHPath(path) {
+		_, err := os.Lstat(path)
+		return err == nil
+	}
+	host, remotePath := splitSSHPath(path)
+	quoted := shellQuote(remotePath)
+	_, err := b.runRemote(host, "test -e "+quoted+" || test -L "+quoted)
+	return err == nil
+}
+
+// askConflicts lists the files of a restore that already exist in the
+// target, using a dry run of args, and asks on the terminal what to do
+// with each. Files to keep both of are renamed with suffix right away; the
+// files to skip are returned as rsync filter patterns.
+func (b *Backup) askConflicts(args []string, from, to, suffix string) ([]string, error) {
+	dryRun := append(append([]string{}, args...), "--dry-run", from, to+"/")
+	output, err := b.rsyncCommand(dryRun...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the files to restore: %v", err)
+	}
+
+	var skip []string
+	all := ""
+	reader := bufio.NewReader(os.Stdin)
+	for _, line := range strings.Split(string(output), "\n") {
+		m := restoreItemRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		existing := filepath.Join(to, m[1])
+		if info, err := os.Lstat(existing); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		answer := all
+		for answer == "" {
+			fmt.Printf("%s exists: [o]verwrite, [s]kip, [k]eep both (O, S, K for all remaining)? ", existing)
+			input, err := reader.ReadString('\n')
+			if err != nil {
+				return nil, fmt.Errorf("aborted by user")
+			}
+			switch input = strings.TrimSpace(input); input {
+			case "o", "s", "k":
+				answer = input
+			case "O", "S", "K":
+				answer = strings.ToLower(input)
+				all = answer
+			}
+		}
+		switch answer {
+		case "s":
+			// Anchored at the transfer root, with wildcards taken literally
+			skip = append(skip, "/"+rsyncWildcardRe.ReplaceAllString(m[1], `\$0`))
+		case "k":
+			if err := os.Rename(existing, existing+suffix); err != nil {
+				return nil, fmt.Errorf("failed to keep %s: %v", existing, err)
+			}
+		}
+	}
+	return skip, nil
+}
+
+// runRestore copies a snapshot, or a file or directory from it, back into a
+// target directory with the preservation flags of the backup. Nothing is
+// deleted on the target. Snapshots in a remote repository are copied over
+// SSH.
+func (b *Backup) runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	target := fs.String("to", "", "Directory to restore into")
+	dryRun := fs.Bool("dry-run", false, "Only show what would be restored")
+	progress := fs.Bool("progress", false, "Show the overall progress (always on for remote repositories)")
+	yes := fs.Bool("yes", false, "Do not ask for confirmation")
+	onConflict := fs.String("on-conflict", "overwrite", "What to do with existing files: overwrite, skip, keep-both, newer or ask")
+	usermap := fs.String("usermap", "", "Map owners to the IDs of this machine, e.g. 501:1000,502:1001")
+	groupmap := fs.String("groupmap", "", "Map groups to the IDs of this machine, e.g. 20:1000")
+	noACLs := fs.Bool("no-acls", false, "Do not restore ACLs")
+	positional := parseArgs(fs, args)
+
+	if len(positional) < 1 || len(positional) > 2 || *target == "" {
+		return fmt.Errorf("usage: restore <snapshot|latest|date> [path] --to <directory> [--on-conflict overwrite|skip|keep-both|newer|ask] [--usermap from:to,...] [--groupmap from:to,...] [--no-acls] [--progress] [--dry-run] [--yes]")
+	}
+	policy, ok := restoreConflictPolicies[*onConflict]
+	if !ok {
+		return fmt.Errorf("--on-conflict must be overwrite, skip, keep-both, newer or ask")
+	}
+	if *onConflict == "ask" && !*dryRun {
+		if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+			return fmt.Errorf("--on-conflict ask needs a terminal")
+		}
+	}
+	// Existing files are renamed, e.g. notes.txt.before-restore-20260301-120000
+	suffix := ".before-restore-" + time.Now().Format("20060102-150405")
+	conflicts := policy.description
+	if *onConflict == "keep-both" {
+		policy.args = append(policy.args, "--suffix="+suffix)
+		conflicts = fmt.Sprintf(conflicts, suffix)
+	}
+	remote := b.isSSHPath(b.config.Destination)
+	if rsyncpath.Parse(b.config.Destination).Kind == rsyncpath.Daemon {
+		return fmt.Errorf("restore does not support rsync daemon repositories")
+	}
+	if err := b.checkRepositoryAccess(); err != nil {
+		return err
+	}
+
+	name, ok, err := b.snapshotAt(positional[0])
+	if err != nil {
+		return err
+	}
+	if !ok {
+		name = positional[0]
+	}
+	snapshot, err := b.resolveRestoreSnapshot(name)
+	if err != nil {
+		return err
+	}
+	name = filepath.Base(snapshot)
+
+	from := snapshot + "/"
+	what := name
+	if len(positional) == 2 {
+		rel, err := b.restorePath(positional[1])
+		if err != nil {
+			return err
+		}
+		if rel != "." {
+			from = filepath.Join(snapshot, rel)
+			if !b.restoreSourceExists(from) {
+				return fmt.Errorf("%s is not in snapshot %s", rel, name)
+			}
+			what = rel + " from " + name
+		}
+	}
+
+	// Restoring into the repository would change the snapshots themselves
+	to, err := filepath.Abs(*target)
+	if err != nil {
+		return err
+	}
+	if !remote {
+		repository, _ := filepath.Abs(b.config.Destination)
+		if to == repository || strings.HasPrefix(to, repository+"/") {
+			return fmt.Errorf("cannot restore into the repository %s", b.config.Destination)
+		}
+	}
+	if err := b.findRsync(); err != nil {
+		return fmt.Errorf("failed to find rsync: %v", err)
+	}
+
+	args = b.cloneRsyncArgs(false)
+	if *noACLs {
+		args = slices.DeleteFunc(args, func(arg string) bool { return arg == "-A" })
+	}
+	// Restoring on a rebuilt machine, where the users got other IDs
+	for _, m := range []struct{ option, mapping string }{{"usermap", *usermap}, {"groupmap", *groupmap}} {
+		option, mapping := m.option, m.mapping
+		if mapping == "" {
+			continue
+		}
+		if len(b.rsyncOptions) > 0 && !b.rsyncOptions[option] {
+			return fmt.Errorf("%s does not support --%s (rsync 3.1 or newer is needed)", b.config.RsyncBin, option)
+		}
+		args = append(args, "--"+option+"="+mapping)
+	}
+	if remote {
+		args = append(args, b.compressArgs()...)
+		args = append(args, "-e", "ssh "+strings.Join(b.sshArgs(), " "))
+	}
+	if (remote || *progress) && !*dryRun && !b.config.ShowProgress {
+		// --info=progress2 shows the whole transfer instead of each file
+		if b.rsyncOptions["info"] {
+			args = append(args, "--info=progress2")
+		} else {
+			args = append(args, "--progress")
+		}
+	}
+	args = append(args, policy.args...)
+	if *dryRun {
+		args = append(args, "--dry-run")
+	} else {
+		if entries, err := os.ReadDir(to); err == nil && len(entries) > 0 {
+			fmt.Printf("Note: %s is not empty. Existing files with the same names %s.\n", to, conflicts)
+		}
+		if !*yes && !confirm(fmt.Sprintf("Restore %s to %s?", what, to)) {
+			return fmt.Errorf("aborted by user")
+		}
+		if err := os.MkdirAll(to, 0755); err != nil {
+			return fmt.Errorf("failed to create target: %v", err)
+		}
+		if *onConflict == "ask" {
+			skip, err := b.askConflicts(args, from, to, suffix)
+			if err != nil {
+				return err
+			}
+			if len(skip) > 0 {
+				filter, err := os.CreateTemp("", "go-rsync-backup-restore-*")
+				if err != nil {
+					return err
+				}
+				defer os.Remove(filter.Name())
+				_, err = filter.WriteString(strings.Join(skip, "\n") + "\n")
+				filter.Close()
+				if err != nil {
+					return fmt.Errorf("failed to write the files to skip: %v", err)
+				}
+				args = append(args, "--exclude-from="+filter.Name())
+			}
+		}
+	}
+	args = append(args, from, to+"/")
+
+	b.log("Restoring %s to %s", what, to)
+	b.log("Running rsync: %s %s", b.config.RsyncBin, strings.Join(args, " "))
+	cmd := b.rsyncCommand(args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("rsync failed: %v", err)
+	}
+
+	if !*dryRun {
+		b.log("Restore of %s to %s completed successfully", what, to)
+	}
+	return nil
+}
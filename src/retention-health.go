@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// checkRetentionHealth warns through alert_command when keeping keep
+// snapshots will make the disk usage reach cleanup_at_percent within
+// retention_warning_days, at the growth measured by forecast. From then on
+// every run fails the disk space check, so this is the time to add space,
+// lower keep or exclude fast-growing data.
+func (b *Backup) checkRetentionHealth() {
+	if b.config.RetentionWarningDays <= 0 || b.config.DryRun || b.isSSHPath(b.config.Destination) {
+		return
+	}
+	snapshots, err := b.listSnapshots()
+	if err != nil || len(snapshots) < 2 {
+		return // Growth cannot be measured yet
+	}
+	g, err := b.measureGrowth(snapshots)
+	if err != nil {
+		b.log("Warning: retention health check failed: %v", err)
+		return
+	}
+	used, _, limit, err := b.destinationUsage()
+	if err != nil {
+		b.log("Warning: retention health check failed: %v", err)
+		return
+	}
+
+	other := math.Max(0, float64(used-g.UniqueSize))
+	steady := g.steadySize(b.config.Keep)
+	days, reason := thresholdDays(other, float64(g.UniqueSize), steady, limit, g, g.runsPerDay(), b.config.Keep)
+	if reason != "" || days > float64(b.config.RetentionWarningDays) {
+		return
+	}
+
+	when := "has already been reached"
+	if days > 0 {
+		when = "will be reached " + forecastDays(days)
+	}
+	msg := fmt.Sprintf("Keeping %d snapshots in %s needs about %.2f GB at the current growth; cleanup_at_percent %d%% (%.2f GB) %s, after which backups fail",
+		b.config.Keep, b.config.Destination, gib(int64(steady)), b.config.CleanupAtPercent, gib(int64(limit)), when)
+	b.log("Warning: %s", msg)
+	b.alert("warning", msg)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// thinSnapshots applies the thinning rules: within each rule's age range
+// only the newest snapshot per interval is kept. Snapshots older than all
+// rules, and the newest snapshot, are left alone. It returns the remaining
+// snapshots, oldest first.
+func (b *Backup) thinSnapshots(snapshots []string) []string {
+	remove := b.thinningCandidates(snapshots)
+
+	var remaining []string
+	for _, name := range snapshots {
+		if remove[name] {
+			b.log("Thinning: %s shares its interval with a newer snapshot", name)
+			b.removeSnapshot(name, "thinning")
+		} else {
+			remaining = append(remaining, name)
+		}
+	}
+	return remaining
+}
+
+// thinningCandidates returns the snapshots the thinning rules would remove.
+func (b *Backup) thinningCandidates(snapshots []string) map[string]bool {
+	remove := make(map[string]bool)
+	if len(b.config.Thinning) == 0 || len(snapshots) < 2 {
+		return remove
+	}
+
+	type rule struct{ within, every time.Duration }
+	var rules []rule
+	for _, r := range b.config.Thinning {
+		within, _ := ParseDuration(r.Within)
+		every, _ := ParseDuration(r.Every)
+		rules = append(rules, rule{within, every})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].within < rules[j].within })
+
+	now := time.Now()
+	seen := make(map[string]bool)
+	// Walk newest first so the newest snapshot of each interval is kept
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		t, err := snapshotTime(snapshots[i])
+		if err != nil {
+			continue
+		}
+		for idx, r := range rules {
+			if now.Sub(t) > r.within {
+				continue
+			}
+			// Buckets are aligned to the interval so they do not shift between runs
+			bucket := fmt.Sprintf("%d/%d", idx, t.UnixNano()/int64(r.every))
+			if seen[bucket] {
+				remove[snapshots[i]] = true
+			}
+			seen[bucket] = true
+			break
+		}
+	}
+	return remove
+}
+
+// enforceRepositoryQuota removes the oldest snapshots until the unique size
+// of the repository (every hard-linked inode counted once) fits into
+// max_repository_size. The newest snapshot is always kept.
+func (b *Backup) enforceRepositoryQuota(snapshots []string) error {
+	if b.config.MaxRepositorySize == "" || len(snapshots) < 2 {
+		return nil
+	}
+	quota, err := ParseSize(b.config.MaxRepositorySize)
+	if err != nil {
+		return err
+	}
+
+	// Count how many snapshots reference each inode so the space freed by
+	// removing a snapshot is known without walking the repository again
+	type inodeUsage struct {
+		size int64
+		refs int
+	}
+	usage := make(map[uint64]*inodeUsage)
+	catalogs := make([][]CatalogEntry, len(snapshots))
+	var total int64
+	for i, name := range snapshots {
+		entries, err := b.loadCatalog(name)
+		if err != nil {
+			return fmt.Errorf("failed to read catalog of %s: %v", name, err)
+		}
+		catalogs[i] = entries
+		for _, e := range entries {
+			if u, ok := usage[e.Inode]; ok {
+				u.refs++
+				continue
+			}
+			usage[e.Inode] = &inodeUsage{size: e.Size, refs: 1}
+			total += e.Size
+		}
+	}
+
+	b.log("Repository size: %.2f GB (quota: %.2f GB)", gib(total), gib(quota))
+	for i := 0; total > quota && i < len(snapshots)-1; i++ {
+		var freed int64
+		for _, e := range catalogs[i] {
+			if u := usage[e.Inode]; u != nil {
+				if u.refs--; u.refs == 0 {
+					freed += u.size
+					delete(usage, e.Inode)
+				}
+			}
+		}
+		b.log("Repository exceeds quota, removing %s (frees %.2f GB)", snapshots[i], gib(freed))
+		b.removeSnapshot(snapshots[i], "max_repository_size "+b.config.MaxRepositorySize)
+		total -= freed
+	}
+
+	if total > quota {
+		b.log("Warning: repository size %.2f GB still exceeds quota %.2f GB with only the newest snapshot left", gib(total), gib(quota))
+	}
+	return nil
+}
+
+// ensureFreeSpace removes the oldest snapshots until at least min_free_space
+// is available on the destination. The newest snapshot is always kept.
+func (b *Backup) ensureFreeSpace() error {
+	if b.config.MinFreeSpace == "" || b.isSSHPath(b.config.Destination) {
+		return nil
+	}
+	floor, err := ParseSize(b.config.MinFreeSpace)
+	if err != nil {
+		return err
+	}
+
+	free, err := freeSpace(b.config.Destination)
+	if err != nil {
+		return err
+	}
+	if free >= floor {
+		b.log("Free space: %.2f GB (minimum: %.2f GB)", gib(free), gib(floor))
+		return nil
+	}
+	if b.config.DryRun {
+		b.log("Free space %.2f GB below minimum %.2f GB, the oldest snapshots would be deleted until enough is free", gib(free), gib(floor))
+		return nil
+	}
+
+	// Snapshots kept in the trash go first
+	for _, t := range b.listTrash() {
+		if free >= floor {
+			break
+		}
+		b.log("Free space %.2f GB below minimum %.2f GB, deleting %s from the trash", gib(free), gib(floor), t.Name)
+		b.deleteTrashed(t.Name, "min_free_space "+b.config.MinFreeSpace)
+		if free, err = freeSpace(b.config.Destination); err != nil {
+			return err
+		}
+	}
+
+	snapshots, err := b.listSnapshots()
+	if err != nil {
+		return err
+	}
+	for i := 0; free < floor && i < len(snapshots)-1; i++ {
+		b.log("Free space %.2f GB below minimum %.2f GB, removing %s", gib(free), gib(floor), snapshots[i])
+		b.removeSnapshot(snapshots[i], "min_free_space "+b.config.MinFreeSpace)
+		b.deleteTrashed(snapshots[i], "min_free_space "+b.config.MinFreeSpace) // Space is only freed once the snapshot is deleted
+		if free, err = freeSpace(b.config.Destination); err != nil {
+			return err
+		}
+	}
+
+	if free < floor {
+		b.log("Warning: free space %.2f GB is below minimum %.2f GB with only the newest snapshot left", gib(free), gib(floor))
+	}
+	return nil
+}
+
+// freeSpace returns the bytes available to unprivileged users on the
+// filesystem containing path.
+func freeSpace(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, fmt.Errorf("failed to get free space of %s: %v", path, err)
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// gib converts bytes into GB as used in log messages.
+func gib(bytes int64) float64 {
+	return float64(bytes) / (1024 * 1024 * 1024)
+}
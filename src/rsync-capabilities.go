@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os/exec"
+	"regexp"
+)
+
+// rsyncOptionNames maps the flags this tool may pass to the long option
+// name rsync lists for them in --help.
+var rsyncOptionNames = map[string]string{
+	"-U":          "atimes",
+	"-A":          "acls",
+	"-X":          "xattrs",
+	"-E":          "executability",
+	"--fileflags": "fileflags",
+	"--crtimes":   "crtimes",
+}
+
+// rsyncHelpOptionRe matches the long options in rsync --help output
+var rsyncHelpOptionRe = regexp.MustCompile(`--([a-z][a-z0-9-]*)`)
+
+// probeRsync records the long options the selected rsync binary supports,
+// as listed by --help. Builds differ in more than their version (e.g. ACL,
+// xattr or file flag support can be compiled out), so flags are chosen by
+// what the binary reports instead of by version number. If --help lists no
+// options the capabilities stay unknown.
+func (b *Backup) probeRsync() {
+	if b.rsyncOptions != nil {
+		return
+	}
+	b.rsyncOptions = make(map[string]bool)
+	output, err := exec.Command(b.config.RsyncBin, "--help").CombinedOutput()
+	if err != nil && len(output) == 0 {
+		return
+	}
+	for _, m := range rsyncHelpOptionRe.FindAllStringSubmatch(string(output), -1) {
+		b.rsyncOptions[m[1]] = true
+	}
+}
+
+// crtimesArgs returns --crtimes if the rsync binary reports support for it.
+// Only builds for platforms with creation times (macOS) offer the option,
+// so it is never passed on unconfirmed capabilities.
+func (b *Backup) crtimesArgs() []string {
+	b.probeRsync()
+	if !b.rsyncOptions["crtimes"] {
+		b.log("Warning: %s does not support --crtimes, creation times are not preserved", b.config.RsyncBin)
+		return nil
+	}
+	return []string{"--crtimes"}
+}
+
+// supportedArgs returns args without the flags the selected rsync binary
+// does not support. When its capabilities are unknown all flags are kept.
+func (b *Backup) supportedArgs(args []string) []string {
+	b.probeRsync()
+	if len(b.rsyncOptions) == 0 {
+		return args
+	}
+
+	var supported []string
+	for _, arg := range args {
+		if name, ok := rsyncOptionNames[arg]; ok && !b.rsyncOptions[name] {
+			b.log("Warning: %s does not support --%s, omitting %s", b.config.RsyncBin, name, arg)
+			continue
+		}
+		supported = append(supported, arg)
+	}
+	return supported
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Suffix of the files in rsync_output_dir, named after the snapshot.
+const RsyncOutputSuffix = ".rsync.log.gz"
+
+// saveRsyncOutput stores the complete output of this run's rsync, gzipped,
+// in rsync_output_dir, so a specific run can be debugged without the
+// itemized file list bloating the main log. Older output of this job is
+// then removed according to rsync_output_retention.
+func (b *Backup) saveRsyncOutput(command, stdout, stderr string, rsyncErr error) {
+	if b.config.RsyncOutputDir == "" {
+		return
+	}
+	if err := b.writeRsyncOutput(command, stdout, stderr, rsyncErr); err != nil {
+		b.log("Warning: failed to store rsync output: %v", err)
+	}
+	b.pruneRsyncOutput()
+}
+
+func (b *Backup) writeRsyncOutput(command, stdout, stderr string, rsyncErr error) error {
+	if err := os.MkdirAll(b.config.RsyncOutputDir, 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(b.config.RsyncOutputDir, b.timestamp+RsyncOutputSuffix))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	status := "ok"
+	if rsyncErr != nil {
+		status = rsyncErr.Error()
+	}
+	gz := gzip.NewWriter(f)
+	fmt.Fprintf(gz, "# %s\n# started %s, exit: %s\n", command, b.started.Format(time.RFC3339), status)
+	fmt.Fprintf(gz, "\n# stdout\n%s", stdout)
+	fmt.Fprintf(gz, "\n# stderr\n%s", stderr)
+	return gz.Close()
+}
+
+// pruneRsyncOutput deletes stored rsync output of this job that is older
+// than rsync_output_retention (kept forever without it). Output of other
+// jobs sharing the directory follows their own retention.
+func (b *Backup) pruneRsyncOutput() {
+	if b.config.RsyncOutputRetention == "" {
+		return
+	}
+	retention, err := ParseDuration(b.config.RsyncOutputRetention)
+	if err != nil {
+		return
+	}
+	entries, err := os.ReadDir(b.config.RsyncOutputDir)
+	if err != nil {
+		return
+	}
+	removed := 0
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), RsyncOutputSuffix)
+		if !ok || !b.ownSnapshot(name) {
+			continue
+		}
+		if t, err := snapshotTime(name); err != nil || time.Since(t) < retention {
+			continue
+		}
+		if err := os.Remove(filepath.Join(b.config.RsyncOutputDir, entry.Name())); err == nil {
+			removed++
+		}
+	}
+	if removed > 0 {
+		b.log("Removed rsync output of %d runs older than %s", removed, b.config.RsyncOutputRetention)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// numberOfFilesRe matches the entry count of the rsync statistics, e.g.
+// "Number of files: 1,234 (reg: 1,000, dir: 234)". rsync before 3.1 does
+// not break it down.
+var numberOfFilesRe = regexp.MustCompile(`Number of files: ([0-9,]+)(?: \(reg: ([0-9,]+))?`)
+
+// statsCount parses a number of the rsync statistics, -1 if missing.
+func statsCount(s string) int {
+	n, err := strconv.Atoi(strings.ReplaceAll(s, ",", ""))
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// safetyDryRun runs the transfer against the previous snapshot in dry-run
+// mode before the real one and refuses the run if it would delete more than
+// max_delete_percent of the previous snapshot (e.g. an unmounted disk or an
+// emptied home directory). For remote sources, which checkSourcePopulated
+// cannot count, min_source_files is checked against the count rsync
+// reports. Nothing has been written when it fails.
+func (b *Backup) safetyDryRun(lastBackup string) error {
+	if !b.config.SafetyDryRun || b.config.DryRun {
+		return nil
+	}
+	if lastBackup == "(none)" {
+		b.log("Safety dry run skipped: no previous snapshot")
+		return nil
+	}
+
+	output, err := b.dryRunOutput(lastBackup)
+	if err != nil {
+		return err
+	}
+	created, modified, deleted := 0, 0, 0
+	for _, c := range parseItemized(output) {
+		if c.Path == SnapshotMetaDir || strings.HasPrefix(c.Path, SnapshotMetaDir+"/") {
+			continue
+		}
+		switch driftKind(c) {
+		case "created":
+			created++
+		case "modified":
+			modified++
+		case "deleted":
+			deleted++
+		}
+	}
+	files, regular := -1, -1
+	if m := numberOfFilesRe.FindStringSubmatch(output); m != nil {
+		files, regular = statsCount(m[1]), statsCount(m[2])
+	}
+	b.log("Safety dry run against %s: %d entries in the source, %d created, %d modified, %d deleted, %.2f GB to transfer",
+		lastBackup, files, created, modified, deleted, b.parseTransferredGB(output))
+
+	if b.hasSSHSource() && b.config.MinSourceFiles > 0 {
+		if regular < 0 {
+			b.log("Warning: rsync reported no regular file count, min_source_files not checked")
+		} else if regular < b.config.MinSourceFiles {
+			return fmt.Errorf("source contains only %d files (min_source_files: %d) - is the source mounted?",
+				regular, b.config.MinSourceFiles)
+		}
+	}
+	if previous := files - created + deleted; b.config.MaxDeletePercent > 0 && files >= 0 && previous > 0 {
+		if percent := deleted * 100 / previous; percent > b.config.MaxDeletePercent {
+			return fmt.Errorf("%d of %d entries (%d%%) of %s would be deleted, more than max_delete_percent %d%%",
+				deleted, previous, percent, lastBackup, b.config.MaxDeletePercent)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// seedSnapshot returns the snapshot of seed_repository to seed from.
+func (b *Backup) seedSnapshot() (string, error) {
+	return findSeedSnapshot(b.config.SeedRepository)
+}
+
+// findSeedSnapshot returns the snapshot of a repository to seed from: the
+// target of its latest link, else its newest snapshot. A directory that is
+// not a repository (e.g. a single snapshot or a plain copy) is used as is.
+func findSeedSnapshot(repo string) (string, error) {
+	if info, err := os.Stat(repo); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("seed repository %s not found (is the disk mounted?)", repo)
+	}
+	if target, err := os.Readlink(filepath.Join(repo, "latest")); err == nil {
+		return filepath.Join(repo, filepath.Base(target)), nil
+	}
+
+	entries, err := os.ReadDir(repo)
+	if err != nil {
+		return "", err
+	}
+	var snapshots []string
+	for _, entry := range entries {
+		if entry.IsDir() && isSnapshotName(entry.Name()) {
+			snapshots = append(snapshots, entry.Name())
+		}
+	}
+	if len(snapshots) == 0 {
+		return repo, nil
+	}
+	sortSnapshots(snapshots)
+	return filepath.Join(repo, snapshots[len(snapshots)-1]), nil
+}
+
+// seedArg returns the rsync option that lets the first snapshot of a new
+// repository take unchanged files from seed_repository instead of reading
+// them from the source: hard links if the seed is on the same filesystem,
+// local copies (--copy-dest) from another disk otherwise.
+func (b *Backup) seedArg() (string, error) {
+	seed, err := b.seedSnapshot()
+	if err != nil {
+		return "", err
+	}
+	var seedStat, destStat syscall.Stat_t
+	if err := syscall.Stat(seed, &seedStat); err != nil {
+		return "", err
+	}
+	if err := syscall.Stat(b.config.Destination, &destStat); err != nil {
+		return "", err
+	}
+	if seedStat.Dev == destStat.Dev {
+		b.log("Seeding from %s (hard links)", seed)
+		return "--link-dest=" + seed, nil
+	}
+	b.log("Seeding from %s (local copies)", seed)
+	return "--copy-dest=" + seed, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// How often the progress of the first full copy is logged
+const seedingReportInterval = 5 * time.Minute
+
+// startSeeding switches a run into seeding mode if seeding_mode is set and
+// the repository has no finished snapshot of this job yet. The first copy
+// can take days: it runs without --delete, keeps what it got when rsync
+// fails so the next run continues it, may be capped by seed_transfer_cap
+// and logs its progress. Once it finishes, the job runs incrementally like
+// any other.
+func (b *Backup) startSeeding(lastBackup string) {
+	if !b.config.SeedingMode || lastBackup != "(none)" {
+		return
+	}
+	b.seeding = true
+	msg := "Repository is empty: seeding mode, copying everything once (resumable, without --delete)"
+	if b.config.SeedTransferCap != "" {
+		msg += ", at most " + b.config.SeedTransferCap + " per run"
+	}
+	b.log("%s", msg)
+}
+
+// reportSeedingProgress logs the data copied so far at regular intervals
+// until the returned function is called.
+func (b *Backup) reportSeedingProgress(meter *transferMeter) func() {
+	if !b.seeding || meter == nil {
+		return func() {}
+	}
+	ticker := time.NewTicker(seedingReportInterval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				b.log("Seeding: %.2f GB copied in %s", gib(meter.transferred()), time.Since(b.started).Round(time.Minute))
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// seedingSummary logs how the first snapshot was created and that the job
+// continues incrementally.
+func (b *Backup) seedingSummary() {
+	if !b.seeding || b.config.DryRun {
+		return
+	}
+	size := ""
+	if entries, err := b.loadCatalog(b.timestamp); err == nil {
+		var total int64
+		files := 0
+		for _, e := range entries {
+			if !e.Mode.IsDir() && !strings.HasPrefix(e.Path, SnapshotMetaDir+"/") {
+				total += e.Size
+				files++
+			}
+		}
+		size = fmt.Sprintf(" (%d files, %.2f GB)", files, gib(total))
+	}
+	b.log("Seeding complete: first snapshot %s%s, this run copied %.2f GB in %s",
+		b.timestamp, size, b.transferredGB, time.Since(b.started).Round(time.Second))
+	b.log("Following runs are incremental: unchanged files are hard-linked against %s and deletions are mirrored", b.timestamp)
+}
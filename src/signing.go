@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Files in the snapshot's metadata directory written with signing_key: the
+// SHA-256 of every regular file, and the signature over it and the catalog
+const (
+	HashesFile    = "hashes.tsv.gz"
+	SignatureFile = "manifest.sig"
+)
+
+// Comment lines of the key files, in the style of minisign
+const (
+	secretKeyComment = "untrusted comment: go-rsync-backup secret key"
+	publicKeyComment = "untrusted comment: go-rsync-backup public key"
+)
+
+// readKeyFile returns the key in a key file: a comment line followed by
+// the base64-encoded key.
+func readKeyFile(path, comment string, size int) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	first, rest, _ := strings.Cut(string(data), "\n")
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(rest))
+	if first != comment || err != nil || len(key) != size {
+		return nil, fmt.Errorf("%s is not a %s", path, strings.TrimPrefix(comment, "untrusted comment: "))
+	}
+	return key, nil
+}
+
+// loadSigningKey reads signing_key.
+func (b *Backup) loadSigningKey() (ed25519.PrivateKey, error) {
+	seed, err := readKeyFile(b.config.SigningKey, secretKeyComment, ed25519.SeedSize)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// publicKeyPath returns signing_public_key, by default the .pub file next
+// to signing_key. It is empty if signatures are not checked.
+func (b *Backup) publicKeyPath() string {
+	if b.config.SigningPublicKey != "" {
+		return b.config.SigningPublicKey
+	}
+	if b.config.SigningKey != "" {
+		return b.config.SigningKey + ".pub"
+	}
+	return ""
+}
+
+// loadPublicKey reads the key signatures are checked with.
+func (b *Backup) loadPublicKey() (ed25519.PublicKey, error) {
+	key, err := readKeyFile(b.publicKeyPath(), publicKeyComment, ed25519.PublicKeySize)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// fileDigest returns the hex SHA-256 of a file.
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// loadHashes reads the content hashes of a snapshot in the local
+// repository, by path.
+func (b *Backup) loadHashes(name string) (map[string]string, error) {
+	f, err := os.Open(filepath.Join(b.config.Destination, name, SnapshotMetaDir, HashesFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("hashes of %s are corrupt: %v", name, err)
+	}
+	defer gz.Close()
+
+	hashes := make(map[string]string)
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		digest, quoted, _ := strings.Cut(scanner.Text(), "\t")
+		path, err := strconv.Unquote(quoted)
+		if err != nil || len(digest) != sha256.Size*2 {
+			return nil, fmt.Errorf("hashes of %s are corrupt: %q", name, scanner.Text())
+		}
+		hashes[path] = digest
+	}
+	return hashes, scanner.Err()
+}
+
+// writeHashes stores the SHA-256 of every regular file of the current
+// snapshot. Files hard-linked to the same path of the previous snapshot
+// keep their hash, so only the files written by this run are read.
+func (b *Backup) writeHashes(lastBackup string) (int, error) {
+	current, err := b.loadCatalog(filepath.Base(b.snapDir))
+	if err != nil {
+		return 0, err
+	}
+	previousInodes := make(map[string]uint64)
+	var previousHashes map[string]string
+	if lastBackup != "(none)" {
+		if previousHashes, err = b.loadHashes(lastBackup); err == nil {
+			previous, err := b.loadCatalog(lastBackup)
+			if err != nil {
+				return 0, err
+			}
+			for _, e := range previous {
+				previousInodes[e.Path] = e.Inode
+			}
+		}
+	}
+
+	f, err := os.Create(filepath.Join(b.snapDir, SnapshotMetaDir, HashesFile))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	w := bufio.NewWriter(gz)
+	read := 0
+	for _, e := range current {
+		if !e.Mode.IsRegular() {
+			continue
+		}
+		digest, ok := previousHashes[e.Path]
+		if !ok || previousInodes[e.Path] != e.Inode {
+			if digest, err = fileDigest(filepath.Join(b.snapDir, e.Path)); err != nil {
+				return 0, err
+			}
+			read++
+		}
+		fmt.Fprintf(w, "%s\t%s\n", digest, strconv.Quote(e.Path))
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+	return read, f.Close()
+}
+
+// snapshotStatement returns what the signature of a snapshot covers: its
+// name, catalog and content hashes. Other metadata, like the change list
+// rewritten by compact, is not signed.
+func snapshotStatement(snapDir, name string) ([]byte, error) {
+	catalog, err := fileDigest(filepath.Join(snapDir, SnapshotMetaDir, CatalogFile))
+	if err != nil {
+		return nil, err
+	}
+	hashes, err := fileDigest(filepath.Join(snapDir, SnapshotMetaDir, HashesFile))
+	if err != nil {
+		return nil, err
+	}
+	return fmt.Appendf(nil, "go-rsync-backup snapshot %s\n%s %s\n%s %s\n", name, CatalogFile, catalog, HashesFile, hashes), nil
+}
+
+// signSnapshot writes the content hashes of the current snapshot and signs
+// them together with its catalog, so later changes to the snapshot are
+// detected by verify and fsck.
+func (b *Backup) signSnapshot(lastBackup string) error {
+	if b.config.SigningKey == "" || b.config.DryRun {
+		return nil
+	}
+	if b.isSSHPath(b.config.Destination) {
+		b.log("Signing skipped for remote destination")
+		return nil
+	}
+	key, err := b.loadSigningKey()
+	if err != nil {
+		return err
+	}
+	read, err := b.writeHashes(lastBackup)
+	if err != nil {
+		return fmt.Errorf("failed to hash snapshot: %v", err)
+	}
+	if err := writeSnapshotSignature(key, b.snapDir, b.timestamp); err != nil {
+		return err
+	}
+	b.log("Snapshot signed (%d files hashed)", read)
+	return nil
+}
+
+// writeSnapshotSignature signs the catalog and content hashes of a
+// snapshot.
+func writeSnapshotSignature(key ed25519.PrivateKey, snapDir, name string) error {
+	statement, err := snapshotStatement(snapDir, name)
+	if err != nil {
+		return err
+	}
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, statement))
+	data := fmt.Sprintf("untrusted comment: signature of %s\n%s\n", name, signature)
+	return os.WriteFile(filepath.Join(snapDir, SnapshotMetaDir, SignatureFile), []byte(data), 0644)
+}
+
+// resignAfter runs rewrite, which changes the catalog of a snapshot but
+// not its files, and signs the snapshot again if signing_key is set and
+// its signature held before. A signature that was already broken stays
+// broken, as signing again would hide the tampering.
+func (b *Backup) resignAfter(name string, rewrite func() error) error {
+	var key ed25519.PrivateKey
+	if b.config.SigningKey != "" {
+		public, err := b.loadPublicKey()
+		if err == nil && b.checkSnapshotSignature(public, name) == nil {
+			if key, err = b.loadSigningKey(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rewrite(); err != nil {
+		return err
+	}
+	if key == nil {
+		return nil
+	}
+	if err := writeSnapshotSignature(key, filepath.Join(b.config.Destination, name), name); err != nil {
+		return fmt.Errorf("failed to sign %s again: %v", name, err)
+	}
+	b.log("  signed %s again", name)
+	return nil
+}
+
+// checkSnapshotSignature checks the signature of a snapshot against its
+// catalog and content hashes. It does not read the files themselves.
+func (b *Backup) checkSnapshotSignature(key ed25519.PublicKey, name string) error {
+	snapDir := filepath.Join(b.config.Destination, name)
+	data, err := os.ReadFile(filepath.Join(snapDir, SnapshotMetaDir, SignatureFile))
+	if err != nil {
+		return fmt.Errorf("not signed")
+	}
+	_, encoded, _ := strings.Cut(string(data), "\n")
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return fmt.Errorf("signature is corrupt")
+	}
+	statement, err := snapshotStatement(snapDir, name)
+	if err != nil {
+		return fmt.Errorf("signed metadata is missing: %v", err)
+	}
+	if !ed25519.Verify(key, statement, signature) {
+		return fmt.Errorf("signature does not match the catalog and hashes")
+	}
+	return nil
+}
+
+// mustBeSigned returns the snapshots expected to carry a signature: all
+// from the oldest signed one on, as older ones were created before
+// signing_key was set, and all a signed history line records as created,
+// so a removed signature does not pass for an old snapshot.
+func (b *Backup) mustBeSigned(key ed25519.PublicKey, snapshots []string) map[string]bool {
+	signed := make(map[string]bool)
+	if b.config.HistoryFile != "" {
+		_, signed, _ = b.checkHistorySignatures(key)
+	}
+	first := ""
+	for _, name := range snapshots {
+		if _, err := os.Stat(filepath.Join(b.config.Destination, name, SnapshotMetaDir, SignatureFile)); err == nil {
+			first = name
+			break
+		}
+	}
+	for _, name := range snapshots {
+		if first != "" && name >= first {
+			signed[name] = true
+		}
+	}
+	return signed
+}
+
+// verifySignature checks the signature of a snapshot and compares its files
+// with the signed content hashes. It returns every problem found.
+func (b *Backup) verifySignature(name string) ([]string, error) {
+	key, err := b.loadPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := b.listSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	if !b.mustBeSigned(key, snapshots)[name] {
+		return nil, nil
+	}
+	if err := b.checkSnapshotSignature(key, name); err != nil {
+		return []string{name + ": " + err.Error()}, nil
+	}
+	hashes, err := b.loadHashes(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	snapDir := filepath.Join(b.config.Destination, name)
+	for path, want := range hashes {
+		digest, err := fileDigest(filepath.Join(snapDir, path))
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s: cannot read: %v", path, err))
+		case digest != want:
+			problems = append(problems, path+": content differs from the signed hash")
+		}
+	}
+	return problems, nil
+}
+
+// signHistoryLine returns the signature field of a history line. Each
+// signature also covers the signature of the line before, so removed or
+// reordered lines break the chain.
+func (b *Backup) signHistoryLine(line string) (string, error) {
+	key, err := b.loadSigningKey()
+	if err != nil {
+		return "", err
+	}
+	previous := ""
+	if data, err := os.ReadFile(b.config.HistoryFile); err == nil {
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		previous = parseHistoryLine(lines[len(lines)-1])["sig"]
+	}
+	signature := ed25519.Sign(key, []byte(previous+"\n"+line))
+	return "sig=" + base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// checkHistorySignatures checks the signature chain of history_file and
+// returns the snapshots validly signed lines record as created. Lines
+// before the first signed one were written before signing_key was set.
+// Lines cut off at the end of the file cannot be detected.
+func (b *Backup) checkHistorySignatures(key ed25519.PublicKey) ([]string, map[string]bool, error) {
+	created := make(map[string]bool)
+	data, err := os.ReadFile(b.config.HistoryFile)
+	if os.IsNotExist(err) {
+		return nil, created, nil
+	} else if err != nil {
+		return nil, created, err
+	}
+
+	var problems []string
+	previous, signed := "", false
+	for i, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		// The signature is always the last field
+		idx := strings.LastIndex(line, " sig=")
+		if idx < 0 {
+			if signed {
+				problems = append(problems, fmt.Sprintf("history line %d: not signed", i+1))
+			}
+			continue
+		}
+		text, encoded := line[:idx], line[idx+len(" sig="):]
+		signed = true
+		signature, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || !ed25519.Verify(key, []byte(previous+"\n"+text), signature) {
+			problems = append(problems, fmt.Sprintf("history line %d: invalid signature (changed, inserted or a line before it removed)", i+1))
+		} else if fields := parseHistoryLine(text); fields["status"] == "ok" {
+			created[fields["snapshot"]] = true
+		}
+		previous = encoded
+	}
+	return problems, created, nil
+}
+
+// runKeygen creates a key pair for signing_key: the secret key and the
+// public key in a .pub file next to it.
+func (b *Backup) runKeygen(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	to := fs.String("to", "", "Secret key file to create; the public key is written to <file>.pub")
+	parseArgs(fs, args)
+
+	if *to == "" {
+		return fmt.Errorf("usage: keygen --to <file>")
+	}
+	if _, err := os.Stat(*to); err == nil {
+		return fmt.Errorf("%s already exists", *to)
+	}
+	public, secret, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(*to), 0700); err != nil {
+		return err
+	}
+	data := secretKeyComment + "\n" + base64.StdEncoding.EncodeToString(secret.Seed()) + "\n"
+	if err := os.WriteFile(*to, []byte(data), 0600); err != nil {
+		return err
+	}
+	data = publicKeyComment + "\n" + base64.StdEncoding.EncodeToString(public) + "\n"
+	if err := os.WriteFile(*to+".pub", []byte(data), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Secret key: %s (set as signing_key)\nPublic key: %s (copy it somewhere the backup host cannot write)\n", *to, *to+".pub")
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+// inhibitSleep keeps the machine from sleeping while the backup runs, so a
+// laptop going to sleep does not leave an _INCOMPLETE snapshot behind. It
+// uses caffeinate on macOS and systemd-inhibit on Linux; both helpers watch
+// our PID and end with the process even if it crashes. The returned
+// function releases the assertion.
+func (b *Backup) inhibitSleep() func() {
+	pid := strconv.Itoa(os.Getpid())
+
+	var cmd *exec.Cmd
+	switch {
+	case runtime.GOOS == "darwin" && commandExists("caffeinate"):
+		cmd = exec.Command("caffeinate", "-i", "-s", "-w", pid)
+	case runtime.GOOS == "linux" && commandExists("systemd-inhibit"):
+		cmd = exec.Command("systemd-inhibit", "--what=sleep:idle", "--who="+AppName,
+			"--why=Backup running", "--mode=block", "tail", "--pid="+pid, "-f", "/dev/null")
+	default:
+		return func() {}
+	}
+
+	if err := cmd.Start(); err != nil {
+		b.log("Warning: failed to prevent sleep: %v", err)
+		return func() {}
+	}
+	b.log("Preventing system sleep during the backup (%s)", cmd.Args[0])
+	return func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+}
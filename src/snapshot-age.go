@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// newestSnapshotAge returns the name and age of the snapshot the latest link
+// points to. Works for local and remote destinations. A snapshot the source
+// was last found unchanged against (skip_unchanged) is as old as that run.
+func (b *Backup) newestSnapshotAge() (string, time.Duration, error) {
+	name := b.getLastBackup()
+	if name == "(none)" {
+		return "", 0, fmt.Errorf("no successful snapshot found in %s", b.config.Destination)
+	}
+	t, err := snapshotTime(name)
+	if err != nil {
+		return name, 0, fmt.Errorf("cannot determine age of snapshot %s: %v", name, err)
+	}
+	if confirmed := b.lastUnchanged(name); confirmed.After(t) {
+		t = confirmed
+	}
+	return name, time.Since(t), nil
+}
+
+// checkSnapshotAge fails if the newest successful snapshot is older than
+// max_snapshot_age.
+func (b *Backup) checkSnapshotAge() error {
+	if b.config.MaxSnapshotAge == "" {
+		return nil
+	}
+	maxAge, err := ParseDuration(b.config.MaxSnapshotAge)
+	if err != nil {
+		return err
+	}
+
+	name, age, err := b.newestSnapshotAge()
+	if err != nil {
+		return err
+	}
+	if age > maxAge {
+		return fmt.Errorf("newest snapshot %s is %s old (max_snapshot_age: %s)", name, age.Round(time.Minute), b.config.MaxSnapshotAge)
+	}
+	b.log("Newest snapshot %s is %s old (max_snapshot_age: %s)", name, age.Round(time.Minute), b.config.MaxSnapshotAge)
+	return nil
+}
+
+// runCheckAge is meant to be called from an independent cron job or
+// monitoring agent: it exits non-zero when backups stopped happening.
+func (b *Backup) runCheckAge(args []string) error {
+	if b.config.MaxSnapshotAge == "" {
+		return fmt.Errorf("max_snapshot_age is not configured")
+	}
+	return b.checkSnapshotAge()
+}
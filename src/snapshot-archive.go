@@ -0,0 +1,229 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// archiveCompressor returns the external compressor for an archive file name
+// based on its extension, or nil for an uncompressed tar archive.
+func archiveCompressor(filename string) ([]string, error) {
+	switch {
+	case strings.HasSuffix(filename, ".tar.zst"), strings.HasSuffix(filename, ".tzst"):
+		return []string{"zstd", "-T0", "-q"}, nil
+	case strings.HasSuffix(filename, ".tar.gz"), strings.HasSuffix(filename, ".tgz"):
+		return []string{"gzip"}, nil
+	case strings.HasSuffix(filename, ".tar"):
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported archive type %s (use .tar.zst, .tar.gz or .tar)", filename)
+}
+
+// tarMetadataArgs returns the tar flags needed to preserve ownership, ACLs,
+// extended attributes and (on macOS) file flags.
+func tarMetadataArgs() []string {
+	args := []string{"--numeric-owner", "--acls", "--xattrs"}
+	if runtime.GOOS == "darwin" {
+		args = append(args, "--fflags") // bsdtar
+	}
+	return args
+}
+
+// resolveSnapshot returns the path of a snapshot in the local repository.
+// The name "latest" refers to the target of the latest link.
+func (b *Backup) resolveSnapshot(name string) (string, error) {
+	if b.isSSHPath(b.config.Destination) {
+		return "", fmt.Errorf("this command requires a local repository")
+	}
+	if err := b.checkRepositoryAccess(); err != nil {
+		return "", err
+	}
+	if name == "latest" {
+		name = b.getLastBackup()
+		if name == "(none)" {
+			return "", fmt.Errorf("no latest snapshot found in %s", b.config.Destination)
+		}
+	}
+	if name == "" || strings.ContainsRune(name, filepath.Separator) {
+		return "", fmt.Errorf("invalid snapshot name %q", name)
+	}
+
+	path := filepath.Join(b.config.Destination, name)
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("snapshot %s not found in %s", name, b.config.Destination)
+	}
+	return path, nil
+}
+
+// runExport writes a snapshot into a portable tar archive.
+func (b *Backup) runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	to := fs.String("to", "", "Archive file to create (.tar.zst, .tar.gz or .tar)")
+	positional := parseArgs(fs, args)
+
+	if len(positional) != 1 || *to == "" {
+		return fmt.Errorf("usage: export <snapshot|latest> --to <file>")
+	}
+	snapshot, err := b.resolveSnapshot(positional[0])
+	if err != nil {
+		return err
+	}
+	compressor, err := archiveCompressor(*to)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(*to); err == nil {
+		return fmt.Errorf("archive %s already exists", *to)
+	}
+
+	out, err := os.Create(*to)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %v", err)
+	}
+	defer out.Close()
+
+	// The archive contains the snapshot directory itself so that import
+	// can restore it under its original name
+	tarArgs := append([]string{"-c", "-f", "-"}, tarMetadataArgs()...)
+	tarArgs = append(tarArgs, "-C", filepath.Dir(snapshot), filepath.Base(snapshot))
+
+	b.log("Exporting snapshot %s to %s", filepath.Base(snapshot), *to)
+	if err := runPipeline(exec.Command("tar", tarArgs...), compressor, nil, out); err != nil {
+		os.Remove(*to)
+		return err
+	}
+
+	b.log("Export completed: %s", *to)
+	return nil
+}
+
+// runImport extracts a snapshot archive created by export into the repository.
+func (b *Backup) runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	positional := parseArgs(fs, args)
+
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: import <file>")
+	}
+	if b.isSSHPath(b.config.Destination) {
+		return fmt.Errorf("import requires a local repository")
+	}
+	name, err := b.importArchive(positional[0])
+	if err != nil {
+		return err
+	}
+	b.log("Imported snapshot: %s", name)
+	return nil
+}
+
+// importArchive extracts a snapshot archive into the local repository and
+// returns the name of the snapshot.
+func (b *Backup) importArchive(archive string) (string, error) {
+	compressor, err := archiveCompressor(archive)
+	if err != nil {
+		return "", err
+	}
+
+	in, err := os.Open(archive)
+	if err != nil {
+		return "", fmt.Errorf("failed to open archive: %v", err)
+	}
+	defer in.Close()
+
+	// Extract into a staging directory first so an interrupted import is
+	// never mistaken for a finished snapshot
+	staging := filepath.Join(b.config.Destination, "import_"+b.timestamp+"_INCOMPLETE")
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %v", err)
+	}
+	defer os.RemoveAll(staging)
+
+	var decompressor []string
+	if compressor != nil {
+		decompressor = append(compressor, "-d", "-c")
+	}
+	tarArgs := append([]string{"-x", "-p", "-f", "-"}, tarMetadataArgs()...)
+	tarArgs = append(tarArgs, "-C", staging)
+
+	b.log("Importing %s", archive)
+	if err := runPipeline(exec.Command("tar", tarArgs...), decompressor, in, nil); err != nil {
+		return "", err
+	}
+
+	entries, err := os.ReadDir(staging)
+	if err != nil {
+		return "", fmt.Errorf("failed to read staging directory: %v", err)
+	}
+	if len(entries) != 1 || !entries[0].IsDir() {
+		return "", fmt.Errorf("archive does not contain exactly one snapshot directory")
+	}
+
+	name := entries[0].Name()
+	finalDir := filepath.Join(b.config.Destination, name)
+	if _, err := os.Stat(finalDir); err == nil {
+		return "", fmt.Errorf("snapshot %s already exists in %s", name, b.config.Destination)
+	}
+	if err := os.Rename(filepath.Join(staging, name), finalDir); err != nil {
+		return "", fmt.Errorf("failed to move imported snapshot: %v", err)
+	}
+
+	return name, nil
+}
+
+// runPipeline runs tar together with an optional (de)compressor. When
+// compressing, tar writes into the compressor which writes to out; when
+// decompressing, the decompressor reads from in and feeds tar.
+func runPipeline(tar *exec.Cmd, compressor []string, in io.Reader, out io.Writer) error {
+	tar.Stderr = os.Stderr
+	if compressor == nil {
+		tar.Stdin = in
+		tar.Stdout = out
+		if err := tar.Run(); err != nil {
+			return fmt.Errorf("tar failed: %v", err)
+		}
+		return nil
+	}
+
+	comp := exec.Command(compressor[0], compressor[1:]...)
+	comp.Stderr = os.Stderr
+
+	var first, second *exec.Cmd
+	if out != nil {
+		first, second = tar, comp
+		comp.Stdout = out
+	} else {
+		first, second = comp, tar
+		comp.Stdin = in
+	}
+
+	pipe, err := first.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	second.Stdin = pipe
+
+	if err := first.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %v", first.Path, err)
+	}
+	if err := second.Start(); err != nil {
+		first.Process.Kill()
+		first.Wait()
+		return fmt.Errorf("failed to start %s: %v", second.Path, err)
+	}
+
+	firstErr := first.Wait()
+	secondErr := second.Wait()
+	if firstErr != nil {
+		return fmt.Errorf("%s failed: %v", filepath.Base(first.Path), firstErr)
+	}
+	if secondErr != nil {
+		return fmt.Errorf("%s failed: %v", filepath.Base(second.Path), secondErr)
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// parseBackupArgs reads the options of the backup command, which runs a
+// backup like no command at all, and reports whether --dry-run was given.
+func parseBackupArgs(args []string) (bool, error) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Perform a dry run (no changes)")
+	if positional := parseArgs(fs, args); len(positional) > 0 {
+		return false, fmt.Errorf("usage: backup [--dry-run]")
+	}
+	return *dryRun, nil
+}
+
+// runList lists the snapshots of this job, oldest first, with the latest
+// one and unfinished ones marked.
+func (b *Backup) runList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	parseArgs(fs, args)
+
+	if b.isSSHPath(b.config.Destination) {
+		return fmt.Errorf("list requires a local repository")
+	}
+	if err := b.checkRepositoryAccess(); err != nil {
+		return err
+	}
+	snapshots, err := b.listSnapshots()
+	if err != nil {
+		return err
+	}
+	state := make(map[string]string)
+	if latest := b.readLatestLink(); latest != "" {
+		state[latest] = "latest"
+	}
+	partial, _ := b.listPartial()
+	incomplete, _ := b.listIncomplete()
+	for _, name := range slices.Concat(partial, incomplete) {
+		snapshots = append(snapshots, name)
+		state[name] = "partial, continued by the next run"
+		if strings.HasSuffix(name, "_INCOMPLETE") {
+			state[name] = "incomplete (running or interrupted)"
+		}
+	}
+	if len(snapshots) == 0 {
+		fmt.Printf("No snapshots in %s\n", b.config.Destination)
+		return nil
+	}
+	sortSnapshots(snapshots)
+
+	fmt.Printf("%-40s %-20s %12s  %s\n", "SNAPSHOT", "CREATED", "AGE", "STATE")
+	for _, name := range snapshots {
+		created, age := "", ""
+		base := strings.TrimSuffix(strings.TrimSuffix(name, "_INCOMPLETE"), PartialSuffix)
+		if t, err := snapshotTime(base); err == nil {
+			created = t.Format("2006-01-02 15:04:05")
+			age = time.Since(t).Round(time.Minute).String()
+		}
+		fmt.Printf("%-40s %-20s %12s  %s\n", name, created, age, state[name])
+	}
+
+	var notes []string
+	if entries, err := os.ReadDir(filepath.Join(b.config.Destination, QuarantineDir)); err == nil {
+		count := 0
+		for _, entry := range entries {
+			if entry.IsDir() {
+				count++
+			}
+		}
+		if count > 0 {
+			notes = append(notes, fmt.Sprintf("%d quarantined", count))
+		}
+	}
+	if trashed := b.listTrash(); len(trashed) > 0 {
+		notes = append(notes, fmt.Sprintf("%d pruned and waiting in the trash", len(trashed)))
+	}
+	fmt.Printf("\n%d snapshots", len(snapshots))
+	if len(notes) > 0 {
+		fmt.Printf(" (not listed: %s)", strings.Join(notes, ", "))
+	}
+	fmt.Println()
+	return nil
+}
+
+// runPrune applies the retention settings (keep, thinning,
+// max_repository_size, min_free_space) and deletes what is due from the
+// trash, as a backup run does after its transfer.
+func (b *Backup) runPrune(args []string) error {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Show which snapshots would be removed without removing any")
+	parseArgs(fs, args)
+
+	if b.isSSHPath(b.config.Destination) {
+		return fmt.Errorf("prune requires a local repository")
+	}
+	if err := b.checkRepositoryAccess(); err != nil {
+		return err
+	}
+	if *dryRun {
+		b.config.DryRun = true
+	} else {
+		// A backup must not link against a snapshot while it is removed
+		if err := b.createLock(); err != nil {
+			return err
+		}
+		defer b.removeLock()
+		if err := b.setupLogging(); err != nil {
+			return err
+		}
+		defer b.closeLogging()
+	}
+	unlock, err := b.lockRepository(!*dryRun, true)
+	if err != nil {
+		return fmt.Errorf("failed to lock repository: %v", err)
+	}
+	defer unlock()
+
+	if err := b.cleanupOldBackups(); err != nil {
+		return err
+	}
+	b.purgeTrash()
+
+	switch {
+	case b.config.DryRun:
+		b.log("Prune dry run: %d snapshots would be removed", b.pruned)
+	case b.reclaimed > 0:
+		b.log("Pruned %d snapshots, reclaimed %.2f GB", b.pruned, gib(b.reclaimed))
+	default:
+		b.log("Pruned %d snapshots", b.pruned)
+	}
+	return nil
+}
+
+// verifySnapshot compares a snapshot with the catalog written when it was
+// created and returns every path that went missing, appeared or changed
+// its type, permissions, size or modification time since.
+func (b *Backup) verifySnapshot(name string) ([]string, error) {
+	snapDir := filepath.Join(b.config.Destination, name)
+	if _, err := os.Stat(filepath.Join(snapDir, SnapshotMetaDir, CatalogFile)); err != nil {
+		return nil, fmt.Errorf("%s has no catalog to verify against", name)
+	}
+	catalog, err := b.loadCatalog(name)
+	if err != nil {
+		return nil, err
+	}
+	current, err := buildCatalog(snapDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk snapshot: %v", err)
+	}
+
+	expected := make(map[string]CatalogEntry, len(catalog))
+	for _, e := range catalog {
+		expected[e.Path] = e
+	}
+	var problems []string
+	for _, e := range current {
+		want, ok := expected[e.Path]
+		delete(expected, e.Path)
+		switch {
+		case !ok:
+			problems = append(problems, e.Path+": not in the catalog")
+		case e.Mode != want.Mode:
+			problems = append(problems, fmt.Sprintf("%s: mode %s, catalog %s", e.Path, e.Mode, want.Mode))
+		case e.Mode.IsDir():
+		case e.Size != want.Size:
+			problems = append(problems, fmt.Sprintf("%s: size %d, catalog %d", e.Path, e.Size, want.Size))
+		case !e.MTime.Equal(want.MTime):
+			problems = append(problems, fmt.Sprintf("%s: modified %s, catalog %s", e.Path, e.MTime.Format(time.RFC3339), want.MTime.Format(time.RFC3339)))
+		}
+	}
+	for path := range expected {
+		problems = append(problems, path+": missing")
+	}
+	slices.Sort(problems)
+	return problems, nil
+}
+
+// runVerify checks that a snapshot is still what the backup wrote and
+// optionally compares a sample of its files with the live source.
+func (b *Backup) runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	sample := fs.Int("source-sample", 0, "Also compare this many random files with the live source (size, mtime, SHA-256)")
+	positional := parseArgs(fs, args)
+
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: verify <snapshot|latest> [--source-sample N]")
+	}
+	path, err := b.resolveSnapshot(positional[0])
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(path)
+
+	problems, err := b.verifySnapshot(name)
+	if err != nil {
+		return err
+	}
+	const shown = 50
+	for i, problem := range problems {
+		if i == shown {
+			fmt.Printf("... and %d more\n", len(problems)-shown)
+			break
+		}
+		fmt.Println(problem)
+	}
+	fmt.Printf("%s: %d differences from the catalog\n", name, len(problems))
+
+	if b.publicKeyPath() != "" {
+		tampered, err := b.verifySignature(name)
+		if err != nil {
+			return err
+		}
+		for i, problem := range tampered {
+			if i == shown {
+				fmt.Printf("... and %d more\n", len(tampered)-shown)
+				break
+			}
+			fmt.Println(problem)
+		}
+		fmt.Printf("%s: %d differences from the signed manifest\n", name, len(tampered))
+		problems = append(problems, tampered...)
+	}
+
+	mismatches := 0
+	if *sample > 0 {
+		if b.hasSSHSource() {
+			return fmt.Errorf("--source-sample requires a local source")
+		}
+		// Files modified in the source after the snapshot are skipped
+		b.snapDir = path
+		if t, err := snapshotTime(name); err == nil {
+			b.started = t
+		}
+		checked, skipped, diffs := b.compareWithSource(b.sampleFiles(path, *sample), true)
+		for _, diff := range diffs {
+			fmt.Println(diff)
+		}
+		fmt.Printf("Source sample: %d files checked, %d changed in the source since, %d mismatches\n", checked, skipped, len(diffs))
+		mismatches = len(diffs)
+	}
+
+	if len(problems) > 0 || mismatches > 0 {
+		return fmt.Errorf("snapshot %s failed verification", name)
+	}
+	return nil
+}
+
+// runStatus shows the state of the job: whether a backup is running, the
+// last run, the latest snapshot and the space left on the destination.
+func (b *Backup) runStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	parseArgs(fs, args)
+
+	fmt.Printf("Destination:     %s\n", b.config.Destination)
+	running := "no"
+	if info, err := os.Stat(b.config.LockFile); err == nil {
+		running = fmt.Sprintf("yes, since %s (lock %s)", info.ModTime().Format("2006-01-02 15:04:05"), b.config.LockFile)
+	}
+	fmt.Printf("Running:         %s\n", running)
+
+	if runs, err := b.readHistory(1); err == nil && len(runs) > 0 {
+		last := runs[0]
+		line := fmt.Sprintf("%s at %s (%s, %s GB)", last["status"], last["time"], last["duration"], last["transferred_gb"])
+		if last["error"] != "" {
+			line += ": " + last["error"]
+		}
+		fmt.Printf("Last run:        %s\n", line)
+	}
+
+	if name, age, err := b.newestSnapshotAge(); err != nil {
+		fmt.Printf("Latest snapshot: %v\n", err)
+	} else {
+		fmt.Printf("Latest snapshot: %s (%s old)\n", name, age.Round(time.Minute))
+	}
+
+	if !b.isSSHPath(b.config.Destination) {
+		if snapshots, err := b.listSnapshots(); err == nil {
+			fmt.Printf("Snapshots:       %d (keep %d)\n", len(snapshots), b.config.Keep)
+		}
+		if used, avail, _, err := b.destinationUsage(); err == nil {
+			fmt.Printf("Disk usage:      %.0f%% (%.2f GB free, backups refused at %d%%)\n",
+				float64(used)*100/float64(used+avail), gib(avail), b.config.CleanupAtPercent)
+		}
+	}
+
+	// Exit non-zero like check-age when backups stopped happening
+	return b.checkSnapshotAge()
+}
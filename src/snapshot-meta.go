@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Directory inside each snapshot that holds data about the snapshot itself
+const SnapshotMetaDir = ".go-rsync-backup"
+
+// SnapshotMeta describes how a snapshot was produced. It is stored as
+// snapshot.json in the snapshot's metadata directory.
+type SnapshotMeta struct {
+	Name             string    `json:"name"`
+	Source           string    `json:"source"`
+	Sources          []string  `json:"sources,omitempty"`
+	Hostname         string    `json:"hostname"`
+	Started          time.Time `json:"started"`
+	Finished         time.Time `json:"finished"`
+	RsyncBin         string    `json:"rsync_bin"`
+	RsyncVersion     string    `json:"rsync_version"`
+	TransferredGB    float64   `json:"transferred_gb"`
+	TransferredFiles int       `json:"transferred_files"`
+	AppVersion       string    `json:"app_version"`
+	Error            string    `json:"error,omitempty"` // Why rsync did not finish the snapshot
+}
+
+// writeSnapshotMeta stores the metadata of the current run in the snapshot.
+func (b *Backup) writeSnapshotMeta() error {
+	if b.config.DryRun {
+		return nil // Nothing was written for dry runs
+	}
+	return b.saveSnapshotMeta(b.snapDir, b.snapshotMeta())
+}
+
+// snapshotMeta returns the metadata of the current run.
+func (b *Backup) snapshotMeta() SnapshotMeta {
+	hostname, _ := os.Hostname()
+	return SnapshotMeta{
+		Name:             b.timestamp,
+		Source:           b.config.Source,
+		Sources:          b.config.Sources,
+		Hostname:         hostname,
+		Started:          b.started,
+		Finished:         time.Now(),
+		RsyncBin:         b.config.RsyncBin,
+		RsyncVersion:     b.rsyncVersion,
+		TransferredGB:    b.transferredGB,
+		TransferredFiles: b.sentFiles,
+		AppVersion:       AppVersion,
+	}
+}
+
+// saveSnapshotMeta writes snapshot.json into the metadata directory of the
+// given snapshot directory.
+func (b *Backup) saveSnapshotMeta(snapDir string, meta SnapshotMeta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return b.writeMetaFile(snapDir, "snapshot.json", data)
+}
+
+// writeMetaFile writes a file into the metadata directory of the given
+// snapshot directory, on the remote host for remote destinations.
+func (b *Backup) writeMetaFile(snapDir, filename string, data []byte) error {
+	metaDir := filepath.Join(snapDir, SnapshotMetaDir)
+	if b.isSSHPath(b.config.Destination) {
+		host, path := splitSSHPath(metaDir)
+		cmd := exec.Command("ssh", append(b.sshArgs(), host,
+			"mkdir -p "+shellQuote(path)+" && cat > "+shellQuote(path+"/"+filename))...)
+		cmd.Stdin = strings.NewReader(string(data))
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to write remote %s: %v: %s", filename, err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(metaDir, 0755); err != nil {
+		return fmt.Errorf("failed to create metadata directory: %v", err)
+	}
+	return os.WriteFile(filepath.Join(metaDir, filename), data, 0644)
+}
+
+// writeRunLog stores the log of this run in the snapshot's metadata
+// directory, so it travels with the snapshot and survives log rotation.
+func (b *Backup) writeRunLog() {
+	if b.runLog == nil || b.config.DryRun {
+		return
+	}
+	if err := b.writeMetaFile(b.snapDir, "run.log", []byte(b.runLog.String())); err != nil {
+		b.log("Warning: failed to store run log in snapshot: %v", err)
+	}
+	b.runLog = nil // Written once
+}
+
+// readSnapshotMeta loads the metadata of a snapshot in the local repository.
+func (b *Backup) readSnapshotMeta(name string) (SnapshotMeta, error) {
+	var meta SnapshotMeta
+	data, err := os.ReadFile(filepath.Join(b.config.Destination, name, SnapshotMetaDir, "snapshot.json"))
+	if err != nil {
+		return meta, err
+	}
+	err = json.Unmarshal(data, &meta)
+	return meta, err
+}
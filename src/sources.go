@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sourcePaths returns the source paths of the run: the expanded sources once
+// expandSources ran, otherwise the configured source or list of sources.
+func (b *Backup) sourcePaths() []string {
+	if b.sources != nil {
+		return b.sources
+	}
+	if len(b.config.Sources) > 0 {
+		return b.config.Sources
+	}
+	return []string{b.config.Source}
+}
+
+// relativeSources reports whether sources are transferred with --relative,
+// i.e. each one keeps its full path inside the snapshot. This is the case
+// for a list of sources and for a single source given as a glob pattern.
+func (b *Backup) relativeSources() bool {
+	return len(b.config.Sources) > 0 || isGlob(b.config.Source)
+}
+
+func (b *Backup) hasSSHSource() bool {
+	for _, source := range b.sourcePaths() {
+		if b.isSSHPath(source) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourcePathFor maps a path relative to the snapshot root back to the
+// source it was copied from.
+func (b *Backup) sourcePathFor(rel string) string {
+	if b.relativeSources() {
+		return filepath.Join("/", rel) // Transferred with --relative
+	}
+	return filepath.Join(b.config.Source, rel)
+}
+
+// expandSources resolves glob patterns like /Users/*/Documents in local
+// source paths at run time, so new matches are picked up automatically.
+// Remote patterns are left to the remote shell.
+func (b *Backup) expandSources() error {
+	var patterns []string
+	if len(b.config.Sources) > 0 {
+		patterns = b.config.Sources
+	} else {
+		patterns = []string{b.config.Source}
+	}
+
+	var sources []string
+	for _, pattern := range patterns {
+		if b.isSSHPath(pattern) || !isGlob(pattern) {
+			sources = append(sources, pattern)
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid source pattern %s: %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			b.log("Warning: source pattern %s matches nothing", pattern)
+			continue
+		}
+		b.log("Source pattern %s matches: %s", pattern, strings.Join(matches, ", "))
+		sources = append(sources, matches...)
+	}
+
+	if len(sources) == 0 {
+		return fmt.Errorf("no source paths left after expanding patterns")
+	}
+	b.sources = sources
+	return nil
+}
+
+// checkSourcePopulated guards against sources that exist but are empty,
+// typically a mount point whose volume failed to mount. --delete would
+// otherwise propagate the emptiness into the new snapshot.
+func (b *Backup) checkSourcePopulated() error {
+	if b.hasSSHSource() {
+		return nil
+	}
+
+	if b.config.CanaryFile != "" {
+		for _, source := range b.sourcePaths() {
+			canary := b.config.CanaryFile
+			if !filepath.IsAbs(canary) {
+				canary = filepath.Join(source, canary)
+			}
+			if _, err := os.Stat(canary); err != nil {
+				return fmt.Errorf("canary file %s not found - is the source mounted?", canary)
+			}
+		}
+	}
+
+	if b.config.MinSourceFiles > 0 {
+		count := 0
+		for _, source := range b.sourcePaths() {
+			count += countFiles(source, b.config.MinSourceFiles-count)
+			if count >= b.config.MinSourceFiles {
+				return nil
+			}
+		}
+		return fmt.Errorf("source contains only %d files (min_source_files: %d) - is the source mounted?",
+			count, b.config.MinSourceFiles)
+	}
+	return nil
+}
+
+// countFiles counts regular files below dir, stopping once limit is reached.
+func countFiles(dir string, limit int) int {
+	count := 0
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			count++
+			if count >= limit {
+				return filepath.SkipAll
+			}
+		}
+		return nil
+	})
+	return count
+}
+
+// checkOverlap detects a destination inside a source, which is excluded
+// automatically, and a source inside the destination, which is refused.
+// Either would otherwise back up the backups and explode disk usage.
+func (b *Backup) checkOverlap() error {
+	if b.isSSHPath(b.config.Destination) {
+		return nil
+	}
+	dest := resolvePath(b.config.Destination)
+
+	for _, source := range b.sourcePaths() {
+		if b.isSSHPath(source) {
+			continue
+		}
+		src := resolvePath(source)
+
+		if isWithin(src, dest) {
+			return fmt.Errorf("source %s lies inside the destination %s", source, b.config.Destination)
+		}
+		if isWithin(dest, src) {
+			exclude := b.excludeWithin(source, src, dest)
+			b.autoExcludes = append(b.autoExcludes, exclude)
+			b.log("Destination lies inside source %s - excluding %s", source, exclude)
+		}
+	}
+	return nil
+}
+
+// excludeWithin returns the exclude rule for the directory path inside
+// source, of which src is the resolved path. Excludes are anchored at the
+// transfer root, which is / for relative transfers and the source directory
+// otherwise.
+func (b *Backup) excludeWithin(source, src, path string) string {
+	rel, _ := filepath.Rel(src, path)
+	if b.relativeSources() {
+		return filepath.Join(source, rel) + "/"
+	}
+	return "/" + rel + "/"
+}
+
+// resolvePath returns the absolute path with symlinks resolved, falling back
+// to the cleaned absolute path.
+func resolvePath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return filepath.Clean(path)
+}
+
+// isWithin reports whether path equals dir or lies below it.
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// dirStats is the share of a directory in the repository.
+type dirStats struct {
+	Dir     string
+	Logical int64 // Sum over all snapshots, as without hard links
+	Unique  int64 // In a single snapshot only, freed when it is pruned
+	Shared  int64 // Hard-linked between snapshots, counted once
+	Growth  int64 // Added by the snapshots after the oldest
+}
+
+// statsDir returns the directory a path is accounted to: its first depth
+// components, or its parent directory if it is less deep.
+func statsDir(path string, depth int) string {
+	parts := strings.Split(filepath.Dir(path), "/")
+	if parts[0] == "." {
+		return "./"
+	}
+	if len(parts) > depth {
+		parts = parts[:depth]
+	}
+	return strings.Join(parts, "/") + "/"
+}
+
+// measureDirStats reads the catalogs of all snapshots and accounts every
+// file's data to its directory. A file hard-linked from several
+// directories counts for the first one it was seen in.
+func (b *Backup) measureDirStats(snapshots []string, depth int) ([]*dirStats, error) {
+	type inodeInfo struct {
+		stats *dirStats
+		size  int64
+		count int // Number of snapshots holding the inode
+	}
+	dirs := make(map[string]*dirStats)
+	inodes := make(map[uint64]*inodeInfo)
+	var previous map[uint64]bool
+
+	for i, name := range snapshots {
+		entries, err := b.loadCatalog(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read catalog of %s: %v", name, err)
+		}
+		current := make(map[uint64]bool, len(entries))
+		for _, e := range entries {
+			if e.Mode.IsDir() || current[e.Inode] || strings.HasPrefix(e.Path, SnapshotMetaDir+"/") {
+				continue
+			}
+			current[e.Inode] = true
+
+			info := inodes[e.Inode]
+			if info == nil {
+				dir := statsDir(e.Path, depth)
+				if dirs[dir] == nil {
+					dirs[dir] = &dirStats{Dir: dir}
+				}
+				info = &inodeInfo{stats: dirs[dir], size: e.Size}
+				inodes[e.Inode] = info
+			}
+			info.count++
+			info.stats.Logical += info.size
+			if i > 0 && !previous[e.Inode] {
+				info.stats.Growth += info.size
+			}
+		}
+		previous = current
+	}
+
+	for _, info := range inodes {
+		if info.count == 1 {
+			info.stats.Unique += info.size
+		} else {
+			info.stats.Shared += info.size
+		}
+	}
+	var stats []*dirStats
+	for _, s := range dirs {
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
+
+// runStats shows per directory how much data the repository holds, how
+// much of it is shared between snapshots and which directories account for
+// the growth, to find candidates for excludes or shorter retention.
+func (b *Backup) runStats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	depth := fs.Int("depth", 1, "Directory levels to break the data down by")
+	top := fs.Int("top", 20, "Number of directories to list")
+	parseArgs(fs, args)
+
+	if *depth < 1 {
+		return fmt.Errorf("--depth must be at least 1")
+	}
+	if b.isSSHPath(b.config.Destination) {
+		return fmt.Errorf("stats requires a local repository")
+	}
+	snapshots, err := b.listSnapshots()
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		return fmt.Errorf("no snapshots found in %s", b.config.Destination)
+	}
+	stats, err := b.measureDirStats(snapshots, *depth)
+	if err != nil {
+		return err
+	}
+
+	total := dirStats{Dir: "Total"}
+	for _, s := range stats {
+		total.Logical += s.Logical
+		total.Unique += s.Unique
+		total.Shared += s.Shared
+		total.Growth += s.Growth
+	}
+	slices.SortFunc(stats, func(x, y *dirStats) int {
+		if c := cmp.Compare(y.Growth, x.Growth); c != 0 {
+			return c
+		}
+		return cmp.Compare(y.Unique+y.Shared, x.Unique+x.Shared)
+	})
+
+	fmt.Printf("\n%d snapshots, %.2f GB on disk (%.2f GB without hard links)\n\n",
+		len(snapshots), gib(total.Unique+total.Shared), gib(total.Logical))
+	fmt.Printf("%-40s %10s %10s %10s %7s %10s %7s\n", "DIRECTORY", "DISK GB", "UNIQUE GB", "SHARED GB", "DEDUP", "GROWTH GB", "GROWTH")
+	printRow := func(s *dirStats) {
+		dedup, share := 0.0, 0.0
+		if disk := s.Unique + s.Shared; disk > 0 {
+			dedup = float64(s.Logical) / float64(disk)
+		}
+		if total.Growth > 0 {
+			share = float64(s.Growth) * 100 / float64(total.Growth)
+		}
+		fmt.Printf("%-40s %10.2f %10.2f %10.2f %6.1fx %10.2f %6.1f%%\n",
+			s.Dir, gib(s.Unique+s.Shared), gib(s.Unique), gib(s.Shared), dedup, gib(s.Growth), share)
+	}
+	for i, s := range stats {
+		if i == *top {
+			fmt.Printf("... %d more directories\n", len(stats)-*top)
+			break
+		}
+		printRow(s)
+	}
+	printRow(&total)
+
+	fmt.Println("\nUNIQUE is data in a single snapshot, freed when that snapshot is pruned.")
+	fmt.Println("SHARED is hard-linked between snapshots and counted once. GROWTH is the data")
+	fmt.Println("the snapshots after the oldest added, i.e. what each run costs on disk.")
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// File in the snapshot's metadata directory describing the backed up system
+const SysinfoFile = "sysinfo.txt"
+
+// sysinfoProbe is a command whose output goes into the system manifest.
+type sysinfoProbe struct {
+	Title   string
+	Command []string
+}
+
+// sysinfoProbes returns the commands describing this system: disk layout,
+// mounts, installed packages and enabled services. Commands that do not
+// exist on this system are skipped.
+func sysinfoProbes() []sysinfoProbe {
+	if runtime.GOOS == "darwin" {
+		// brew refuses to run as root, so installed formulae and casks are
+		// listed from the Homebrew prefixes directly
+		return []sysinfoProbe{
+			{"Operating system", []string{"sw_vers"}},
+			{"Hardware", []string{"system_profiler", "SPHardwareDataType"}},
+			{"Disk layout", []string{"diskutil", "list"}},
+			{"APFS containers", []string{"diskutil", "apfs", "list"}},
+			{"Mounts", []string{"mount"}},
+			{"Homebrew formulae", []string{"ls", "-1", "/opt/homebrew/Cellar", "/usr/local/Cellar"}},
+			{"Homebrew casks", []string{"ls", "-1", "/opt/homebrew/Caskroom", "/usr/local/Caskroom"}},
+			{"Applications", []string{"ls", "-1", "/Applications"}},
+			{"Launch daemons", []string{"ls", "-1", "/Library/LaunchDaemons", "/Library/LaunchAgents"}},
+		}
+	}
+	return []sysinfoProbe{
+		{"Operating system", []string{"cat", "/etc/os-release"}},
+		{"Kernel", []string{"uname", "-a"}},
+		{"Disk layout", []string{"lsblk", "-o", "NAME,SIZE,TYPE,FSTYPE,UUID,LABEL,MOUNTPOINTS"}},
+		{"Partition tables", []string{"fdisk", "-l"}},
+		{"LVM", []string{"lvs", "-o", "vg_name,lv_name,lv_size,devices"}},
+		{"fstab", []string{"cat", "/etc/fstab"}},
+		{"Mounts", []string{"findmnt", "--real"}},
+		{"Debian packages", []string{"dpkg-query", "-W", "-f", "${Package} ${Version}\\n"}},
+		{"RPM packages", []string{"rpm", "-qa"}},
+		{"Pacman packages", []string{"pacman", "-Q"}},
+		{"Snap packages", []string{"snap", "list"}},
+		{"Flatpak applications", []string{"flatpak", "list", "--app"}},
+		{"Enabled services", []string{"systemctl", "list-unit-files", "--state=enabled", "--no-pager"}},
+	}
+}
+
+// writeSysinfo stores a manifest of the backed up system in the snapshot, so
+// a rebuilt machine can be set up like the old one before the data is
+// restored.
+func (b *Backup) writeSysinfo() error {
+	if !b.config.SystemManifest || b.config.DryRun || b.hasSSHSource() {
+		return nil // The manifest describes this machine, not a remote source
+	}
+
+	var manifest strings.Builder
+	hostname, _ := os.Hostname()
+	fmt.Fprintf(&manifest, "System manifest of %s taken %s\n", hostname, b.started.Format("2006-01-02 15:04:05"))
+
+	sections := 0
+	for _, probe := range sysinfoProbes() {
+		if !commandExists(probe.Command[0]) {
+			continue
+		}
+		// Output of partially failing commands (e.g. one of two ls paths
+		// missing) is still useful
+		output, _ := exec.Command(probe.Command[0], probe.Command[1:]...).Output()
+		if len(strings.TrimSpace(string(output))) == 0 {
+			continue
+		}
+		fmt.Fprintf(&manifest, "\n== %s (%s) ==\n%s", probe.Title, strings.Join(probe.Command, " "), output)
+		if !strings.HasSuffix(string(output), "\n") {
+			manifest.WriteString("\n")
+		}
+		sections++
+	}
+
+	if err := b.writeMetaFile(b.snapDir, SysinfoFile, []byte(manifest.String())); err != nil {
+		return err
+	}
+	b.log("System manifest recorded: %d sections", sections)
+	return nil
+}
+
+// runSysinfo prints the system manifest stored in a snapshot.
+func (b *Backup) runSysinfo(args []string) error {
+	fs := flag.NewFlagSet("sysinfo", flag.ExitOnError)
+	positional := parseArgs(fs, args)
+
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: sysinfo <snapshot|latest>")
+	}
+	snapshot, err := b.resolveSnapshot(positional[0])
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filepath.Join(snapshot, SnapshotMetaDir, SysinfoFile))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("snapshot %s has no system manifest (enable system_manifest)", filepath.Base(snapshot))
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(data))
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Suffix of a snapshot whose transfer was stopped at max_transfer_per_run or
+// max_run_time. The next run continues it instead of starting over.
+const PartialSuffix = "_PARTIAL"
+
+// transferMeter counts the bytes rsync reports in its --progress output and
+// calls stop once the limit is reached.
+type transferMeter struct {
+	limit     int64
+	completed atomic.Int64 // Bytes of finished files
+	current   atomic.Int64 // Bytes of the file in transfer
+	pending   []byte
+	stop      func()
+	stopped   atomic.Bool
+}
+
+func (m *transferMeter) Write(p []byte) (int, error) {
+	m.pending = append(m.pending, p...)
+	for {
+		idx := bytes.IndexAny(m.pending, "\r\n")
+		if idx < 0 {
+			break
+		}
+		m.parseLine(string(m.pending[:idx]))
+		m.pending = m.pending[idx+1:]
+	}
+	if m.transferred() >= m.limit && !m.stopped.Swap(true) {
+		m.stop()
+	}
+	return len(p), nil
+}
+
+// transferred returns the bytes sent so far.
+func (m *transferMeter) transferred() int64 {
+	return m.completed.Load() + m.current.Load()
+}
+
+// parseLine reads a progress line like "  1,234,567  42%  10.00MB/s  0:00:01",
+// which ends with "(xfr#3, to-chk=12/40)" once the file is complete.
+func (m *transferMeter) parseLine(line string) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !strings.HasSuffix(fields[1], "%") {
+		return
+	}
+	n, err := strconv.ParseInt(strings.NewReplacer(",", "", ".", "").Replace(fields[0]), 10, 64)
+	if err != nil {
+		return
+	}
+	if strings.Contains(line, "xfr#") || strings.Contains(line, "xfer#") {
+		m.completed.Add(n)
+		m.current.Store(0)
+	} else {
+		m.current.Store(n)
+	}
+}
+
+// transferLimit returns the transfer limit of this run and the option it
+// comes from: max_transfer_per_run, or seed_transfer_cap while seeding if
+// that is lower. The limit is 0 if none applies.
+func (b *Backup) transferLimit() (string, int64) {
+	name, limit := "", int64(0)
+	if size, err := ParseSize(b.config.MaxTransferPerRun); err == nil && size > 0 {
+		name, limit = "max_transfer_per_run "+b.config.MaxTransferPerRun, size
+	}
+	if !b.seeding {
+		return name, limit
+	}
+	if size, err := ParseSize(b.config.SeedTransferCap); err == nil && size > 0 && (limit == 0 || size < limit) {
+		name, limit = "seed_transfer_cap "+b.config.SeedTransferCap, size
+	}
+	return name, limit
+}
+
+// newTransferMeter returns a meter that stops the given rsync process once
+// the transfer limit is reached, or nil if no limit applies. While seeding
+// there is always a meter, it also reports the progress.
+func (b *Backup) newTransferMeter(stop func()) *transferMeter {
+	if b.config.DryRun {
+		return nil
+	}
+	_, limit := b.transferLimit()
+	if limit <= 0 {
+		if !b.seeding {
+			return nil
+		}
+		limit = math.MaxInt64
+	}
+	return &transferMeter{limit: limit, stop: stop}
+}
+
+// listPartial returns the names of this job's snapshots stopped by a
+// transfer limit, oldest first.
+func (b *Backup) listPartial() ([]string, error) {
+	var names []string
+	if b.isSSHPath(b.config.Destination) {
+		host, path := splitSSHPath(b.config.Destination)
+		output, err := b.runRemote(host, "find "+shellQuote(path)+" -mindepth 1 -maxdepth 1 -type d")
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(output, "\n") {
+			names = append(names, filepath.Base(strings.TrimSpace(line)))
+		}
+	} else {
+		entries, err := os.ReadDir(b.config.Destination)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				names = append(names, entry.Name())
+			}
+		}
+	}
+
+	var partial []string
+	for _, name := range names {
+		if strings.HasSuffix(name, PartialSuffix) && b.ownSnapshot(strings.TrimSuffix(name, PartialSuffix)) {
+			partial = append(partial, name)
+		}
+	}
+	sortSnapshots(partial)
+	return partial, nil
+}
+
+// moveSnapshotDir renames a directory in the repository, locally or on the
+// remote host.
+func (b *Backup) moveSnapshotDir(from, to string) error {
+	if b.isSSHPath(b.config.Destination) {
+		host, fromPath := splitSSHPath(from)
+		_, toPath := splitSSHPath(to)
+		_, err := b.runRemote(host, "mv "+shellQuote(fromPath)+" "+shellQuote(toPath))
+		return err
+	}
+	return os.Rename(from, to)
+}
+
+// resumePartial continues the newest snapshot stopped by a transfer limit:
+// it becomes the snapshot of this run, so rsync only transfers what is still
+// missing.
+func (b *Backup) resumePartial() {
+	if b.config.DryRun {
+		return
+	}
+	partial, err := b.listPartial()
+	if err != nil || len(partial) == 0 {
+		return
+	}
+	name := partial[len(partial)-1]
+	if err := b.moveSnapshotDir(filepath.Join(b.config.Destination, name), b.snapDir); err != nil {
+		b.log("Warning: failed to resume partial snapshot %s: %v", name, err)
+		return
+	}
+	b.log("Resuming partial snapshot %s", name)
+}
+
+// remainingRunTime returns how much of max_run_time is left for the
+// transfer, or the time until the -deadline of the invocation if that
+// comes first. max_run_time counts from the start of the run, including
+// any deferral or wait for the lock.
+func (b *Backup) remainingRunTime() (time.Duration, bool) {
+	if b.config.DryRun {
+		return 0, false
+	}
+	var remaining time.Duration
+	ok := false
+	if maxRunTime, err := ParseDuration(b.config.MaxRunTime); b.config.MaxRunTime != "" && err == nil && maxRunTime > 0 {
+		remaining, ok = maxRunTime-time.Since(b.started), true
+	}
+	if !b.config.Deadline.IsZero() {
+		if untilDeadline := time.Until(b.config.Deadline); !ok || untilDeadline < remaining {
+			remaining, ok = untilDeadline, true
+		}
+	}
+	return remaining, ok
+}
+
+// stopReason names the limit that stopped the transfer.
+func (b *Backup) stopReason() string {
+	if b.timedOut && deadlinePassed(b.config) {
+		return "the deadline " + b.config.Deadline.Format("2006-01-02 15:04")
+	}
+	if b.timedOut {
+		return fmt.Sprintf("max_run_time %s", b.config.MaxRunTime)
+	}
+	name, _ := b.transferLimit()
+	return name
+}
+
+// keepPartial stores the snapshot of a run stopped by the transfer quota,
+// the run time limit or, while seeding, an rsync error for the next run.
+func (b *Backup) keepPartial(limit string) error {
+	if !b.isSSHPath(b.config.Destination) {
+		if _, err := os.Stat(b.snapDir); err != nil {
+			b.log("Transfer stopped after %s before anything was written", limit)
+			return nil
+		}
+	}
+	target := filepath.Join(b.config.Destination, b.timestamp+PartialSuffix)
+	if err := b.moveSnapshotDir(b.snapDir, target); err != nil {
+		return fmt.Errorf("failed to keep partial snapshot: %v", err)
+	}
+	b.log("Transfer stopped after %s; the next run continues %s", limit, filepath.Base(target))
+	return nil
+}
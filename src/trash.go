@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// Directory in the repository that holds pruned snapshots until they are
+// deleted. Moving a snapshot there is a cheap rename; the expensive
+// deletion of millions of hard links happens later at low I/O priority,
+// after trash_retention if set.
+const TrashDir = ".trash"
+
+// trashSnapshot moves a snapshot into the trash area and records when it
+// was trashed. If that fails the snapshot is deleted right away.
+func (b *Backup) trashSnapshot(name string) {
+	trash := filepath.Join(b.config.Destination, TrashDir)
+	backupPath := filepath.Join(b.config.Destination, name)
+	if err := os.MkdirAll(trash, 0755); err == nil {
+		if err := os.Rename(backupPath, filepath.Join(trash, name)); err == nil {
+			os.WriteFile(filepath.Join(trash, name+".trashed"), []byte(time.Now().Format(time.RFC3339)+"\n"), 0644)
+			return
+		}
+	}
+	rm := b.privilegedArgs("rm", "-rf", backupPath)
+	if err := lowPriorityCommand(rm[0], rm[1:]...).Run(); err != nil {
+		b.log("Warning: failed to remove %s: %v", backupPath, err)
+	}
+}
+
+// trashedSnapshot is a snapshot in the trash area.
+type trashedSnapshot struct {
+	Name    string
+	Trashed time.Time // Zero if unknown
+}
+
+// listTrash returns the snapshots of this job in the trash area, longest
+// trashed first. Jobs sharing a repository share its trash area but only
+// purge their own snapshots from it.
+func (b *Backup) listTrash() []trashedSnapshot {
+	trash := filepath.Join(b.config.Destination, TrashDir)
+	entries, err := os.ReadDir(trash)
+	if err != nil {
+		return nil
+	}
+	var trashed []trashedSnapshot
+	for _, entry := range entries {
+		if !entry.IsDir() || !b.ownSnapshot(entry.Name()) {
+			continue
+		}
+		t := trashedSnapshot{Name: entry.Name()}
+		if info, err := os.Stat(filepath.Join(trash, entry.Name()+".trashed")); err == nil {
+			t.Trashed = info.ModTime()
+		}
+		trashed = append(trashed, t)
+	}
+	sort.Slice(trashed, func(i, j int) bool {
+		if !trashed[i].Trashed.Equal(trashed[j].Trashed) {
+			return trashed[i].Trashed.Before(trashed[j].Trashed)
+		}
+		return trashed[i].Name < trashed[j].Name
+	})
+	return trashed
+}
+
+// purgeTrash deletes the snapshots that have been in the trash for longer
+// than trash_retention (all of them without it). Leftovers of an
+// interrupted purge are removed by the next run.
+func (b *Backup) purgeTrash() {
+	if b.config.DryRun {
+		return
+	}
+	var retention time.Duration
+	if b.config.TrashRetention != "" {
+		retention, _ = ParseDuration(b.config.TrashRetention)
+	}
+	kept := 0
+	for _, t := range b.listTrash() {
+		if time.Since(t.Trashed) < retention {
+			kept++
+			continue
+		}
+		reason := "no trash_retention"
+		if retention > 0 {
+			reason = "trash_retention " + b.config.TrashRetention
+		}
+		b.deleteTrashed(t.Name, reason)
+	}
+	if kept > 0 {
+		b.log("Trash: %d pruned snapshots kept for trash_retention %s", kept, b.config.TrashRetention)
+	}
+}
+
+// deleteTrashed deletes a snapshot from the trash area at low CPU and I/O
+// priority, so it does not slow down backups or restores running at the
+// same time. The space actually freed (blocks no longer referenced by any
+// other snapshot) is measured on the filesystem and added to b.reclaimed.
+func (b *Backup) deleteTrashed(name, reason string) {
+	path := filepath.Join(b.config.Destination, TrashDir, name)
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+	before, _ := freeSpace(b.config.Destination)
+	rm := b.privilegedArgs("rm", "-rf", path)
+	if err := lowPriorityCommand(rm[0], rm[1:]...).Run(); err != nil {
+		b.log("Warning: failed to delete %s: %v", path, err)
+		return
+	}
+	os.Remove(path + ".trashed")
+	after, _ := freeSpace(b.config.Destination)
+
+	// Other writers on the filesystem can make the difference negative
+	freed := max(after-before, 0)
+	b.reclaimed += freed
+	b.log("Deleted pruned snapshot %s (freed %.2f GB)", name, gib(freed))
+	b.audit("delete", name, reason)
+}
+
+// lowPriorityCommand runs a command with idle I/O priority (ionice on
+// Linux, background policy on macOS) and lowest CPU priority where these
+// tools are available.
+func lowPriorityCommand(name string, args ...string) *exec.Cmd {
+	switch {
+	case runtime.GOOS == "linux" && commandExists("ionice"):
+		return exec.Command("ionice", append([]string{"-c3", "nice", "-n19", name}, args...)...)
+	case runtime.GOOS == "darwin" && commandExists("taskpolicy"):
+		return exec.Command("taskpolicy", append([]string{"-b", name}, args...)...)
+	case commandExists("nice"):
+		return exec.Command("nice", append([]string{"-n19", name}, args...)...)
+	}
+	return exec.Command(name, args...)
+}
+
+// commandExists reports whether a command is found in PATH.
+func commandExists(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
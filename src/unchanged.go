@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// sourceUnchanged runs rsync in dry-run mode against the previous snapshot
+// and reports whether the new snapshot would be identical to it. The
+// snapshot metadata is not part of the source and does not count.
+func (b *Backup) sourceUnchanged(lastBackup string) (bool, error) {
+	changes, err := b.dryRunAgainst(lastBackup)
+	if err != nil {
+		return false, err
+	}
+	return len(changes) == 0, nil
+}
+
+// dryRunAgainst runs rsync in dry-run mode with a snapshot as destination
+// and returns what a backup into it would change, without the snapshot
+// metadata.
+func (b *Backup) dryRunAgainst(snapshot string) ([]itemizedChange, error) {
+	output, err := b.dryRunOutput(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	var changes []itemizedChange
+	for _, c := range parseItemized(output) {
+		if c.Path != SnapshotMetaDir && !strings.HasPrefix(c.Path, SnapshotMetaDir+"/") {
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
+
+// dryRunOutput returns the output (itemized changes and statistics) of a
+// backup into a snapshot in dry-run mode.
+func (b *Backup) dryRunOutput(snapshot string) (string, error) {
+	args := b.buildRsyncArgs(snapshot)
+	var check []string
+	for _, arg := range args[:len(args)-1] {
+		if strings.HasPrefix(arg, "--link-dest=") || arg == "--progress" || arg == "--dry-run" {
+			continue
+		}
+		check = append(check, arg)
+	}
+	check = append(check, "--dry-run", filepath.Join(b.config.Destination, snapshot)+"/")
+
+	cmd := b.rsyncCommand(check...)
+	cmd.Env = b.childEnv()
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("rsync dry run failed: %v", err)
+	}
+	return string(output), nil
+}
+
+// lastUnchanged returns when a run last found the source identical to the
+// given snapshot, from the history file, or the zero time.
+func (b *Backup) lastUnchanged(name string) time.Time {
+	if b.config.HistoryFile == "" {
+		return time.Time{}
+	}
+	f, err := os.Open(b.config.HistoryFile)
+	if err != nil {
+		return time.Time{}
+	}
+	defer f.Close()
+
+	var last time.Time
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if !slices.Contains(fields, "status=unchanged") || !slices.Contains(fields, "snapshot="+name) {
+			continue
+		}
+		for _, field := range fields {
+			if value, ok := strings.CutPrefix(field, "time="); ok {
+				if t, err := time.Parse(time.RFC3339, value); err == nil {
+					last = t
+				}
+			}
+		}
+	}
+	return last
+}
@@ -3,21 +3,114 @@ package main
 const (
 	AppName    = "Go-Rsync-Backup"
 	AppVersion = "1.0.1"
+	AppDir     = "go-rsync-backup.d" // Next to the executable, holds the rsync from install-rsync
 )
 
 // Default configuration values
 var DefaultConfig = Config{
 	Source:           "/Volumes/external-0",
+	Sources:          nil,
 	Destination:      "/Volumes/backup-0/backups",
 	Keep:             30,
 	CleanupAtPercent: 95,
 	ExcludeList:      "/Volumes/external-0/.backup-exclude.list",
 	LogFile:          "/Volumes/backup-0/backups/backup.log",
+	HistoryFile:      "",
+	AuditLog:         "",
+	APISocket:        "/var/run/go-rsync-backup/api.sock",
 	LockFile:         "/tmp/backupRunningLock",
 	DryRun:           false,
 	ForceSystemRsync: false,
 	ShowProgress:     true,
 	RsyncBin:         "",
+	MinRsyncVersion:  "",
+
+	ExcludeBackupStores: false,
+
+	PrivilegedCommand: "",
+
+	CPUQuota:  "",
+	IOWeight:  0,
+	MemoryMax: "",
+
+	SSHAddressFamily: "",
+
+	Compress:      "",
+	CompressLevel: 0,
+	CompressMedia: false,
+
+	SigningKey:       "",
+	SigningPublicKey: "",
+
+	ChangeCache:      "",
+	FullScanInterval: "",
+
+	RsyncOutputDir:       "",
+	RsyncOutputRetention: "",
+
+	APFSSnapshot: false,
+	QuiesceApps:  nil,
+
+	SafetyDryRun:     false,
+	MaxDeletePercent: 0,
+
+	VerifySampleFiles:  0,
+	VerifySampleHash:   false,
+	VerifyChangedFiles: false,
+
+	VerifyWorkers:   1,
+	VerifyRateLimit: "",
+
+	MaxRepositorySize: "",
+	MinFreeSpace:      "",
+	MaxTransferPerRun: "",
+	MaxRunTime:        "",
+	Thinning:          nil,
+	TrashRetention:    "",
+
+	MaxSnapshotAge: "",
+	MinInterval:    "",
+	SkipUnchanged:  false,
+
+	MaxLoad:       0,
+	MaxIOPressure: 0,
+	MinIdle:       "",
+	DeferMaxWait:  "",
+
+	PerHostLayout:   false,
+	SnapshotPrefix:  "",
+	RepositoryOwner: "",
+
+	CopyLinks:       false,
+	KeepDirlinks:    false,
+	PreserveCrtimes: false,
+	LinkDestCount:   0,
+	DeleteMode:      "",
+	KeepExcluded:    false,
+
+	MinSourceFiles: 0,
+	CanaryFile:     "",
+
+	Assertions: nil,
+
+	Env: nil,
+
+	OffsiteDestination: "",
+	SeedRepository:     "",
+	SeedingMode:        false,
+	SeedTransferCap:    "",
+
+	MaxChangedPercent: 0,
+	AlertCommand:      "",
+
+	RetentionWarningDays: 0,
+
+	SnapshotLog:    false,
+	SystemManifest: false,
+
+	AllowIndexing:               false,
+	EjectAfterBackup:            false,
+	RequireEncryptedDestination: false,
 }
 
 // Base rsync arguments with comments
@@ -41,9 +134,15 @@ var RsyncMacOSArgs = []string{
 	"--fileflags", // Preserve file flags (macOS specific)
 }
 
+// SSH options used for rsync transfers and remote commands
+var SSHArgs = []string{
+	"-o", "StrictHostKeyChecking=no",
+	"-o", "UserKnownHostsFile=/dev/null",
+	"-o", "ConnectTimeout=30", // Try the next address of a host with several in time
+}
+
 // SSH-specific rsync arguments
 var RsyncSSHArgs = []string{
-	"-z",                                                                    // Compress file data during transfer
-	"--compress-level=6",                                                    // Compression level (1-9, 6 is good balance)
-	"-e", "ssh -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null", // SSH options
+	"-z",                 // Compress file data during transfer
+	"--compress-level=6", // Compression level (1-9, 6 is good balance)
 }
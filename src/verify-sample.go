@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+)
+
+// verifySample compares a random sample of files in the snapshot against
+// the live source (size, mtime and optionally a SHA-256 hash). Files that
+// changed or vanished in the source since the run started are skipped.
+func (b *Backup) verifySample() error {
+	if b.config.VerifySampleFiles <= 0 || b.config.DryRun {
+		return nil
+	}
+	if b.hasSSHSource() || b.isSSHPath(b.config.Destination) {
+		b.log("Sample verification skipped for remote paths")
+		return nil
+	}
+
+	sample := b.sampleFiles(b.snapDir, b.config.VerifySampleFiles)
+	checked, skipped, mismatches := b.compareWithSource(sample, b.config.VerifySampleHash)
+
+	b.log("Sample verification: %d files checked, %d skipped, %d mismatches", checked, skipped, len(mismatches))
+	if len(mismatches) > 0 {
+		for _, m := range mismatches {
+			b.log("  %s", m)
+		}
+		return fmt.Errorf("%d of %d sampled files differ from the source", len(mismatches), checked)
+	}
+	return nil
+}
+
+// verifyChanged compares every file rsync reported as transferred against
+// the live source (size, mtime and SHA-256). Unlike the random sample it
+// covers exactly the data written by this run.
+func (b *Backup) verifyChanged() error {
+	if !b.config.VerifyChangedFiles || b.config.DryRun {
+		return nil
+	}
+	if b.hasSSHSource() || b.isSSHPath(b.config.Destination) {
+		b.log("Changed-file verification skipped for remote paths")
+		return nil
+	}
+
+	var files []string
+	for _, c := range b.changes {
+		if c.transferred() {
+			files = append(files, c.Path)
+		}
+	}
+	checked, skipped, mismatches := b.compareWithSource(files, true)
+
+	b.log("Changed-file verification: %d files checked, %d skipped, %d mismatches", checked, skipped, len(mismatches))
+	if len(mismatches) > 0 {
+		for _, m := range mismatches {
+			b.log("  %s", m)
+		}
+		return fmt.Errorf("%d of %d transferred files differ from the source", len(mismatches), checked)
+	}
+	return nil
+}
+
+// compareWithSource compares files of the snapshot, given relative to its
+// root, against the source. Files that changed or vanished in the source
+// since the run started are skipped. Files are compared by verify_workers
+// workers, reading at most verify_rate_limit per second together.
+func (b *Backup) compareWithSource(files []string, hash bool) (checked, skipped int, mismatches []string) {
+	limiter := b.newRateLimiter()
+	results := make([]fileCheck, len(files))
+	b.forEachParallel(len(files), func(i int) {
+		results[i] = b.compareFile(files[i], hash, limiter)
+	})
+
+	for _, r := range results {
+		if r.skipped {
+			skipped++
+			continue
+		}
+		checked++
+		if r.mismatch != "" {
+			mismatches = append(mismatches, r.mismatch)
+		}
+	}
+	return checked, skipped, mismatches
+}
+
+// fileCheck is the result of comparing one file with the source.
+type fileCheck struct {
+	skipped  bool
+	mismatch string
+}
+
+// compareFile compares one file of the snapshot against the source.
+func (b *Backup) compareFile(rel string, hash bool, limiter *rateLimiter) fileCheck {
+	srcInfo, err := os.Stat(b.sourcePathFor(rel))
+	if err != nil || srcInfo.ModTime().After(b.started) {
+		return fileCheck{skipped: true} // Deleted or modified since the backup started
+	}
+	snapInfo, err := os.Stat(filepath.Join(b.snapDir, rel))
+	if err != nil {
+		return fileCheck{mismatch: fmt.Sprintf("%s: %v", rel, err)}
+	}
+
+	if srcInfo.Size() != snapInfo.Size() {
+		return fileCheck{mismatch: fmt.Sprintf("%s: size %d != %d", rel, snapInfo.Size(), srcInfo.Size())}
+	}
+	if srcInfo.ModTime().Unix() != snapInfo.ModTime().Unix() {
+		return fileCheck{mismatch: fmt.Sprintf("%s: mtime %s != %s", rel, snapInfo.ModTime(), srcInfo.ModTime())}
+	}
+	if hash {
+		srcHash, err := hashFileLimited(b.sourcePathFor(rel), limiter)
+		if err != nil {
+			return fileCheck{skipped: true}
+		}
+		snapHash, err := hashFileLimited(filepath.Join(b.snapDir, rel), limiter)
+		if err != nil || srcHash != snapHash {
+			return fileCheck{mismatch: fmt.Sprintf("%s: checksum mismatch", rel)}
+		}
+	}
+	return fileCheck{}
+}
+
+// sampleFiles returns up to n randomly chosen regular files below dir as
+// paths relative to dir (reservoir sampling, so the tree is walked once).
+func (b *Backup) sampleFiles(dir string, n int) []string {
+	var sample []string
+	seen := 0
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() && d.Name() == SnapshotMetaDir && filepath.Dir(path) == dir {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(dir, path)
+		seen++
+		if len(sample) < n {
+			sample = append(sample, rel)
+		} else if i := rand.IntN(seen); i < n {
+			sample[i] = rel
+		}
+		return nil
+	})
+	return sample
+}
+
+// hashFile returns the hex encoded SHA-256 of a file.
+func hashFile(path string) (string, error) {
+	return hashFileLimited(path, nil)
+}
+
+// hashFileLimited is hashFile reading through a rate limiter.
+func hashFileLimited(path string, limiter *rateLimiter) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, limitedReader{f, limiter}); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}